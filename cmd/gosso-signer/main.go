@@ -0,0 +1,56 @@
+// gosso-signer holds token signing key and serves signing requests over Unix socket,
+// so GoSSO API process never holds key material.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/signer"
+)
+
+func main() {
+	keyPath := flag.String("key", "", "PEM encoded private key file")
+	socketPath := flag.String("socket", "/run/gosso/signer.sock", "Unix socket path")
+	flag.Parse()
+
+	if *keyPath == "" {
+		log.Fatal("-key is required")
+	}
+
+	key, err := keystore.LoadPrivateKey(*keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	k, err := keystore.NewKey(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv, err := signer.NewServer(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	l, err := signer.Listen(*socketPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		_ = l.Close()
+	}()
+
+	log.Printf("serving %s key %s on %s", k.Algorithm, k.ID, *socketPath)
+	err = srv.Serve(l)
+	_ = os.Remove(*socketPath)
+	log.Println(err)
+}
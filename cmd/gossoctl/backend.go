@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/api/user"
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/setup"
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// backend manages users either directly on bolt file or through REST API
+type backend interface {
+	// ListUsers returns every user matching query, following page cursors
+	ListUsers(q auth.UserQuery) ([]auth.User, error)
+	GetUser(ref string) (*auth.User, error)
+	CreateUser(username, password string, perms []permission.Permission) (uuid.UUID, error)
+	DeleteUser(id uuid.UUID) error
+	UpdateCredential(id uuid.UUID, username, password string) error
+	SetPermissions(id uuid.UUID, perms []permission.Permission) error
+	SetStatus(id uuid.UUID, status auth.AccountStatus) error
+	Bootstrap(username, password string) (uuid.UUID, error)
+	Backup(w io.Writer) error
+	Export() (*auth.Export, error)
+	Import(e *auth.Export, mode auth.ConflictMode) (auth.ImportResult, error)
+	Close() error
+}
+
+// responseError is non-2xx response of REST API
+type responseError struct {
+	status int
+	msg    string
+}
+
+func (e responseError) Error() string {
+	return e.msg
+}
+
+type boltBackend struct {
+	ds *auth.BoltStore
+}
+
+func newBoltBackend(path string) (*boltBackend, error) {
+	ds, err := auth.OpenDataStore(path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s (is GoSSO running? use -url instead): %w", path, err)
+	}
+	return &boltBackend{ds: ds}, nil
+}
+
+func (b boltBackend) ListUsers(q auth.UserQuery) ([]auth.User, error) {
+	users := make([]auth.User, 0)
+	for {
+		p, err := b.ds.QueryUsers(q)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, p.Users...)
+		if p.NextCursor == "" {
+			return users, nil
+		}
+		q.Cursor = p.NextCursor
+	}
+}
+
+func (b boltBackend) GetUser(ref string) (*auth.User, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		u, err := b.ds.GetUserByID(id)
+		if err != auth.ErrNotFound {
+			return u, err
+		}
+	}
+
+	u, err := b.ds.GetUserByUsername(ref)
+	if err == auth.ErrNotFound {
+		return nil, errUserNotFound
+	}
+	return u, err
+}
+
+func (b boltBackend) CreateUser(username, password string, perms []permission.Permission) (uuid.UUID, error) {
+	hp, err := auth.HashPassword(password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	u := &auth.User{
+		ID:          uuid.New(),
+		Username:    username,
+		Password:    hp,
+		Permissions: perms,
+	}
+
+	return u.ID, b.ds.AddUser(u)
+}
+
+func (b boltBackend) DeleteUser(id uuid.UUID) error {
+	return b.ds.DeleteUser(&auth.User{ID: id})
+}
+
+func (b boltBackend) UpdateCredential(id uuid.UUID, username, password string) error {
+	u, err := b.ds.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	if username != "" {
+		u.Username = username
+	}
+
+	if password != "" {
+		u.Password, err = auth.HashPassword(password)
+		if err != nil {
+			return err
+		}
+	}
+
+	u.RotateSecurityStamp()
+	return b.ds.UpdateUser(u)
+}
+
+func (b boltBackend) SetPermissions(id uuid.UUID, perms []permission.Permission) error {
+	u, err := b.ds.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	u.Permissions = perms
+	u.RotateSecurityStamp()
+	return b.ds.UpdateUser(u)
+}
+
+func (b boltBackend) SetStatus(id uuid.UUID, status auth.AccountStatus) error {
+	u, err := b.ds.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	u.Status = status
+	u.RotateSecurityStamp()
+	return b.ds.UpdateUser(u)
+}
+
+func (b boltBackend) Bootstrap(username, password string) (uuid.UUID, error) {
+	u, err := setup.CreateAdmin(b.ds, username, password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return u.ID, nil
+}
+
+func (b boltBackend) Backup(w io.Writer) error {
+	return b.ds.Backup(w)
+}
+
+func (b boltBackend) Export() (*auth.Export, error) {
+	return auth.ExportDataStore(b.ds)
+}
+
+func (b boltBackend) Import(e *auth.Export, mode auth.ConflictMode) (auth.ImportResult, error) {
+	return auth.ImportDataStore(b.ds, e, mode)
+}
+
+func (b boltBackend) Close() error {
+	return b.ds.Close()
+}
+
+type restBackend struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newRestBackend(url, token string) *restBackend {
+	return &restBackend{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// request sends JSON request and returns successful response
+func (r restBackend) request(method, path string, in interface{}) (*http.Response, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, r.url+path, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, application/octet-stream")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+
+		var e apierror.Response
+		if json.Unmarshal(msg, &e) == nil && e.Code != "" {
+			return nil, responseError{res.StatusCode, fmt.Sprintf("%s %s: %s: %s (%s, request %s)", method, path, res.Status, e.Message, e.Code, e.RequestID)}
+		}
+		return nil, responseError{res.StatusCode, fmt.Sprintf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))}
+	}
+
+	return res, nil
+}
+
+// do sends JSON request and decodes JSON response into out if not nil
+func (r restBackend) do(method, path string, in, out interface{}) error {
+	res, err := r.request(method, path, in)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (r restBackend) ListUsers(q auth.UserQuery) ([]auth.User, error) {
+	v := url.Values{}
+	v.Set("limit", strconv.Itoa(auth.MaxPageSize))
+	if q.Prefix != "" {
+		v.Set("prefix", q.Prefix)
+	}
+	if q.Permission != nil {
+		v.Set("permission", q.Permission.String())
+	}
+	if q.Sort != "" {
+		v.Set("sort", string(q.Sort))
+	}
+
+	users := make([]auth.User, 0)
+	for {
+		res, err := r.request("GET", "/user/?"+v.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]auth.User, 0)
+		err = json.NewDecoder(res.Body).Decode(&page)
+		_ = res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, page...)
+
+		next := res.Header.Get(user.NextCursorHeader)
+		if next == "" {
+			return users, nil
+		}
+		v.Set("cursor", next)
+	}
+}
+
+// GetUser fetches user by ID if ref is UUID, and otherwise looks up username by prefix.
+// Username equal to prefix sorts before every other match, so first user of page is enough.
+func (r restBackend) GetUser(ref string) (*auth.User, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		u := new(auth.User)
+		err := r.do("GET", "/user/"+id.String(), nil, u)
+		if err == nil {
+			return u, nil
+		}
+
+		// Username may look like UUID, so missing ID falls back to username
+		var rErr responseError
+		if !errors.As(err, &rErr) || rErr.status != http.StatusNotFound {
+			return nil, err
+		}
+	}
+
+	v := url.Values{}
+	v.Set("prefix", ref)
+	v.Set("sort", string(auth.SortByUsername))
+	v.Set("limit", "1")
+
+	users := make([]auth.User, 0)
+	if err := r.do("GET", "/user/?"+v.Encode(), nil, &users); err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 || users[0].Username != ref {
+		return nil, errUserNotFound
+	}
+	return &users[0], nil
+}
+
+// userInfo matches request body of user API
+type userInfo struct {
+	Username    string                  `json:"username,omitempty"`
+	Password    string                  `json:"password,omitempty"`
+	Permissions []permission.Permission `json:"permissions,omitempty"`
+}
+
+func (r restBackend) CreateUser(username, password string, perms []permission.Permission) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.do("POST", "/user/", userInfo{Username: username, Password: password, Permissions: perms}, &id)
+	return id, err
+}
+
+func (r restBackend) DeleteUser(id uuid.UUID) error {
+	return r.do("DELETE", "/user/"+id.String(), nil, nil)
+}
+
+func (r restBackend) UpdateCredential(id uuid.UUID, username, password string) error {
+	return r.do("POST", "/user/"+id.String()+"/credential", userInfo{Username: username, Password: password}, nil)
+}
+
+func (r restBackend) SetPermissions(id uuid.UUID, perms []permission.Permission) error {
+	return r.do("POST", "/user/"+id.String()+"/permissions", perms, nil)
+}
+
+func (r restBackend) SetStatus(id uuid.UUID, status auth.AccountStatus) error {
+	return r.do("PUT", "/user/"+id.String()+"/status", status, nil)
+}
+
+// Bootstrap uses token as one-time setup token
+func (r restBackend) Bootstrap(username, password string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.do("POST", "/setup/", map[string]string{
+		"token":    r.token,
+		"username": username,
+		"password": password,
+	}, &id)
+	return id, err
+}
+
+func (r restBackend) Backup(w io.Writer) error {
+	res, err := r.request("GET", "/admin/backup", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (r restBackend) Export() (*auth.Export, error) {
+	e := new(auth.Export)
+	return e, r.do("GET", "/admin/export", nil, e)
+}
+
+func (r restBackend) Import(e *auth.Export, mode auth.ConflictMode) (auth.ImportResult, error) {
+	var result auth.ImportResult
+	err := r.do("POST", "/admin/import?conflict="+url.QueryEscape(string(mode)), e, &result)
+	return result, err
+}
+
+func (r restBackend) Close() error {
+	return nil
+}
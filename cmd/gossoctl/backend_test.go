@@ -0,0 +1,155 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/api/user"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/setup"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+func testBackend(t *testing.T, b backend) {
+	admin, err := b.Bootstrap("admin", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Bootstrap("other", "password"); err == nil {
+		t.Error("Expected second bootstrap to fail")
+	}
+
+	p, err := permission.FromString("+:gosso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := b.CreateUser("hello", "world", []permission.Permission{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := b.GetUser("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := b.ListUsers(auth.UserQuery{Prefix: "hel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 1 || users[0].ID != id {
+		t.Errorf("Expected [hello] but got %+v", users)
+	}
+
+	if u.ID != id {
+		t.Errorf("Expected %s but got %s", id, u.ID)
+	}
+
+	for _, ref := range []string{"hel", uuid.New().String()} {
+		if _, err := b.GetUser(ref); err != errUserNotFound {
+			t.Errorf("%s: Expected errUserNotFound but got %v", ref, err)
+		}
+	}
+
+	err = b.UpdateCredential(id, "hola", "")
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err = b.GetUser(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Username != "hola" {
+		t.Errorf("Expected hola but got %s", u.Username)
+	}
+
+	err = b.SetPermissions(id, []permission.Permission{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err = b.GetUser("hola")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(u.Permissions) != 0 {
+		t.Errorf("Expected no permissions but got %v", u.Permissions)
+	}
+
+	err = b.SetStatus(id, auth.AccountStatus{State: auth.StateDisabled, Reason: "left"})
+	if err != nil {
+		t.Error(err)
+	}
+
+	u, err = b.GetUser("hola")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Status.Check(time.Now()) != auth.ErrAccountDisabled || u.Status.Reason != "left" {
+		t.Errorf("Expected disabled account but got %+v", u.Status)
+	}
+
+	err = b.DeleteUser(id)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := b.GetUser("hola"); err != errUserNotFound {
+		t.Errorf("Expected errUserNotFound but got %v", err)
+	}
+
+	if _, err := b.GetUser(admin.String()); err != nil {
+		t.Error(err)
+	}
+}
+
+func createTempDS(t *testing.T) string {
+	testDir, err := ioutil.TempDir("", "gossoctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(testDir, "test.db")
+}
+
+func TestBoltBackend(t *testing.T) {
+	b, err := newBoltBackend(createTempDS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Close() }()
+
+	testBackend(t, b)
+}
+
+func TestRestBackend(t *testing.T) {
+	ds, err := auth.NewDataStore(createTempDS(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := setup.Init(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := restful.NewContainer()
+	c.Add(user.New(ds).WebService())
+	c.Add(s.WebService())
+
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	testBackend(t, newRestBackend(srv.URL, s.Token()))
+}
@@ -0,0 +1,737 @@
+// gossoctl manages GoSSO users, permissions and signing keys.
+// User commands work directly on bolt file (-db) or through REST API (-url).
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/permission"
+)
+
+const usage = `Usage: gossoctl [-db file | -url url [-token token]] command [arguments]
+
+User commands:
+  admin bootstrap [-password password] username
+  user list [-prefix prefix] [-perm permission] [-sort sort]
+  user create [-password password] [-perm permission]... username
+  user delete user
+  user rename user new-username
+  user passwd [-password password] user
+  user disable [-reason reason] [-until time] user
+  user enable user
+  perm grant user permission...
+  perm revoke user permission...
+
+Database commands:
+  db migrate [-dry-run] [-backup file]
+  db backup -out file
+  db restore -in file
+  db export [-out file]
+  db import [-conflict skip|overwrite|fail] file
+
+Key commands:
+  key generate [-alg algorithm] -out file
+  key rotate [-alg algorithm] -dir dir
+  key list -dir dir
+  key remove -dir dir kid
+
+admin bootstrap creates admin account of instance without users.
+With -url, -token is setup token printed at GoSSO startup.
+db backup, export and import work with -url while GoSSO is running.
+db migrate and restore require -db and stopped GoSSO.
+
+user is UUID or username. Password is read from stdin if -password is omitted.
+Time is RFC 3339, such as 2006-01-02T15:04:05Z.
+`
+
+type command struct {
+	args    []string
+	db      string
+	backend func() (backend, error)
+}
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		_, _ = fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	db := flag.String("db", "", "bolt database file")
+	url := flag.String("url", "", "GoSSO URL")
+	token := flag.String("token", os.Getenv("GOSSO_TOKEN"), "access token for REST API (default $GOSSO_TOKEN)")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := command{
+		args: flag.Args()[2:],
+		db:   *db,
+		backend: func() (backend, error) {
+			switch {
+			case *db != "" && *url != "":
+				return nil, fmt.Errorf("-db and -url are mutually exclusive")
+			case *db != "":
+				return newBoltBackend(*db)
+			case *url != "":
+				return newRestBackend(*url, *token), nil
+			}
+			return nil, fmt.Errorf("-db or -url is required")
+		},
+	}
+
+	var err error
+	switch flag.Arg(0) + " " + flag.Arg(1) {
+	case "admin bootstrap":
+		err = c.adminBootstrap()
+	case "user list":
+		err = c.userList()
+	case "user create":
+		err = c.userCreate()
+	case "user delete":
+		err = c.userDelete()
+	case "user rename":
+		err = c.userRename()
+	case "user passwd":
+		err = c.userPasswd()
+	case "user disable":
+		err = c.userDisable()
+	case "user enable":
+		err = c.userEnable()
+	case "perm grant":
+		err = c.permGrant()
+	case "perm revoke":
+		err = c.permRevoke()
+	case "db migrate":
+		err = c.dbMigrate()
+	case "db backup":
+		err = c.dbBackup()
+	case "db restore":
+		err = c.dbRestore()
+	case "db export":
+		err = c.dbExport()
+	case "db import":
+		err = c.dbImport()
+	case "key generate":
+		err = c.keyGenerate()
+	case "key rotate":
+		err = c.keyRotate()
+	case "key list":
+		err = c.keyList()
+	case "key remove":
+		err = c.keyRemove()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "gossoctl:", err)
+		os.Exit(1)
+	}
+}
+
+// parse parses command flags and checks number of positional arguments
+func (c *command) parse(fs *flag.FlagSet, nArgs int, argsUsage string) error {
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: gossoctl %s %s\n", fs.Name(), argsUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(c.args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != nArgs && nArgs >= 0 {
+		fs.Usage()
+		return fmt.Errorf("expected %d arguments but got %d", nArgs, fs.NArg())
+	}
+
+	if nArgs < 0 && fs.NArg() < -nArgs {
+		fs.Usage()
+		return fmt.Errorf("expected at least %d arguments but got %d", -nArgs, fs.NArg())
+	}
+
+	c.args = fs.Args()
+	return nil
+}
+
+// withBackend runs f with opened backend
+func (c *command) withBackend(f func(b backend) error) error {
+	b, err := c.backend()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = b.Close() }()
+
+	return f(b)
+}
+
+func readPassword() (string, error) {
+	_, _ = fmt.Fprint(os.Stderr, "Password: ")
+	p, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && p == "" {
+		return "", err
+	}
+
+	p = strings.TrimRight(p, "\r\n")
+	if p == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	return p, nil
+}
+
+func parsePermissions(s []string) ([]permission.Permission, error) {
+	perms := make([]permission.Permission, 0, len(s))
+	for _, v := range s {
+		p, err := permission.FromString(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v, err)
+		}
+		perms = append(perms, p)
+	}
+	return perms, nil
+}
+
+func (c *command) adminBootstrap() error {
+	fs := flag.NewFlagSet("admin bootstrap", flag.ExitOnError)
+	password := fs.String("password", "", "password")
+	if err := c.parse(fs, 1, "username"); err != nil {
+		return err
+	}
+
+	var err error
+	if *password == "" {
+		*password, err = readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.withBackend(func(b backend) error {
+		id, err := b.Bootstrap(c.args[0], *password)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(id)
+		return nil
+	})
+}
+
+func (c *command) userList() error {
+	fs := flag.NewFlagSet("user list", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "username prefix")
+	perm := fs.String("perm", "", "permission granted by user permissions")
+	sort := fs.String("sort", string(auth.SortByUsername), "sort order: username, -username, id or -id")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	q := auth.UserQuery{
+		Prefix: *prefix,
+		Sort:   auth.UserSort(*sort),
+	}
+
+	if *perm != "" {
+		p, err := permission.FromString(*perm)
+		if err != nil {
+			return err
+		}
+		q.Permission = &p
+	}
+
+	return c.withBackend(func(b backend) error {
+		users, err := b.ListUsers(q)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tUSERNAME\tSTATUS\tPERMISSIONS")
+		for _, u := range users {
+			perms := make([]string, len(u.Permissions))
+			for i, p := range u.Permissions {
+				perms[i] = p.String()
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.ID, u.Username, statusString(u.Status), strings.Join(perms, " "))
+		}
+		return w.Flush()
+	})
+}
+
+func (c *command) userCreate() error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	password := fs.String("password", "", "password")
+	var perms stringList
+	fs.Var(&perms, "perm", "permission, can be repeated")
+	if err := c.parse(fs, 1, "username"); err != nil {
+		return err
+	}
+
+	p, err := parsePermissions(perms)
+	if err != nil {
+		return err
+	}
+
+	if *password == "" {
+		*password, err = readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.withBackend(func(b backend) error {
+		id, err := b.CreateUser(c.args[0], *password, p)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(id)
+		return nil
+	})
+}
+
+func (c *command) userDelete() error {
+	if err := c.parse(flag.NewFlagSet("user delete", flag.ExitOnError), 1, "user"); err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+		return b.DeleteUser(u.ID)
+	})
+}
+
+func (c *command) userRename() error {
+	if err := c.parse(flag.NewFlagSet("user rename", flag.ExitOnError), 2, "user new-username"); err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+		return b.UpdateCredential(u.ID, c.args[1], "")
+	})
+}
+
+func (c *command) userPasswd() error {
+	fs := flag.NewFlagSet("user passwd", flag.ExitOnError)
+	password := fs.String("password", "", "new password")
+	if err := c.parse(fs, 1, "user"); err != nil {
+		return err
+	}
+
+	var err error
+	if *password == "" {
+		*password, err = readPassword()
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+		return b.UpdateCredential(u.ID, "", *password)
+	})
+}
+
+// statusString returns why account is inactive, or active
+func statusString(s auth.AccountStatus) string {
+	switch s.Check(time.Now()) {
+	case auth.ErrAccountDisabled:
+		return string(auth.StateDisabled)
+	case auth.ErrAccountLocked:
+		return string(auth.StateLocked)
+	case auth.ErrAccountExpired:
+		return "expired"
+	}
+	return string(auth.StateActive)
+}
+
+func (c *command) userDisable() error {
+	fs := flag.NewFlagSet("user disable", flag.ExitOnError)
+	reason := fs.String("reason", "", "reason shown to admins")
+	until := fs.String("until", "", "time account is re-enabled automatically")
+	if err := c.parse(fs, 1, "user"); err != nil {
+		return err
+	}
+
+	var untilTime *time.Time
+	if *until != "" {
+		t, err := time.Parse(time.RFC3339, *until)
+		if err != nil {
+			return err
+		}
+		untilTime = &t
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+
+		return b.SetStatus(u.ID, u.Status.Disable(*reason, untilTime))
+	})
+}
+
+func (c *command) userEnable() error {
+	if err := c.parse(flag.NewFlagSet("user enable", flag.ExitOnError), 1, "user"); err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+
+		return b.SetStatus(u.ID, u.Status.Enable(time.Now()))
+	})
+}
+
+func (c *command) permGrant() error {
+	if err := c.parse(flag.NewFlagSet("perm grant", flag.ExitOnError), -2, "user permission..."); err != nil {
+		return err
+	}
+
+	perms, err := parsePermissions(c.args[1:])
+	if err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+
+		updated := append(make([]permission.Permission, 0), u.Permissions...)
+		for _, p := range perms {
+			if !containsPermission(updated, p) {
+				updated = append(updated, p)
+			}
+		}
+
+		return b.SetPermissions(u.ID, updated)
+	})
+}
+
+func (c *command) permRevoke() error {
+	if err := c.parse(flag.NewFlagSet("perm revoke", flag.ExitOnError), -2, "user permission..."); err != nil {
+		return err
+	}
+
+	perms, err := parsePermissions(c.args[1:])
+	if err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		u, err := b.GetUser(c.args[0])
+		if err != nil {
+			return err
+		}
+
+		updated := make([]permission.Permission, 0)
+		for _, p := range u.Permissions {
+			if !containsPermission(perms, p) {
+				updated = append(updated, p)
+			}
+		}
+
+		return b.SetPermissions(u.ID, updated)
+	})
+}
+
+func containsPermission(perms []permission.Permission, p permission.Permission) bool {
+	for _, v := range perms {
+		if v.Equals(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *command) dbMigrate() error {
+	fs := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "list pending migrations without applying them")
+	backup := fs.String("backup", "", "copy database to file before migrating")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if c.db == "" {
+		return fmt.Errorf("-db is required")
+	}
+
+	ds, err := auth.OpenDataStore(c.db, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("opening %s (is GoSSO running?): %w", c.db, err)
+	}
+	defer func() { _ = ds.Close() }()
+
+	migrations, err := auth.Migrate(ds, auth.MigrateOptions{
+		DryRun:     *dryRun,
+		BackupPath: *backup,
+	})
+
+	for _, m := range migrations {
+		if *dryRun {
+			fmt.Printf("pending %d: %s\n", m.Version, m.Description)
+		} else {
+			fmt.Printf("applied %d: %s\n", m.Version, m.Description)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	v, err := ds.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("schema version %d\n", v)
+	return nil
+}
+
+func (c *command) dbBackup() error {
+	fs := flag.NewFlagSet("db backup", flag.ExitOnError)
+	out := fs.String("out", "", "backup file")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	return c.withBackend(func(b backend) error {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+
+		err = b.Backup(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			_ = os.Remove(*out)
+		}
+		return err
+	})
+}
+
+func (c *command) dbRestore() error {
+	fs := flag.NewFlagSet("db restore", flag.ExitOnError)
+	in := fs.String("in", "", "backup file")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+
+	if c.db == "" {
+		return fmt.Errorf("-db is required")
+	}
+
+	return auth.RestoreBoltFile(*in, c.db, 5*time.Second)
+}
+
+func (c *command) dbExport() error {
+	fs := flag.NewFlagSet("db export", flag.ExitOnError)
+	out := fs.String("out", "", "export file (default stdout)")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		e, err := b.Export()
+		if err != nil {
+			return err
+		}
+
+		w := os.Stdout
+		if *out != "" {
+			w, err = os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(e)
+		if w != os.Stdout {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	})
+}
+
+func (c *command) dbImport() error {
+	fs := flag.NewFlagSet("db import", flag.ExitOnError)
+	conflict := fs.String("conflict", string(auth.ConflictFail), "existing record handling: skip, overwrite or fail")
+	if err := c.parse(fs, 1, "file"); err != nil {
+		return err
+	}
+
+	mode, err := auth.ParseConflictMode(*conflict)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.args[0])
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	e := new(auth.Export)
+	if err := json.NewDecoder(f).Decode(e); err != nil {
+		return err
+	}
+
+	return c.withBackend(func(b backend) error {
+		r, err := b.Import(e, mode)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created %d, overwritten %d, skipped %d\n", r.Created, r.Overwritten, r.Skipped)
+		return nil
+	})
+}
+
+func (c *command) keyGenerate() error {
+	fs := flag.NewFlagSet("key generate", flag.ExitOnError)
+	alg := fs.String("alg", "ES256", "signing algorithm: "+strings.Join(keystore.Algorithms, ", "))
+	out := fs.String("out", "", "private key file")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	pk, err := keystore.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+
+	k, err := keystore.NewKey(pk)
+	if err != nil {
+		return err
+	}
+
+	if err := keystore.WritePrivateKey(*out, pk); err != nil {
+		return err
+	}
+
+	fmt.Println(k.ID)
+	return nil
+}
+
+func (c *command) keyRotate() error {
+	fs := flag.NewFlagSet("key rotate", flag.ExitOnError)
+	alg := fs.String("alg", "ES256", "signing algorithm: "+strings.Join(keystore.Algorithms, ", "))
+	dir := fs.String("dir", "", "key directory")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	pk, err := keystore.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+
+	k, err := keystore.AddToDir(*dir, pk)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(k.ID)
+	return nil
+}
+
+func (c *command) keyList() error {
+	fs := flag.NewFlagSet("key list", flag.ExitOnError)
+	dir := fs.String("dir", "", "key directory")
+	if err := c.parse(fs, 0, ""); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	s, err := keystore.LoadDir(*dir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KID\tALGORITHM\tCURRENT")
+	for i, k := range s.Keys() {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%t\n", k.ID, k.Algorithm, i == 0)
+	}
+	return w.Flush()
+}
+
+func (c *command) keyRemove() error {
+	fs := flag.NewFlagSet("key remove", flag.ExitOnError)
+	dir := fs.String("dir", "", "key directory")
+	if err := c.parse(fs, 1, "kid"); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	return keystore.RemoveFromDir(*dir, c.args[0])
+}
@@ -9,8 +9,9 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/duo-labs/webauthn v0.0.0-20200714211715-1daaee874e43
 	github.com/emicklei/go-restful/v3 v3.4.0
-	github.com/google/certificate-transparency-go v1.0.21
 	github.com/google/uuid v1.2.0
 	github.com/json-iterator/go v1.1.10 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
+	go.etcd.io/bbolt v1.3.4
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 )
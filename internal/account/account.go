@@ -0,0 +1,366 @@
+// Package account serves self-service flows: password reset and email verification.
+// Links are delivered by mailer and carry single-use tokens whose hashes are kept in data store.
+package account
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/mail"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/openapi"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	ResetTokenTimeout        = time.Hour
+	VerificationTokenTimeout = 24 * time.Hour
+
+	// ResetPath and VerifyPath are pages of web frontend receiving token query parameter
+	ResetPath  = "/reset-password"
+	VerifyPath = "/verify-email"
+)
+
+var (
+	ErrInvalidToken         = errors.New("account: invalid or expired token")
+	ErrNoEmail              = errors.New("account: user has no email address")
+	ErrEmailAlreadyVerified = errors.New("account: email address already verified")
+)
+
+type Account struct {
+	ds      auth.DataStore
+	mailer  mail.Mailer
+	puk     crypto.PublicKey
+	baseURL string
+	// resets tracks password reset requests processed in background
+	resets *sync.WaitGroup
+}
+
+// New creates account service sending links under baseURL and verifying access tokens with puk
+func New(dataStore auth.DataStore, mailer mail.Mailer, puk crypto.PublicKey, baseURL string) *Account {
+	return &Account{
+		ds:      dataStore,
+		mailer:  mailer,
+		puk:     puk,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		resets:  new(sync.WaitGroup),
+	}
+}
+
+// Wait waits for password reset requests processed in background, such as on shutdown
+func (a Account) Wait() {
+	a.resets.Wait()
+}
+
+func (a Account) link(path, token string) string {
+	return a.baseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// issue replaces outstanding tokens of user with purpose by new token
+func (a Account) issue(purpose auth.TokenPurpose, u *auth.User, timeout time.Duration) (string, error) {
+	if err := a.ds.DeleteOneTimeTokens(u.ID, purpose); err != nil {
+		return "", err
+	}
+
+	token, rec := auth.NewOneTimeToken(purpose, u, timeout)
+	if err := a.ds.AddOneTimeToken(rec); err != nil {
+		return "", err
+	}
+
+	metrics.TokensIssued.Inc(string(purpose))
+	return token, nil
+}
+
+// consume returns user of valid token. Expired token is consumed too, so it can't be retried.
+func (a Account) consume(purpose auth.TokenPurpose, token string) (*auth.User, *auth.OneTimeToken, error) {
+	rec, err := a.ds.ConsumeOneTimeToken(purpose, auth.HashOneTimeToken(token))
+	if err == auth.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rec.Expired() {
+		return nil, nil, ErrInvalidToken
+	}
+
+	u, err := a.ds.GetUserByID(rec.UserID)
+	if err == auth.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u, rec, nil
+}
+
+// RequestPasswordReset mails reset link to verified email of user.
+// Unknown users, inactive users and users without verified email are ignored, so response doesn't reveal which usernames exist.
+func (a Account) RequestPasswordReset(username string) error {
+	u, err := a.ds.GetUserByUsername(username)
+	if err == auth.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if u.Email == "" || !u.EmailVerified || u.Status.Check(time.Now()) != nil {
+		return nil
+	}
+
+	token, err := a.issue(auth.PurposePasswordReset, u, ResetTokenTimeout)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen the link below to choose a new password. The link expires in %s.\n\n%s\n\nIf you didn't request password reset, ignore this mail.\n",
+			u.Username, ResetTokenTimeout, a.link(ResetPath, token)),
+	})
+}
+
+// ResetPassword sets password of user holding reset token and revokes other reset tokens and refresh tokens of user.
+// Token is valid only while email of user is still verified address token was sent to.
+func (a Account) ResetPassword(token, password string) error {
+	if password == "" {
+		return errors.New("account: empty password")
+	}
+
+	u, rec, err := a.consume(auth.PurposePasswordReset, token)
+	if err != nil {
+		return err
+	}
+
+	if u.Email != rec.Email || !u.EmailVerified {
+		return ErrInvalidToken
+	}
+
+	if err := u.Status.Check(time.Now()); err != nil {
+		return err
+	}
+
+	if u.Password, err = auth.HashPassword(password); err != nil {
+		return err
+	}
+
+	// Password may have been reset because it leaked, so sign out everywhere
+	u.RotateSecurityStamp()
+
+	if err := a.ds.UpdateUser(u); err != nil {
+		return err
+	}
+
+	return a.ds.DeleteOneTimeTokens(u.ID, auth.PurposePasswordReset)
+}
+
+// RequestEmailVerification mails verification link to current email of user
+func (a Account) RequestEmailVerification(id uuid.UUID) error {
+	u, err := a.ds.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	if u.Email == "" {
+		return ErrNoEmail
+	}
+
+	if u.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := a.issue(auth.PurposeEmailVerification, u, VerificationTokenTimeout)
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen the link below to verify your email address. The link expires in %s.\n\n%s\n",
+			u.Username, VerificationTokenTimeout, a.link(VerifyPath, token)),
+	})
+}
+
+// VerifyEmail marks email of user verified if it's still address token was sent to
+func (a Account) VerifyEmail(token string) error {
+	u, rec, err := a.consume(auth.PurposeEmailVerification, token)
+	if err != nil {
+		return err
+	}
+
+	if u.Email != rec.Email {
+		return ErrInvalidToken
+	}
+
+	u.EmailVerified = true
+	return a.ds.UpdateUser(u)
+}
+
+type resetRequest struct {
+	Username string `json:"username"`
+}
+
+type resetConfirmation struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type verificationConfirmation struct {
+	Token string `json:"token"`
+}
+
+func (a Account) requestPasswordReset(req *restful.Request, res *restful.Response) {
+	r := new(resetRequest)
+	err := req.ReadEntity(r)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if r.Username == "" {
+		apierror.Write(req, res, apierror.Invalid("Insufficient request parameters"))
+		return
+	}
+
+	// Request is processed in background and failure is only logged,
+	// so neither response nor response time reveals whether user exists
+	a.resets.Add(1)
+	go func() {
+		defer a.resets.Done()
+		if err := a.RequestPasswordReset(r.Username); err != nil {
+			log.Printf("account: password reset of %s failed: %v", r.Username, err)
+		}
+	}()
+
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (a Account) resetPassword(req *restful.Request, res *restful.Response) {
+	r := new(resetConfirmation)
+	err := req.ReadEntity(r)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if r.Token == "" || r.Password == "" {
+		apierror.Write(req, res, apierror.Invalid("Insufficient request parameters"))
+		return
+	}
+
+	err = a.ResetPassword(r.Token, r.Password)
+	if err == ErrInvalidToken {
+		apierror.Write(req, res, apierror.New(http.StatusBadRequest, apierror.CodeInvalidToken, err.Error()))
+		return
+	}
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (a Account) requestEmailVerification(req *restful.Request, res *restful.Response) {
+	u, ok := req.Attribute(gosso.UserAttribute).(*gosso.User)
+	if !ok {
+		apierror.Write(req, res, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	err := a.RequestEmailVerification(u.ID)
+	switch err {
+	case nil:
+		res.WriteHeader(http.StatusAccepted)
+	case auth.ErrNotFound:
+		apierror.Write(req, res, apierror.NotFound("User"))
+	case ErrNoEmail:
+		apierror.Write(req, res, apierror.Invalid(err.Error()))
+	case ErrEmailAlreadyVerified:
+		apierror.Write(req, res, apierror.Conflict(err.Error()))
+	default:
+		apierror.Write(req, res, err)
+	}
+}
+
+func (a Account) verifyEmail(req *restful.Request, res *restful.Response) {
+	r := new(verificationConfirmation)
+	err := req.ReadEntity(r)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	err = a.VerifyEmail(r.Token)
+	if err == ErrInvalidToken {
+		apierror.Write(req, res, apierror.New(http.StatusBadRequest, apierror.CodeInvalidToken, err.Error()))
+		return
+	}
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (a Account) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/account").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
+
+	ws.Route(ws.POST("/password-reset").To(a.requestPasswordReset).
+		Doc("Mail password reset link to verified email of user. Always accepted, whether user exists or not").
+		Reads(resetRequest{}).
+		Returns(http.StatusAccepted, "Accepted", nil).
+		Returns(http.StatusBadRequest, "Bad Request", nil))
+
+	ws.Route(ws.POST("/password-reset/confirm").To(a.resetPassword).
+		Doc("Set new password using token of password reset link").
+		Reads(resetConfirmation{}).
+		Returns(http.StatusNoContent, "No Content", nil).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusForbidden, "Account not active", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
+	ws.Route(ws.POST("/email-verification").To(a.requestEmailVerification).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Filter(apierror.AccessTokenFilter(a.puk)).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Mail verification link to email of access token holder").
+		Returns(http.StatusAccepted, "Accepted", nil).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusUnauthorized, "Unauthorized", nil).
+		Returns(http.StatusConflict, "Conflict", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
+	ws.Route(ws.POST("/email-verification/confirm").To(a.verifyEmail).
+		Doc("Verify email address using token of verification link").
+		Reads(verificationConfirmation{}).
+		Returns(http.StatusNoContent, "No Content", nil).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
+	return ws
+}
@@ -0,0 +1,245 @@
+package account
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/mail"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+// recorder keeps sent messages instead of delivering them
+type recorder struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (r *recorder) Send(m mail.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+var tokenPattern = regexp.MustCompile(`\?token=([A-Za-z0-9_-]+)`)
+
+// lastToken returns token in link of last sent message
+func (r *recorder) lastToken(t *testing.T) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.sent) == 0 {
+		t.Fatal("Expected mail to be sent")
+	}
+
+	m := tokenPattern.FindStringSubmatch(r.sent[len(r.sent)-1].Body)
+	if m == nil {
+		t.Fatal("Expected token link in mail")
+	}
+	return m[1]
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sent)
+}
+
+func post(c *restful.Container, path string, body interface{}, bearer string) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, req)
+	return res
+}
+
+func TestAccount_WebService(t *testing.T) {
+	ds := auth.NewMemoryStore()
+
+	hp, err := auth.HashPassword("world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &auth.User{
+		ID:       uuid.New(),
+		Username: "hello",
+		Password: hp,
+		Email:    "hello@example.com",
+	}
+
+	if err := ds.AddUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accessToken, err := keys.Sign(auth.NewUserClaim(*u, "", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := new(recorder)
+	a := New(ds, mailer, pk.Public(), "https://sso.example.com/")
+	c := restful.NewContainer()
+	c.Add(a.WebService())
+
+	// resetPassword requests reset and waits until mail is sent in background
+	resetPassword := func(username string) *httptest.ResponseRecorder {
+		res := post(c, "/account/password-reset", resetRequest{Username: username}, "")
+		a.Wait()
+		return res
+	}
+
+	// Scenario 01 : Unverified email doesn't receive reset link
+	{
+		res := resetPassword("hello")
+		if res.Code != http.StatusAccepted || mailer.count() != 0 {
+			t.Errorf("Expected Accepted without mail but got %d, %d mails", res.Code, mailer.count())
+		}
+	}
+
+	// Scenario 02 : Verification requires access token
+	{
+		res := post(c, "/account/email-verification", nil, "")
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Expected Unauthorized but got %d", res.Code)
+		}
+	}
+
+	// Scenario 03 : Verify email. Request has no body, so Content-Type may be left out.
+	{
+		req := httptest.NewRequest("POST", "/account/email-verification", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+		if res.Code != http.StatusAccepted {
+			t.Fatalf("Expected Accepted but got %d", res.Code)
+		}
+
+		if to := mailer.sent[0].To; to != u.Email {
+			t.Errorf("Expected mail to %s but got %s", u.Email, to)
+		}
+
+		token := mailer.lastToken(t)
+
+		res = post(c, "/account/email-verification/confirm", verificationConfirmation{Token: token}, "")
+		if res.Code != http.StatusNoContent {
+			t.Errorf("Expected No Content but got %d", res.Code)
+		}
+
+		got, _ := ds.GetUserByID(u.ID)
+		if !got.EmailVerified {
+			t.Error("Expected email to be verified")
+		}
+
+		// Token is single-use
+		res = post(c, "/account/email-verification/confirm", verificationConfirmation{Token: token}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+
+		res = post(c, "/account/email-verification", nil, accessToken)
+		if res.Code != http.StatusConflict {
+			t.Errorf("Expected Conflict but got %d", res.Code)
+		}
+	}
+
+	// Scenario 04 : Unknown user is accepted without mail
+	{
+		n := mailer.count()
+		res := resetPassword("nobody")
+		if res.Code != http.StatusAccepted || mailer.count() != n {
+			t.Errorf("Expected Accepted without mail but got %d", res.Code)
+		}
+	}
+
+	// Scenario 05 : Reset password. Only latest link is valid.
+	{
+		resetPassword("hello")
+		old := mailer.lastToken(t)
+
+		res := resetPassword("hello")
+		if res.Code != http.StatusAccepted {
+			t.Fatalf("Expected Accepted but got %d", res.Code)
+		}
+		token := mailer.lastToken(t)
+
+		res = post(c, "/account/password-reset/confirm", resetConfirmation{Token: old, Password: "other"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+
+		res = post(c, "/account/password-reset/confirm", resetConfirmation{Token: token, Password: "new password"}, "")
+		if res.Code != http.StatusNoContent {
+			t.Errorf("Expected No Content but got %d", res.Code)
+		}
+
+		got, _ := ds.GetUserByID(u.ID)
+		if !got.Password.Validate("new password") {
+			t.Error("Expected password to be changed")
+		}
+
+		res = post(c, "/account/password-reset/confirm", resetConfirmation{Token: token, Password: "again"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 06 : Expired token is rejected
+	{
+		token, rec := auth.NewOneTimeToken(auth.PurposePasswordReset, u, -time.Second)
+		if err := ds.AddOneTimeToken(rec); err != nil {
+			t.Fatal(err)
+		}
+
+		res := post(c, "/account/password-reset/confirm", resetConfirmation{Token: token, Password: "expired"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 07 : Reset link sent to old email is rejected after email change
+	{
+		resetPassword("hello")
+		token := mailer.lastToken(t)
+
+		got, _ := ds.GetUserByID(u.ID)
+		got.Email = "new@example.com"
+		if err := ds.UpdateUser(got); err != nil {
+			t.Fatal(err)
+		}
+
+		res := post(c, "/account/password-reset/confirm", resetConfirmation{Token: token, Password: "stolen"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+}
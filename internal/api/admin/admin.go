@@ -0,0 +1,155 @@
+// Package admin serves backup and data transfer endpoints.
+// Responses include password hashes, so every route requires admin permission.
+package admin
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/must"
+	"github.com/dfkdream/GoSSO/internal/openapi"
+	"github.com/emicklei/go-restful/v3"
+)
+
+// Permission is required to access admin endpoints
+var Permission = must.PermissionFromString("gosso:admin")
+
+type Admin struct {
+	ds  auth.DataStore
+	puk crypto.PublicKey
+}
+
+// New creates admin service verifying access tokens with puk
+func New(dataStore auth.DataStore, puk crypto.PublicKey) *Admin {
+	return &Admin{
+		ds:  dataStore,
+		puk: puk,
+	}
+}
+
+func (a Admin) backup(req *restful.Request, res *restful.Response) {
+	b, ok := a.ds.(auth.Backuper)
+	if !ok {
+		apierror.Write(req, res, auth.ErrBackupUnsupported)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/octet-stream")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gosso-%s.db"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	// Headers are already sent if backup fails while streaming, so error can only abort response
+	err := b.Backup(res)
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (a Admin) export(req *restful.Request, res *restful.Response) {
+	e, err := auth.ExportDataStore(a.ds)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(e)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (a Admin) importData(req *restful.Request, res *restful.Response) {
+	conflict := req.QueryParameter("conflict")
+	if conflict == "" {
+		conflict = string(auth.ConflictFail)
+	}
+
+	mode, err := auth.ParseConflictMode(conflict)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	e := new(auth.Export)
+	err = req.ReadEntity(e)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	result, err := auth.ImportDataStore(a.ds, e, mode)
+	if err != nil {
+		if _, ok := err.(auth.ImportError); ok {
+			apierror.Write(req, res, apierror.Conflict(err.Error()))
+			return
+		}
+
+		if err == auth.ErrUnsupportedExport {
+			apierror.Write(req, res, apierror.Invalid(err.Error()))
+			return
+		}
+
+		// Records written before failure are kept, so admin is told how far import went
+		var partial auth.PartialImportError
+		if errors.As(err, &partial) {
+			log.Printf("admin: %v", err)
+			e := apierror.From(partial.Err)
+			apierror.Write(req, res, apierror.New(e.Status, e.Code, fmt.Sprintf("%s. Import stopped after %d created, %d overwritten and %d skipped records",
+				e.Message, partial.Result.Created, partial.Result.Overwritten, partial.Result.Skipped)))
+			return
+		}
+
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(result)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (a Admin) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/admin").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument).
+		Filter(apierror.AccessTokenFilter(a.puk, Permission))
+
+	ws.Route(ws.GET("/backup").To(a.backup).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Download consistent snapshot of live database").
+		Produces("application/octet-stream"))
+
+	ws.Route(ws.GET("/export").To(a.export).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Export users and groups including password hashes as JSON").
+		Writes(auth.Export{}))
+
+	ws.Route(ws.POST("/import").To(a.importData).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Import users and groups exported by /admin/export").
+		Param(ws.QueryParameter("conflict", "How to handle existing records").
+			AllowableValues(map[string]string{
+				string(auth.ConflictSkip):      "keep existing record",
+				string(auth.ConflictOverwrite): "replace existing record",
+				string(auth.ConflictFail):      "import nothing if any record exists",
+			}).
+			DefaultValue(string(auth.ConflictFail))).
+		Reads(auth.Export{}).
+		Writes(auth.ImportResult{}))
+
+	return ws
+}
@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+func request(c *restful.Container, method, path string, body interface{}, bearer string) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, req)
+	return res
+}
+
+func TestAdmin_WebService(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := auth.NewDataStore(filepath.Join(testDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.Close() }()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(perms ...permission.Permission) string {
+		tok, err := keys.Sign(auth.NewUserClaim(auth.User{ID: uuid.New(), Username: "admin", Permissions: perms}, "", time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	adminToken := sign(Permission)
+
+	c := restful.NewContainer()
+	c.Add(New(ds, pk.Public()).WebService())
+
+	pw, err := auth.HashPassword("world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &auth.User{ID: uuid.New(), Username: "hello", Password: pw}
+	if err := ds.AddUser(u); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scenario 01 : Only admin can access endpoints
+	{
+		for _, path := range []string{"/admin/backup", "/admin/export"} {
+			if res := request(c, "GET", path, nil, ""); res.Code != http.StatusUnauthorized {
+				t.Errorf("%s: Expected Unauthorized but got %d", path, res.Code)
+			}
+
+			if res := request(c, "GET", path, nil, sign()); res.Code != http.StatusForbidden {
+				t.Errorf("%s: Expected Forbidden but got %d", path, res.Code)
+			}
+		}
+	}
+
+	// Scenario 02 : Download backup
+	{
+		res := request(c, "GET", "/admin/backup", nil, adminToken)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if !strings.HasPrefix(res.Header().Get("Content-Disposition"), "attachment;") || res.Body.Len() == 0 {
+			t.Errorf("Unexpected backup response %v", res.Header())
+		}
+	}
+
+	// Scenario 03 : Export includes password hashes
+	var e auth.Export
+	{
+		res := request(c, "GET", "/admin/export", nil, adminToken)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(e.Users) != 1 || e.Users[0].ID != u.ID || !e.Users[0].Password.Validate("world") {
+			t.Errorf("Unexpected export %+v", e)
+		}
+	}
+
+	// Scenario 04 : Import handles conflicts by mode
+	{
+		for _, tc := range []struct {
+			conflict string
+			code     int
+			result   auth.ImportResult
+		}{
+			{"", http.StatusConflict, auth.ImportResult{}},
+			{"?conflict=skip", http.StatusOK, auth.ImportResult{Skipped: 1}},
+			{"?conflict=overwrite", http.StatusOK, auth.ImportResult{Overwritten: 1}},
+			{"?conflict=invalid", http.StatusBadRequest, auth.ImportResult{}},
+		} {
+			res := request(c, "POST", "/admin/import"+tc.conflict, e, adminToken)
+			if res.Code != tc.code {
+				t.Errorf("%q: Expected %d but got %d", tc.conflict, tc.code, res.Code)
+				continue
+			}
+
+			if res.Code == http.StatusOK {
+				var r auth.ImportResult
+				if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+					t.Fatal(err)
+				}
+
+				if r != tc.result {
+					t.Errorf("%q: Expected %+v but got %+v", tc.conflict, tc.result, r)
+				}
+			}
+		}
+
+		res := request(c, "POST", "/admin/import", auth.Export{Version: auth.ExportVersion + 1}, adminToken)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 05 : Partial import reports records written before failure
+	{
+		partial := auth.Export{
+			Version: auth.ExportVersion,
+			Users: []auth.ExportedUser{
+				{ID: uuid.New(), Username: "other"},
+				{ID: uuid.New(), Username: "other"},
+			},
+		}
+
+		res := request(c, "POST", "/admin/import", partial, adminToken)
+		if res.Code != http.StatusConflict {
+			t.Fatalf("Expected Conflict but got %d", res.Code)
+		}
+
+		r := new(apierror.Response)
+		if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(r.Message, "stopped after 1 created") {
+			t.Errorf("Unexpected message %q", r.Message)
+		}
+
+		if _, err := ds.GetUserByID(partial.Users[0].ID); err != nil {
+			t.Errorf("Expected written user to be kept but got %v", err)
+		}
+	}
+
+	// Scenario 06 : Backup of data store without snapshot support is not implemented
+	{
+		c := restful.NewContainer()
+		c.Add(New(auth.NewMemoryStore(), pk.Public()).WebService())
+
+		if res := request(c, "GET", "/admin/backup", nil, adminToken); res.Code != http.StatusNotImplemented {
+			t.Errorf("Expected Not Implemented but got %d", res.Code)
+		}
+	}
+}
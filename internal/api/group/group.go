@@ -0,0 +1,374 @@
+package group
+
+import (
+	"net/http"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+type Group struct {
+	ds auth.DataStore
+}
+
+type groupInfo struct {
+	Name        string                  `json:"name"`
+	Permissions []permission.Permission `json:"permissions"`
+	Members     []uuid.UUID             `json:"members"`
+}
+
+func New(dataStore auth.DataStore) *Group {
+	return &Group{
+		ds: dataStore,
+	}
+}
+
+func (g Group) getGroups(req *restful.Request, res *restful.Response) {
+	groups, err := g.ds.GetAllGroups()
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(groups)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+// readGroup finds group from groupUUID path parameter and writes error response on failure
+func (g Group) readGroup(req *restful.Request, res *restful.Response) (*auth.Group, bool) {
+	gid, err := uuid.Parse(req.PathParameter("groupUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid group UUID"))
+		return nil, false
+	}
+
+	grp, err := g.ds.GetGroupByID(gid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group", err))
+		return nil, false
+	}
+
+	return grp, true
+}
+
+// checkMembers verifies every member is existing user
+func (g Group) checkMembers(members []uuid.UUID) error {
+	for _, m := range members {
+		if _, err := g.ds.GetUserByID(m); err != nil {
+			if err == auth.ErrNotFound {
+				return apierror.Invalid("User not found: " + m.String())
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeMembers rotates security stamps of users whose group permissions changed,
+// so refresh tokens issued with old permissions are revoked. Deleted users are skipped.
+func (g Group) revokeMembers(members []uuid.UUID) error {
+	for _, id := range members {
+		usr, err := g.ds.GetUserByID(id)
+		if err == auth.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		usr.RotateSecurityStamp()
+		if err := g.ds.UpdateUser(usr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g Group) getGroup(req *restful.Request, res *restful.Response) {
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	err := res.WriteEntity(grp)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) addGroup(req *restful.Request, res *restful.Response) {
+	gData := new(groupInfo)
+	err := req.ReadEntity(gData)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if gData.Name == "" {
+		apierror.Write(req, res, apierror.BadRequest("Insufficient request parameters"))
+		return
+	}
+
+	if err := g.checkMembers(gData.Members); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	grp := &auth.Group{
+		ID:          uuid.New(),
+		Name:        gData.Name,
+		Permissions: gData.Permissions,
+		Members:     gData.Members,
+	}
+
+	err = g.ds.AddGroup(grp)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group name", err))
+		return
+	}
+
+	err = res.WriteEntity(grp.ID)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) deleteGroup(req *restful.Request, res *restful.Response) {
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	err := g.ds.DeleteGroup(grp)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group", err))
+		return
+	}
+
+	if err := g.revokeMembers(grp.Members); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) renameGroup(req *restful.Request, res *restful.Response) {
+	gData := new(groupInfo)
+	err := req.ReadEntity(gData)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if gData.Name == "" {
+		apierror.Write(req, res, apierror.BadRequest("Insufficient request parameters"))
+		return
+	}
+
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	grp.Name = gData.Name
+
+	err = g.ds.UpdateGroup(grp)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group name", err))
+		return
+	}
+}
+
+func (g Group) updateGroupPerms(req *restful.Request, res *restful.Response) {
+	perm := make([]permission.Permission, 0)
+	err := req.ReadEntity(&perm)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	grp.Permissions = perm
+
+	err = g.ds.UpdateGroup(grp)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group", err))
+		return
+	}
+
+	if err := g.revokeMembers(grp.Members); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) updateGroupMembers(req *restful.Request, res *restful.Response) {
+	members := make([]uuid.UUID, 0)
+	err := req.ReadEntity(&members)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	if err := g.checkMembers(members); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	unique := &auth.Group{Members: make([]uuid.UUID, 0, len(members))}
+	for _, m := range members {
+		unique.AddMember(m)
+	}
+
+	old, err := g.ds.SetGroupMembers(grp.ID, unique.Members)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group", err))
+		return
+	}
+
+	// Members both added and removed gain or lose group permissions
+	was := make(map[uuid.UUID]bool, len(old))
+	for _, m := range old {
+		was[m] = true
+	}
+
+	changed := make([]uuid.UUID, 0)
+	for _, m := range unique.Members {
+		if !was[m] {
+			changed = append(changed, m)
+		}
+		delete(was, m)
+	}
+	for _, m := range old {
+		if was[m] {
+			changed = append(changed, m)
+		}
+	}
+
+	if err := g.revokeMembers(changed); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) addGroupMember(req *restful.Request, res *restful.Response) {
+	uid, err := uuid.Parse(req.PathParameter("userUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
+		return
+	}
+
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	if err := g.checkMembers([]uuid.UUID{uid}); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	added, err := g.ds.AddGroupMember(grp.ID, uid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group", err))
+		return
+	}
+
+	if !added {
+		return
+	}
+
+	if err := g.revokeMembers([]uuid.UUID{uid}); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) removeGroupMember(req *restful.Request, res *restful.Response) {
+	uid, err := uuid.Parse(req.PathParameter("userUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
+		return
+	}
+
+	grp, ok := g.readGroup(req, res)
+	if !ok {
+		return
+	}
+
+	err = g.ds.RemoveGroupMember(grp.ID, uid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Group member", err))
+		return
+	}
+
+	if err := g.revokeMembers([]uuid.UUID{uid}); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (g Group) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/group").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
+
+	ws.Route(ws.GET("/").To(g.getGroups).
+		Doc("Get all groups").
+		Writes(&[]auth.Group{}))
+
+	ws.Route(ws.POST("/").To(g.addGroup).
+		Doc("Create new group").
+		Reads(&groupInfo{}).
+		Writes(&uuid.UUID{}))
+
+	ws.Route(ws.GET("/{groupUUID}").To(g.getGroup).
+		Doc("Get group info with provided UUID").
+		Writes(&auth.Group{}))
+
+	ws.Route(ws.DELETE("/{groupUUID}").To(g.deleteGroup).
+		Doc("Delete group with provided UUID"))
+
+	ws.Route(ws.POST("/{groupUUID}/name").To(g.renameGroup).
+		Doc("Rename group").
+		Reads(&groupInfo{}, "permissions and members field not used"))
+
+	ws.Route(ws.POST("/{groupUUID}/permissions").To(g.updateGroupPerms).
+		Doc("Update group permissions inherited by members").
+		Reads([]permission.Permission{}))
+
+	ws.Route(ws.POST("/{groupUUID}/members").To(g.updateGroupMembers).
+		Doc("Replace group members").
+		Reads([]uuid.UUID{}))
+
+	ws.Route(ws.PUT("/{groupUUID}/members/{userUUID}").To(g.addGroupMember).
+		AllowedMethodsWithoutContentType([]string{http.MethodPut}).
+		Doc("Add user to group"))
+
+	ws.Route(ws.DELETE("/{groupUUID}/members/{userUUID}").To(g.removeGroupMember).
+		Doc("Remove user from group"))
+
+	return ws
+}
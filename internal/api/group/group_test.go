@@ -0,0 +1,186 @@
+package group
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+// serve sends JSON request with body to c
+func serve(c *restful.Container, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", restful.MIME_JSON)
+	}
+	res := httptest.NewRecorder()
+
+	c.ServeHTTP(res, req)
+	return res
+}
+
+func TestGroup_WebService(t *testing.T) {
+	ds := auth.NewMemoryStore()
+
+	c := restful.NewContainer()
+	c.Add(New(ds).WebService())
+
+	var users []uuid.UUID
+	for _, name := range []string{"hello", "world"} {
+		u := &auth.User{ID: uuid.New(), Username: name}
+		if err := ds.AddUser(u); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u.ID)
+	}
+
+	readGroup := func(id string) *auth.Group {
+		res := serve(c, "GET", "/group/"+id, "")
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		g := new(auth.Group)
+		if err := json.NewDecoder(res.Body).Decode(g); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	// Scenario 01 : Create group with members
+	var gid string
+	{
+		res := serve(c, "POST", "/group/", `{"name":"staff","permissions":["+:staff"],"members":["`+users[0].String()+`"]}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		var id uuid.UUID
+		if err := json.NewDecoder(res.Body).Decode(&id); err != nil {
+			t.Fatal(err)
+		}
+		gid = id.String()
+
+		g := readGroup(gid)
+		if g.Name != "staff" || len(g.Permissions) != 1 || len(g.Members) != 1 || g.Members[0] != users[0] {
+			t.Errorf("Unexpected group %+v", g)
+		}
+	}
+
+	// Scenario 02 : Invalid groups are rejected
+	{
+		for _, tc := range []struct {
+			body string
+			code int
+		}{
+			{`{"permissions":["+:staff"]}`, http.StatusBadRequest},
+			{`{"name":"other","members":["` + uuid.New().String() + `"]}`, http.StatusBadRequest},
+			{`{"name":"staff"}`, http.StatusConflict},
+		} {
+			res := serve(c, "POST", "/group/", tc.body)
+			if res.Code != tc.code {
+				t.Errorf("%s: Expected %d but got %d", tc.body, tc.code, res.Code)
+			}
+		}
+	}
+
+	// Scenario 03 : Rename group, keeping names unique
+	{
+		res := serve(c, "POST", "/group/", `{"name":"other"}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		res = serve(c, "POST", "/group/"+gid+"/name", `{"name":"other"}`)
+		if res.Code != http.StatusConflict {
+			t.Errorf("Expected Conflict but got %d", res.Code)
+		}
+
+		res = serve(c, "POST", "/group/"+gid+"/name", `{"name":"team"}`)
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		if g := readGroup(gid); g.Name != "team" {
+			t.Errorf("Expected renamed group but got %+v", g)
+		}
+	}
+
+	// Scenario 04 : Add and remove members
+	{
+		res := serve(c, "PUT", "/group/"+gid+"/members/"+users[1].String(), "")
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		res = serve(c, "DELETE", "/group/"+gid+"/members/"+users[0].String(), "")
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		if g := readGroup(gid); len(g.Members) != 1 || g.Members[0] != users[1] {
+			t.Errorf("Unexpected members %v", g.Members)
+		}
+
+		res = serve(c, "DELETE", "/group/"+gid+"/members/"+users[0].String(), "")
+		if res.Code != http.StatusNotFound {
+			t.Errorf("Expected Not Found but got %d", res.Code)
+		}
+
+		res = serve(c, "PUT", "/group/"+gid+"/members/"+uuid.New().String(), "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 05 : Replace members and permissions
+	{
+		res := serve(c, "POST", "/group/"+gid+"/members", `["`+users[0].String()+`","`+users[0].String()+`","`+users[1].String()+`"]`)
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		res = serve(c, "POST", "/group/"+gid+"/permissions", `["+:team","-:team:secret"]`)
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		if g := readGroup(gid); len(g.Members) != 2 || len(g.Permissions) != 2 {
+			t.Errorf("Unexpected group %+v", g)
+		}
+	}
+
+	// Scenario 06 : List and delete groups
+	{
+		res := serve(c, "GET", "/group/", "")
+
+		var groups []auth.Group
+		if err := json.NewDecoder(res.Body).Decode(&groups); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(groups) != 2 {
+			t.Errorf("Expected 2 groups but got %+v", groups)
+		}
+
+		res = serve(c, "DELETE", "/group/"+gid, "")
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		for _, path := range []string{"/group/" + gid, "/group/" + gid + "/members/" + users[0].String()} {
+			if res := serve(c, "DELETE", path, ""); res.Code != http.StatusNotFound {
+				t.Errorf("%s: Expected Not Found but got %d", path, res.Code)
+			}
+		}
+
+		if res := serve(c, "GET", "/group/invalid", ""); res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+}
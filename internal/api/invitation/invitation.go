@@ -0,0 +1,301 @@
+// Package invitation serves invitation-based onboarding.
+// Admins create invitations with preset permissions, and invitees accept them choosing own username and password.
+package invitation
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/api/admin"
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/mail"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/openapi"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultTimeout = 7 * 24 * time.Hour
+	MaxTimeout     = 30 * 24 * time.Hour
+
+	// AcceptPath is page of web frontend receiving token query parameter
+	AcceptPath = "/accept-invitation"
+)
+
+var ErrInvalidInvitation = errors.New("invitation: invalid or expired invitation")
+
+type Invitation struct {
+	ds      auth.DataStore
+	mailer  mail.Mailer
+	puk     crypto.PublicKey
+	baseURL string
+}
+
+// New creates invitation service. Invitations with email are mailed if mailer is not nil.
+func New(dataStore auth.DataStore, mailer mail.Mailer, puk crypto.PublicKey, baseURL string) *Invitation {
+	return &Invitation{
+		ds:      dataStore,
+		mailer:  mailer,
+		puk:     puk,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+type invitationRequest struct {
+	Permissions []permission.Permission `json:"permissions"`
+	Email       string                  `json:"email,omitempty"`
+	// ExpiresIn is lifetime of invitation in seconds
+	ExpiresIn int64 `json:"expiresIn,omitempty"`
+}
+
+// createdInvitation holds invitation link, which is returned only once
+type createdInvitation struct {
+	auth.Invitation
+	Link string `json:"link"`
+}
+
+type acceptRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Accept creates user of invitation with token. Invitation is consumed only if user is created.
+// Token is checked before password is hashed, so requests with made-up tokens are cheap.
+func (v Invitation) Accept(token, username, password string) (*auth.User, error) {
+	i, err := v.ds.ConsumeInvitation(auth.HashOneTimeToken(token))
+	if err == auth.ErrNotFound {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i.Expired() {
+		return nil, ErrInvalidInvitation
+	}
+
+	u, err := v.createUser(i, username, password)
+	if err != nil {
+		// Give invitation back to invitee, so another username can be chosen
+		if rErr := v.ds.AddInvitation(i); rErr != nil {
+			return nil, fmt.Errorf("%v, restoring invitation: %w", err, rErr)
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (v Invitation) createUser(i *auth.Invitation, username, password string) (*auth.User, error) {
+	if _, err := v.ds.GetUserByUsername(username); err == nil {
+		return nil, auth.ErrAlreadyExists
+	} else if err != auth.ErrNotFound {
+		return nil, err
+	}
+
+	hp, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &auth.User{
+		ID:          uuid.New(),
+		Username:    username,
+		Password:    hp,
+		Permissions: i.Permissions,
+		Email:       i.Email,
+		// Invitee received link at address invitation was mailed to
+		EmailVerified: i.Mailed,
+	}
+
+	if err := v.ds.AddUser(u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (v Invitation) createInvitation(req *restful.Request, res *restful.Response) {
+	caller, ok := req.Attribute(gosso.UserAttribute).(*gosso.User)
+	if !ok {
+		apierror.Write(req, res, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "Unauthorized"))
+		return
+	}
+
+	r := new(invitationRequest)
+	err := req.ReadEntity(r)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	timeout := DefaultTimeout
+	if r.ExpiresIn != 0 {
+		timeout = time.Duration(r.ExpiresIn) * time.Second
+	}
+
+	if timeout <= 0 || timeout > MaxTimeout {
+		apierror.Write(req, res, apierror.Invalid(fmt.Sprintf("expiresIn must be between 1 and %d seconds", int64(MaxTimeout/time.Second))))
+		return
+	}
+
+	// Email is validated like profile email, since it becomes email of created user
+	if err := auth.ValidateProfile(&auth.User{Email: r.Email}, nil); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	token, i := auth.NewInvitation(r.Permissions, r.Email, caller.ID, timeout)
+	// Invitation is deleted if mail fails, so it's stored as mailed before sending
+	i.Mailed = i.Email != "" && v.mailer != nil
+	err = v.ds.AddInvitation(i)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	created := createdInvitation{
+		Invitation: *i,
+		Link:       v.baseURL + AcceptPath + "?token=" + url.QueryEscape(token),
+	}
+
+	if i.Mailed {
+		err = v.mailer.Send(mail.Message{
+			To:      i.Email,
+			Subject: "You are invited to GoSSO",
+			Body: fmt.Sprintf("Hello,\n\nOpen the link below to create your account. The link expires at %s.\n\n%s\n",
+				i.ExpiresAt.UTC().Format(time.RFC1123), created.Link),
+		})
+		if err != nil {
+			log.Printf("invitation: mail to %s failed: %v", i.Email, err)
+			_ = v.ds.DeleteInvitation(i.ID)
+			apierror.Write(req, res, apierror.New(http.StatusBadGateway, apierror.CodeUpstream, "Invitation mail not sent"))
+			return
+		}
+	}
+
+	err = res.WriteHeaderAndEntity(http.StatusCreated, created)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (v Invitation) getInvitations(req *restful.Request, res *restful.Response) {
+	iList, err := v.ds.GetAllInvitations()
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(iList)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (v Invitation) deleteInvitation(req *restful.Request, res *restful.Response) {
+	id, err := uuid.Parse(req.PathParameter("invitationUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid invitation UUID"))
+		return
+	}
+
+	err = v.ds.DeleteInvitation(id)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("Invitation", err))
+		return
+	}
+}
+
+func (v Invitation) acceptInvitation(req *restful.Request, res *restful.Response) {
+	r := new(acceptRequest)
+	err := req.ReadEntity(r)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if r.Token == "" || r.Username == "" || r.Password == "" {
+		apierror.Write(req, res, apierror.Invalid("Insufficient request parameters"))
+		return
+	}
+
+	u, err := v.Accept(r.Token, r.Username, r.Password)
+	switch err {
+	case nil:
+	case ErrInvalidInvitation:
+		apierror.Write(req, res, apierror.New(http.StatusBadRequest, apierror.CodeInvalidToken, err.Error()))
+		return
+	case auth.ErrAlreadyExists:
+		apierror.Write(req, res, apierror.Conflict("Username already exists"))
+		return
+	default:
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteHeaderAndEntity(http.StatusCreated, u.ID)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (v Invitation) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/invitation").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
+
+	adminFilter := apierror.AccessTokenFilter(v.puk, admin.Permission)
+
+	ws.Route(ws.POST("/").To(v.createInvitation).
+		Filter(adminFilter).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Create invitation with preset permissions. Invitation is mailed if email is set").
+		Reads(invitationRequest{}).
+		Writes(createdInvitation{}).
+		Returns(http.StatusCreated, "Created", createdInvitation{}).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusBadGateway, "Mail not sent", nil))
+
+	ws.Route(ws.GET("/").To(v.getInvitations).
+		Filter(adminFilter).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Get unaccepted invitations including expired ones").
+		Writes([]auth.Invitation{}))
+
+	ws.Route(ws.DELETE("/{invitationUUID}").To(v.deleteInvitation).
+		Filter(adminFilter).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("Revoke invitation").
+		Returns(http.StatusOK, "OK", nil).
+		Returns(http.StatusNotFound, "Not Found", nil))
+
+	ws.Route(ws.POST("/accept").To(v.acceptInvitation).
+		Doc("Create user of invitation choosing username and password").
+		Reads(acceptRequest{}).
+		Writes(uuid.UUID{}).
+		Returns(http.StatusCreated, "Created", uuid.UUID{}).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusConflict, "Conflict", nil))
+
+	return ws
+}
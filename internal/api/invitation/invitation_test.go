@@ -0,0 +1,226 @@
+package invitation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/api/admin"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/mail"
+	"github.com/dfkdream/GoSSO/internal/must"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+// recorder keeps sent messages instead of delivering them
+type recorder struct {
+	sent []mail.Message
+}
+
+func (r *recorder) Send(m mail.Message) error {
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+func request(c *restful.Container, method, path string, body interface{}, bearer string) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res := httptest.NewRecorder()
+	c.ServeHTTP(res, req)
+	return res
+}
+
+func TestInvitation_WebService(t *testing.T) {
+	ds := auth.NewMemoryStore()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(perms ...permission.Permission) string {
+		tok, err := keys.Sign(auth.NewUserClaim(auth.User{ID: uuid.New(), Username: "admin", Permissions: perms}, "", time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	adminToken := sign(admin.Permission)
+
+	c := restful.NewContainer()
+	c.Add(New(ds, nil, pk.Public(), "https://sso.example.com").WebService())
+
+	perms := []permission.Permission{must.PermissionFromString("+:sso")}
+
+	// Scenario 01 : Only admin can create invitation
+	{
+		res := request(c, "POST", "/invitation/", invitationRequest{Permissions: perms}, sign())
+		if res.Code != http.StatusForbidden {
+			t.Errorf("Expected Forbidden but got %d", res.Code)
+		}
+
+		res = request(c, "POST", "/invitation/", invitationRequest{Permissions: perms, ExpiresIn: -1}, adminToken)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 02 : Accept invitation
+	var created createdInvitation
+	{
+		res := request(c, "POST", "/invitation/", invitationRequest{Permissions: perms, Email: "hello@example.com"}, adminToken)
+		if res.Code != http.StatusCreated {
+			t.Fatalf("Expected Created but got %d", res.Code)
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		if created.Mailed {
+			t.Error("Expected invitation not mailed without mailer")
+		}
+
+		link, err := url.Parse(created.Link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := link.Query().Get("token")
+
+		res = request(c, "GET", "/invitation/", nil, adminToken)
+		var iList []auth.Invitation
+		if err := json.NewDecoder(res.Body).Decode(&iList); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(iList) != 1 || iList[0].ID != created.ID {
+			t.Errorf("Unexpected invitations %+v", iList)
+		}
+
+		res = request(c, "POST", "/invitation/accept", acceptRequest{Token: token, Username: "hello", Password: "world"}, "")
+		if res.Code != http.StatusCreated {
+			t.Fatalf("Expected Created but got %d", res.Code)
+		}
+
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Link wasn't mailed, so invitee didn't prove owning email
+		if !u.Password.Validate("world") || len(u.Permissions) != 1 || !u.Permissions[0].Equals(perms[0]) || u.EmailVerified {
+			t.Errorf("Unexpected user %+v", u)
+		}
+
+		// Invitation is single-use
+		res = request(c, "POST", "/invitation/accept", acceptRequest{Token: token, Username: "other", Password: "world"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 03 : Taken username keeps invitation
+	{
+		res := request(c, "POST", "/invitation/", invitationRequest{}, adminToken)
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		link, _ := url.Parse(created.Link)
+		token := link.Query().Get("token")
+
+		res = request(c, "POST", "/invitation/accept", acceptRequest{Token: token, Username: "hello", Password: "world"}, "")
+		if res.Code != http.StatusConflict {
+			t.Errorf("Expected Conflict but got %d", res.Code)
+		}
+
+		res = request(c, "POST", "/invitation/accept", acceptRequest{Token: token, Username: "hola", Password: "world"}, "")
+		if res.Code != http.StatusCreated {
+			t.Errorf("Expected Created but got %d", res.Code)
+		}
+	}
+
+	// Scenario 04 : Revoked invitation can't be accepted
+	{
+		res := request(c, "POST", "/invitation/", invitationRequest{}, adminToken)
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		res = request(c, "DELETE", "/invitation/"+created.ID.String(), nil, adminToken)
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		link, _ := url.Parse(created.Link)
+		res = request(c, "POST", "/invitation/accept", acceptRequest{Token: link.Query().Get("token"), Username: "bye", Password: "world"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 05 : Expired invitation can't be accepted
+	{
+		token, i := auth.NewInvitation(nil, "", uuid.New(), -time.Second)
+		if err := ds.AddInvitation(i); err != nil {
+			t.Fatal(err)
+		}
+
+		res := request(c, "POST", "/invitation/accept", acceptRequest{Token: token, Username: "late", Password: "world"}, "")
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected Bad Request but got %d", res.Code)
+		}
+	}
+
+	// Scenario 06 : Email of mailed invitation is verified
+	{
+		r := new(recorder)
+		mc := restful.NewContainer()
+		mc.Add(New(ds, r, pk.Public(), "https://sso.example.com").WebService())
+
+		res := request(mc, "POST", "/invitation/", invitationRequest{Permissions: perms, Email: "mailed@example.com"}, adminToken)
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+
+		if !created.Mailed || len(r.sent) != 1 || r.sent[0].To != "mailed@example.com" {
+			t.Fatalf("Expected invitation mailed but got %+v", created)
+		}
+
+		link, _ := url.Parse(created.Link)
+		res = request(mc, "POST", "/invitation/accept", acceptRequest{Token: link.Query().Get("token"), Username: "mailed", Password: "world"}, "")
+		if res.Code != http.StatusCreated {
+			t.Fatalf("Expected Created but got %d", res.Code)
+		}
+
+		u, err := ds.GetUserByUsername("mailed")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !u.EmailVerified || u.Email != "mailed@example.com" {
+			t.Errorf("Expected verified email but got %+v", u)
+		}
+	}
+}
@@ -1,121 +1,274 @@
 package token
 
 import (
-	"crypto/ecdsa"
-	"encoding/pem"
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"time"
 
-	"github.com/dfkdream/GoSSO/internal/must"
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/openapi"
 
 	"github.com/dfkdream/GoSSO/pkg/gosso"
 
-	"github.com/google/certificate-transparency-go/x509"
-
 	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/permission"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/emicklei/go-restful/v3"
 )
 
-var refreshPermission = must.PermissionFromString("+:gosso:token:refresh")
-
 type Token struct {
-	ds            *auth.DataStore
-	pk            *ecdsa.PrivateKey
+	ds            auth.DataStore
+	keys          *keystore.Store
 	accessTimeout time.Duration
-	pem           []byte
+	cookie        auth.CookieConfig
 }
 
+const (
+	profileFull    = "full"
+	profileCompact = "compact"
+)
+
+var (
+	ErrBadRefreshToken = errors.New("token: bad refresh token")
+	ErrTokenRevoked    = errors.New("token: refresh token revoked")
+)
+
 type refreshTokenResponse struct {
 	Token string `json:"token"`
 }
 
-func New(dataStore *auth.DataStore, privateKey *ecdsa.PrivateKey, accessTimeout time.Duration) (*Token, error) {
-	pemBytes, err := x509.MarshalPKIXPublicKey(privateKey.Public())
-	if err != nil {
+func New(dataStore auth.DataStore, keys *keystore.Store, accessTimeout time.Duration, cookie auth.CookieConfig) (*Token, error) {
+	if _, err := keys.Current(); err != nil {
 		return nil, err
 	}
 
 	return &Token{
 		ds:            dataStore,
-		pk:            privateKey,
+		keys:          keys,
 		accessTimeout: accessTimeout,
-		pem: pem.EncodeToMemory(&pem.Block{
-			Type:  "PUBLIC KEY",
-			Bytes: pemBytes,
-		}),
+		cookie:        cookie,
 	}, nil
 }
 
-func (t Token) generateAccessToken(u auth.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, auth.UserClaim{
-		Issuer:    "gosso",
-		IssuedAt:  time.Now().Unix(),
-		NotBefore: time.Now().Unix(),
-		ExpiresAt: time.Now().Add(t.accessTimeout).Unix(),
-		User:      u,
-	})
-	return token.SignedString(t.pk)
-}
-
-func (t Token) publicKey(_ *restful.Request, res *restful.Response) {
-	_, err := res.Write(t.pem)
+// generateAccessToken signs access token holding user permissions merged with group permissions.
+// If scope is not empty, permissions are down-scoped to intersection of scope and user permissions.
+// Compact token holds scoped permissions, or digest of permissions if scope is empty.
+// Profile claims are added to either token profile.
+func (t Token) generateAccessToken(u auth.User, audience string, scope []permission.Permission, compact bool, profile gosso.Profile) (string, error) {
+	perms, err := auth.GetEffectivePermissions(t.ds, &u)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		return "", err
+	}
+
+	if len(scope) > 0 {
+		perms = auth.ScopePermissions(perms, scope)
+	}
+	u.Permissions = perms
+
+	var claims jwt.Claims
+	if compact {
+		c := auth.NewCompactClaim(u, audience, t.accessTimeout, len(scope) > 0)
+		c.Profile = profile
+		claims = c
+	} else {
+		c := auth.NewUserClaim(u, audience, t.accessTimeout)
+		c.Profile = profile
+		claims = c
 	}
+
+	return t.keys.Sign(claims)
 }
 
-func isRefreshToken(u *auth.User) bool {
-	if len(u.Permissions) != 1 {
-		return false
+// publicKey writes PEM encoded public key of current signing key, or key with kid query parameter.
+// Key id and signing algorithm are published in X-Key-ID and X-Key-Algorithm headers.
+func (t Token) publicKey(req *restful.Request, res *restful.Response) {
+	k, err := t.keys.Current()
+	if kid := req.QueryParameter("kid"); kid != "" {
+		k, err = t.keys.Get(kid)
+		if err == keystore.ErrKeyNotFound {
+			apierror.Write(req, res, apierror.NotFound("Key"))
+			return
+		}
+	}
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
 	}
 
-	if !u.Permissions[0].Equals(refreshPermission) {
-		return false
+	p, err := k.PublicKeyPEM()
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
 	}
 
-	return refreshPermission.HasPermission(u.Permissions)
+	res.Header().Set("X-Key-ID", k.ID)
+	res.Header().Set("X-Key-Algorithm", k.Algorithm)
+
+	_, err = res.Write(p)
+	if err != nil {
+		apierror.Write(req, res, err)
+	}
 }
 
-func (t Token) refreshToken(req *restful.Request, res *restful.Response) {
-	c, err := req.Request.Cookie("token")
+func (t Token) jwks(req *restful.Request, res *restful.Response) {
+	set, err := t.keys.JWKSet()
 	if err != nil {
-		_ = res.WriteError(http.StatusBadRequest, err)
+		apierror.Write(req, res, err)
 		return
 	}
 
-	u, ok, err := gosso.ValidateToken(c.Value, t.pk.Public())
-	if ok && u != nil {
+	err = res.WriteAsJson(set)
+	if err != nil {
+		apierror.Write(req, res, err)
+	}
+}
 
-		if !isRefreshToken(u) {
-			_ = res.WriteErrorString(http.StatusForbidden, "Bad Refresh Token")
-			return
+// refreshTokenUser returns user of refresh token in cookie.
+// Refresh tokens issued before security stamp of user was rotated are rejected.
+func (t Token) refreshTokenUser(req *restful.Request) (*auth.User, error) {
+	c, err := req.Request.Cookie(t.cookie.Name)
+	if err != nil {
+		return nil, apierror.BadRequest("Refresh token cookie not found")
+	}
+
+	cl, ok, err := gosso.ParseClaims(c.Value, t.keys)
+	if !ok || cl == nil || !cl.User.IsRefreshToken() {
+		if err == nil {
+			err = ErrBadRefreshToken
 		}
+		return nil, apierror.New(http.StatusForbidden, apierror.CodeInvalidToken, err.Error())
+	}
+
+	usr, err := t.ds.GetUserByID(cl.User.ID)
+	if err == auth.ErrNotFound {
+		return nil, apierror.New(http.StatusForbidden, apierror.CodeInvalidToken, ErrBadRefreshToken.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(cl.SecurityStamp), []byte(usr.SecurityStamp)) != 1 {
+		return nil, apierror.New(http.StatusForbidden, apierror.CodeTokenRevoked, ErrTokenRevoked.Error())
+	}
+
+	return usr, nil
+}
+
+func (t Token) refreshToken(req *restful.Request, res *restful.Response) {
+	usr, err := t.refreshTokenUser(req)
+	if err != nil {
+		metrics.RefreshRejections.Inc(string(apierror.From(err).Code))
+		apierror.Write(req, res, err)
+		return
+	}
 
-		usr, err := t.ds.GetUserByID(u.ID)
+	scope := make([]permission.Permission, 0)
+	for _, v := range req.QueryParameters("scope") {
+		p, err := permission.FromString(v)
 		if err != nil {
-			_ = res.WriteError(http.StatusInternalServerError, err)
+			apierror.Write(req, res, apierror.Invalid(err.Error()))
 			return
 		}
-		at, err := t.generateAccessToken(*usr)
+		scope = append(scope, p)
+	}
+
+	var compact bool
+	switch req.QueryParameter("profile") {
+	case "", profileFull:
+	case profileCompact:
+		compact = true
+	default:
+		apierror.Write(req, res, apierror.Invalid("Unknown token profile"))
+		return
+	}
+
+	if err := usr.Status.Check(time.Now()); err != nil {
+		metrics.RefreshRejections.Inc(string(apierror.From(err).Code))
+		apierror.Write(req, res, err)
+		return
+	}
+
+	var profile gosso.Profile
+	if claims := req.QueryParameters("claims"); len(claims) > 0 {
+		schemas, err := t.ds.GetAttributeSchemas()
 		if err != nil {
-			_ = res.WriteError(http.StatusInternalServerError, err)
+			apierror.Write(req, res, err)
 			return
 		}
-		err = res.WriteAsJson(refreshTokenResponse{Token: at})
+
+		profile, err = auth.SelectProfile(*usr, schemas, claims)
 		if err != nil {
-			_ = res.WriteError(http.StatusInternalServerError, err)
+			apierror.Write(req, res, err)
 			return
 		}
+	}
+
+	at, err := t.generateAccessToken(*usr, req.QueryParameter("audience"), scope, compact, profile)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+	metrics.TokensIssued.Inc("access")
+
+	err = res.WriteAsJson(refreshTokenResponse{Token: at})
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+// signOutEverywhere rotates security stamp of refresh token holder, revoking every refresh token of user.
+// Inactive users may sign out too, so status isn't checked.
+func (t Token) signOutEverywhere(req *restful.Request, res *restful.Response) {
+	usr, err := t.refreshTokenUser(req)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	usr.RotateSecurityStamp()
+	if err := t.ds.UpdateUser(usr); err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+
+	http.SetCookie(res.ResponseWriter, t.cookie.Expired())
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// userInfo returns user with full effective permissions of access token holder
+func (t Token) userInfo(req *restful.Request, res *restful.Response) {
+	u, ok := req.Attribute(gosso.UserAttribute).(*gosso.User)
+	if !ok {
+		apierror.Write(req, res, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "Unauthorized"))
 		return
 	}
 
+	usr, err := t.ds.GetUserByID(u.ID)
 	if err != nil {
-		_ = res.WriteError(http.StatusForbidden, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
 
-	_ = res.WriteErrorString(http.StatusForbidden, "Forbidden")
+	if err := usr.Status.Check(time.Now()); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	usr.Permissions, err = auth.GetEffectivePermissions(t.ds, usr)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(usr.Public())
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
 }
 
 func (t Token) WebService() *restful.WebService {
@@ -123,20 +276,60 @@ func (t Token) WebService() *restful.WebService {
 
 	ws.
 		Path("/token").
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
 
 	ws.Route(ws.GET("/public-key").To(t.publicKey).
 		Doc("get PEM encoded public key").
+		Param(ws.QueryParameter("kid", "key id, current signing key if omitted")).
 		Writes([]byte{}).
 		Returns(http.StatusOK, "OK", []byte{}).
+		Returns(http.StatusNotFound, "Not Found", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
+	ws.Route(ws.GET("/jwks").To(t.jwks).
+		Doc("get JSON Web Key Set of token signing keys").
+		Writes(&gosso.JWKSet{}).
+		Returns(http.StatusOK, "OK", &gosso.JWKSet{}).
 		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
 
 	ws.Route(ws.POST("/refresh").To(t.refreshToken).
 		Doc("get signed access token using refresh token").
+		Param(ws.QueryParameter("audience", "target audience set as aud claim")).
+		Param(ws.QueryParameter("scope", "requested permission subset, access token holds intersection with user permissions").
+			AllowMultiple(true)).
+		Param(ws.QueryParameter("profile", "token claim profile").
+			AllowableValues(map[string]string{
+				profileFull:    "usr claim holding user and permissions",
+				profileCompact: "name claim with scoped permissions or permission digest",
+			}).
+			DefaultValue(profileFull)).
+		Param(ws.QueryParameter("claims", "profile claim to include: email, display_name or custom attribute allowed as claim").
+			AllowMultiple(true)).
 		Writes(&refreshTokenResponse{}).
 		Returns(http.StatusOK, "OK", &refreshTokenResponse{}).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
 		Returns(http.StatusForbidden, "Forbidden", nil).
 		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
 
+	ws.Route(ws.POST("/signout-everywhere").To(t.signOutEverywhere).
+		Doc("revoke every refresh token of refresh token holder and remove refresh token cookie").
+		Returns(http.StatusNoContent, "No Content", nil).
+		Returns(http.StatusBadRequest, "Bad Request", nil).
+		Returns(http.StatusForbidden, "Forbidden", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
+	ws.Route(ws.GET("/userinfo").To(t.userInfo).
+		Filter(apierror.AccessTokenFilter(t.keys)).
+		Metadata(openapi.SecurityKey, openapi.BearerAuth).
+		Doc("get user info with full permission set using access token").
+		Writes(&gosso.User{}).
+		Returns(http.StatusOK, "OK", &gosso.User{}).
+		Returns(http.StatusUnauthorized, "Unauthorized", nil).
+		Returns(http.StatusForbidden, "Account not active", nil).
+		Returns(http.StatusNotFound, "Not Found", nil).
+		Returns(http.StatusInternalServerError, "Internal Server Error", nil))
+
 	return ws
 }
@@ -6,52 +6,58 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/json"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/dfkdream/GoSSO/internal/api/group"
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/setup"
 	"github.com/dfkdream/GoSSO/internal/signin"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
 
+	"github.com/dfkdream/permission"
 	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
 
 	"github.com/dfkdream/GoSSO/internal/auth"
 )
 
-func createTempDS() *auth.DataStore {
-	testDir, err := ioutil.TempDir("", "datastore")
-	//fmt.Println(testDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-	d, err := auth.NewDataStore(filepath.Join(testDir, "test.db"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	return d
+func createTempDS() auth.DataStore {
+	return auth.NewMemoryStore()
 }
 
 func TestToken_WebService(t *testing.T) {
 	ds := createTempDS()
 
+	if _, err := setup.CreateAdmin(ds, "hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+
 	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	h := restful.NewContainer()
-	h.Add(signin.New(ds, pk, 1*time.Second).WebService())
-	tk, err := New(ds, pk, 1*time.Second)
+	h.Add(signin.New(ds, keys, 1*time.Second, auth.DefaultCookieConfig()).WebService())
+	tk, err := New(ds, keys, 1*time.Second, auth.DefaultCookieConfig())
 	if err != nil {
 		t.Error(err)
 	}
 
 	c := restful.NewContainer()
 	c.Add(tk.WebService())
+	c.Add(group.New(ds).WebService())
 
 	// Test GET /token/public-key
 	{
@@ -65,7 +71,38 @@ func TestToken_WebService(t *testing.T) {
 		}
 	}
 
-	// Register user && Generate refresh token
+	// Test GET /token/jwks
+	{
+		req := httptest.NewRequest("GET", "/token/jwks", nil)
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != 200 {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		set := new(gosso.JWKSet)
+		err := json.NewDecoder(res.Body).Decode(set)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if len(set.Keys) != 1 {
+			t.Fatalf("Expected 1 key but got %d", len(set.Keys))
+		}
+
+		k, err := set.Keys[0].PublicKey()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !pk.PublicKey.Equal(k) {
+			t.Error("Expected published key equals signing key")
+		}
+	}
+
+	// Generate refresh token
 	var rTok string
 	{
 		data := url.Values{}
@@ -108,6 +145,362 @@ func TestToken_WebService(t *testing.T) {
 		aTok = resp.Token
 	}
 
+	// Get audience-scoped access token
+	{
+		req := httptest.NewRequest("POST", "/token/refresh?audience=app&scope=gosso:user", nil)
+
+		req.AddCookie(&http.Cookie{
+			Name:  "token",
+			Value: rTok,
+		})
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		resp := new(refreshTokenResponse)
+		err := json.NewDecoder(res.Body).Decode(&resp)
+		if err != nil {
+			t.Error(err)
+		}
+
+		u, ok, err := gosso.ValidateTokenForAudience(resp.Token, pk.Public(), "app")
+		if !ok || err != nil {
+			t.Errorf("Expected valid token but got %v", err)
+		}
+
+		cl, _, err := gosso.ParseClaims(resp.Token, pk.Public())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if cl.Subject != u.ID.String() || cl.ID == "" {
+			t.Errorf("Expected sub and jti claims but got %+v", cl)
+		}
+
+		if len(u.Permissions) != 1 || u.Permissions[0].String() != "+:gosso:user" {
+			t.Errorf("Expected [+:gosso:user] but got %v", u.Permissions)
+		}
+
+		_, ok, err = gosso.ValidateTokenForAudience(resp.Token, pk.Public(), "other")
+		if ok || err != gosso.ErrAudienceMismatch {
+			t.Errorf("Expected ErrAudienceMismatch but got %v", err)
+		}
+	}
+
+	// Get compact access token and fetch full permissions from userinfo
+	{
+		req := httptest.NewRequest("POST", "/token/refresh?profile=compact", nil)
+
+		req.AddCookie(&http.Cookie{
+			Name:  "token",
+			Value: rTok,
+		})
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		resp := new(refreshTokenResponse)
+		err := json.NewDecoder(res.Body).Decode(&resp)
+		if err != nil {
+			t.Error(err)
+		}
+
+		cl, _, err := gosso.ParseClaims(resp.Token, pk.Public())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !cl.IsCompact() || cl.User.Username != "hello" || len(cl.User.Permissions) != 0 {
+			t.Errorf("Expected compact claims but got %+v", cl)
+		}
+
+		req = httptest.NewRequest("GET", "/token/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Token)
+		res = httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", res.Code)
+		}
+
+		u := new(gosso.User)
+		err = json.NewDecoder(res.Body).Decode(u)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if u.ID != cl.User.ID || !cl.MatchesDigest(u.Permissions) {
+			t.Errorf("Expected userinfo matching permission digest but got %+v", u)
+		}
+
+		// Refresh token can't be used to fetch userinfo
+		req = httptest.NewRequest("GET", "/token/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+rTok)
+		res = httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusUnauthorized {
+			t.Errorf("Expected Unauthorized but got %d", res.Code)
+		}
+	}
+
+	// Get access token with selected profile claims
+	{
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u.Email = "hello@example.com"
+		u.DisplayName = "Hello"
+		u.Attributes = map[string]string{"team": "sso", "salary": "1"}
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ds.SetAttributeSchemas([]auth.AttributeSchema{{Name: "team", Claim: true}}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range []struct {
+			query  string
+			status int
+		}{
+			{"?claims=email&claims=team", http.StatusOK},
+			{"?claims=salary", http.StatusBadRequest},
+		} {
+			req := httptest.NewRequest("POST", "/token/refresh"+v.query, nil)
+			req.AddCookie(&http.Cookie{
+				Name:  "token",
+				Value: rTok,
+			})
+			res := httptest.NewRecorder()
+
+			c.ServeHTTP(res, req)
+
+			if res.Code != v.status {
+				t.Errorf("%s: Expected %d but got %d", v.query, v.status, res.Code)
+				continue
+			}
+
+			if res.Code != http.StatusOK {
+				continue
+			}
+
+			resp := new(refreshTokenResponse)
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Error(err)
+			}
+
+			cl, _, err := gosso.ParseClaims(resp.Token, pk.Public())
+			if err != nil {
+				t.Error(err)
+			}
+
+			if cl.Email != u.Email || cl.DisplayName != "" || len(cl.Attributes) != 1 || cl.Attributes["team"] != "sso" {
+				t.Errorf("Expected selected profile claims but got %+v", cl.Profile)
+			}
+		}
+	}
+
+	// Disabled user can't refresh access token
+	{
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u.Status = u.Status.Disable("left", nil)
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/token/refresh", nil)
+		req.AddCookie(&http.Cookie{
+			Name:  "token",
+			Value: rTok,
+		})
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusForbidden {
+			t.Errorf("Expected Forbidden but got %d", res.Code)
+		}
+
+		u.Status = u.Status.Enable(time.Now())
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Refresh token issued before security stamp rotation is revoked
+	{
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u.RotateSecurityStamp()
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		rejections := metrics.RefreshRejections.Value(string(apierror.CodeTokenRevoked))
+
+		req := httptest.NewRequest("POST", "/token/refresh", nil)
+		req.AddCookie(&http.Cookie{
+			Name:  "token",
+			Value: rTok,
+		})
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusForbidden {
+			t.Errorf("Expected Forbidden but got %d", res.Code)
+		}
+
+		e := new(apierror.Response)
+		if err := json.NewDecoder(res.Body).Decode(e); err != nil {
+			t.Error(err)
+		}
+
+		if e.Code != apierror.CodeTokenRevoked || e.RequestID == "" {
+			t.Errorf("Expected token_revoked error but got %+v", e)
+		}
+
+		if n := metrics.RefreshRejections.Value(string(apierror.CodeTokenRevoked)); n != rejections+1 {
+			t.Errorf("Expected %d token_revoked rejections but got %d", rejections+1, n)
+		}
+	}
+
+	// Sign out everywhere revokes refresh token of new session
+	{
+		data := url.Values{}
+		data.Set("username", "hello")
+		data.Add("password", "world")
+
+		req := httptest.NewRequest("POST", "/signin", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		session := res.Result().Cookies()[0].Value
+
+		refresh := func() int {
+			req := httptest.NewRequest("POST", "/token/refresh", nil)
+			req.AddCookie(&http.Cookie{
+				Name:  "token",
+				Value: session,
+			})
+			res := httptest.NewRecorder()
+
+			c.ServeHTTP(res, req)
+			return res.Code
+		}
+
+		if code := refresh(); code != http.StatusOK {
+			t.Errorf("Expected OK but got %d", code)
+		}
+
+		req = httptest.NewRequest("POST", "/token/signout-everywhere", nil)
+		req.AddCookie(&http.Cookie{
+			Name:  "token",
+			Value: session,
+		})
+		res = httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusNoContent {
+			t.Errorf("Expected No Content but got %d", res.Code)
+		}
+
+		if cookies := res.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "" || cookies[0].MaxAge >= 0 {
+			t.Errorf("Expected expired cookie but got %v", cookies)
+		}
+
+		if code := refresh(); code != http.StatusForbidden {
+			t.Errorf("Expected Forbidden but got %d", code)
+		}
+	}
+
+	// Group changes revoke refresh tokens of affected members
+	{
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		grp := &auth.Group{ID: uuid.New(), Name: "staff", Permissions: make([]permission.Permission, 0)}
+		if err := ds.AddGroup(grp); err != nil {
+			t.Fatal(err)
+		}
+
+		signIn := func() string {
+			data := url.Values{}
+			data.Set("username", "hello")
+			data.Add("password", "world")
+
+			req := httptest.NewRequest("POST", "/signin", bytes.NewBufferString(data.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+			res := httptest.NewRecorder()
+
+			h.ServeHTTP(res, req)
+			return res.Result().Cookies()[0].Value
+		}
+
+		refresh := func(session string) int {
+			req := httptest.NewRequest("POST", "/token/refresh", nil)
+			req.AddCookie(&http.Cookie{
+				Name:  "token",
+				Value: session,
+			})
+			res := httptest.NewRecorder()
+
+			c.ServeHTTP(res, req)
+			return res.Code
+		}
+
+		for _, change := range []struct {
+			method, path, body string
+		}{
+			{"PUT", "/group/" + grp.ID.String() + "/members/" + u.ID.String(), ""},
+			{"POST", "/group/" + grp.ID.String() + "/permissions", `["+:staff"]`},
+			{"POST", "/group/" + grp.ID.String() + "/members", `[]`},
+			{"POST", "/group/" + grp.ID.String() + "/members", `["` + u.ID.String() + `"]`},
+			{"DELETE", "/group/" + grp.ID.String() + "/members/" + u.ID.String(), ""},
+		} {
+			session := signIn()
+
+			req := httptest.NewRequest(change.method, change.path, bytes.NewBufferString(change.body))
+			req.Header.Set("Content-Type", restful.MIME_JSON)
+			res := httptest.NewRecorder()
+
+			c.ServeHTTP(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Errorf("%s %s: Expected OK but got %d", change.method, change.path, res.Code)
+			}
+
+			if code := refresh(session); code != http.StatusForbidden {
+				t.Errorf("%s %s: Expected Forbidden but got %d", change.method, change.path, code)
+			}
+		}
+	}
+
 	// Request access token using access token
 	{
 		req := httptest.NewRequest("POST", "/token/refresh", nil)
@@ -1,41 +1,106 @@
 package user
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/asdine/storm/v3"
+	"github.com/dfkdream/GoSSO/internal/apierror"
 	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/metrics"
 	"github.com/dfkdream/permission"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/google/uuid"
 )
 
+const (
+	TotalCountHeader = "X-Total-Count"
+	NextCursorHeader = "X-Next-Cursor"
+)
+
 type User struct {
-	ds *auth.DataStore
+	ds auth.DataStore
 }
 
 type userInfo struct {
 	Username    string                  `json:"username"`
 	Password    string                  `json:"password"`
 	Permissions []permission.Permission `json:"permissions"`
+
+	profileInfo
+}
+
+// profileInfo holds editable profile fields of user
+type profileInfo struct {
+	Email       string            `json:"email,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// validateProfile returns validation error if profile of usr doesn't match attribute schemas
+func (u User) validateProfile(usr *auth.User) error {
+	schemas, err := u.ds.GetAttributeSchemas()
+	if err != nil {
+		return err
+	}
+
+	return auth.ValidateProfile(usr, schemas)
 }
 
-func New(dataStore *auth.DataStore) *User {
+func New(dataStore auth.DataStore) *User {
 	return &User{
 		ds: dataStore,
 	}
 }
 
-func (u User) getUsers(_ *restful.Request, res *restful.Response) {
-	users, err := u.ds.GetAllUsers()
+// getUsers writes page of users. Total count and cursor of next page are returned in headers,
+// so response body stays array of users.
+func (u User) getUsers(req *restful.Request, res *restful.Response) {
+	q := auth.UserQuery{
+		Prefix: req.QueryParameter("prefix"),
+		Sort:   auth.UserSort(req.QueryParameter("sort")),
+		Cursor: req.QueryParameter("cursor"),
+	}
+
+	if l := req.QueryParameter("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil {
+			apierror.Write(req, res, apierror.BadRequest("Invalid limit"))
+			return
+		}
+		q.Limit = limit
+	}
+
+	if p := req.QueryParameter("permission"); p != "" {
+		perm, err := permission.FromString(p)
+		if err != nil {
+			apierror.Write(req, res, apierror.Invalid(err.Error()))
+			return
+		}
+		q.Permission = &perm
+	}
+
+	page, err := u.ds.QueryUsers(q)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, err)
 		return
 	}
 
-	err = res.WriteEntity(users)
+	res.AddHeader(TotalCountHeader, strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		res.AddHeader(NextCursorHeader, page.NextCursor)
+
+		next := *req.Request.URL
+		v := next.Query()
+		v.Set("cursor", page.NextCursor)
+		next.RawQuery = v.Encode()
+		res.AddHeader("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	err = res.WriteEntity(page.Users)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, err)
 		return
 	}
 }
@@ -43,19 +108,19 @@ func (u User) getUsers(_ *restful.Request, res *restful.Response) {
 func (u User) getUser(req *restful.Request, res *restful.Response) {
 	uid, err := uuid.Parse(req.PathParameter("userUUID"))
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
 		return
 	}
 
 	usr, err := u.ds.GetUserByID(uid)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
 
 	err = res.WriteEntity(usr)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, err)
 		return
 	}
 }
@@ -64,17 +129,18 @@ func (u User) addUser(req *restful.Request, res *restful.Response) {
 	uData := new(userInfo)
 	err := req.ReadEntity(uData)
 	if err != nil {
-		_ = res.WriteError(http.StatusBadRequest, err)
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	if uData.Username == "" || uData.Password == "" {
-		_ = res.WriteErrorString(http.StatusBadRequest, "Insufficient request parameters")
+		apierror.Write(req, res, apierror.Invalid("Insufficient request parameters"))
+		return
 	}
 
 	hp, err := auth.HashPassword(uData.Password)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, err)
 		return
 	}
 
@@ -83,22 +149,26 @@ func (u User) addUser(req *restful.Request, res *restful.Response) {
 		Username:    uData.Username,
 		Password:    hp,
 		Permissions: uData.Permissions,
+		Email:       uData.Email,
+		DisplayName: uData.DisplayName,
+		Attributes:  uData.Attributes,
+	}
+
+	if err := u.validateProfile(usr); err != nil {
+		apierror.Write(req, res, err)
+		return
 	}
 
 	err = u.ds.AddUser(usr)
 	if err != nil {
-		if err == storm.ErrAlreadyExists {
-			_ = res.WriteErrorString(http.StatusConflict, "Username already exists")
-			return
-		}
-
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.For("Username", err))
 		return
 	}
+	metrics.UserOperations.Inc("create")
 
 	err = res.WriteEntity(usr.ID)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, err)
 		return
 	}
 }
@@ -106,7 +176,7 @@ func (u User) addUser(req *restful.Request, res *restful.Response) {
 func (u User) deleteUser(req *restful.Request, res *restful.Response) {
 	uid, err := uuid.Parse(req.PathParameter("userUUID"))
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
 		return
 	}
 
@@ -115,28 +185,29 @@ func (u User) deleteUser(req *restful.Request, res *restful.Response) {
 	})
 
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
+	metrics.UserOperations.Inc("delete")
 }
 
 func (u User) updateUserCredentials(req *restful.Request, res *restful.Response) {
 	uData := new(userInfo)
 	err := req.ReadEntity(uData)
 	if err != nil {
-		_ = res.WriteError(http.StatusBadRequest, err)
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	uid, err := uuid.Parse(req.PathParameter("userUUID"))
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
 		return
 	}
 
 	usr, err := u.ds.GetUserByID(uid)
 	if err != nil {
-		_ = res.WriteError(http.StatusNotFound, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
 
@@ -147,48 +218,245 @@ func (u User) updateUserCredentials(req *restful.Request, res *restful.Response)
 	if uData.Password != "" {
 		p, err := auth.HashPassword(uData.Password)
 		if err != nil {
-			_ = res.WriteError(http.StatusInternalServerError, err)
+			apierror.Write(req, res, err)
 			return
 		}
 
 		usr.Password = p
 	}
 
+	usr.RotateSecurityStamp()
+
 	err = u.ds.UpdateUser(usr)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.For("Username", err))
 		return
 	}
+	metrics.UserOperations.Inc("update_credentials")
 }
 
 func (u User) updateUserPerms(req *restful.Request, res *restful.Response) {
 	perm := make([]permission.Permission, 0)
 	err := req.ReadEntity(&perm)
 	if err != nil {
-		_ = res.WriteError(http.StatusBadRequest, err)
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	uid, err := uuid.Parse(req.PathParameter("userUUID"))
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
 		return
 	}
 
 	usr, err := u.ds.GetUserByID(uid)
 	if err != nil {
-		_ = res.WriteError(http.StatusNotFound, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
 
 	usr.Permissions = perm
+	usr.RotateSecurityStamp()
+
+	err = u.ds.UpdateUser(usr)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+	metrics.UserOperations.Inc("update_permissions")
+}
+
+// updateUserProfile replaces email, display name and attributes of user
+func (u User) updateUserProfile(req *restful.Request, res *restful.Response) {
+	p := new(profileInfo)
+	err := req.ReadEntity(p)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	uid, err := uuid.Parse(req.PathParameter("userUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
+		return
+	}
+
+	usr, err := u.ds.GetUserByID(uid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+
+	emailChanged := usr.Email != p.Email
+	if emailChanged {
+		usr.EmailVerified = false
+	}
+
+	usr.Email = p.Email
+	usr.DisplayName = p.DisplayName
+	usr.Attributes = p.Attributes
+
+	if err := u.validateProfile(usr); err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = u.ds.UpdateUser(usr)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+
+	// Reset links sent to previous address must not work anymore
+	if emailChanged {
+		if err := u.ds.DeleteOneTimeTokens(usr.ID, auth.PurposePasswordReset); err != nil {
+			apierror.Write(req, res, err)
+			return
+		}
+	}
+	metrics.UserOperations.Inc("update_profile")
+}
+
+// statusInfo is request body of disable endpoint
+type statusInfo struct {
+	Reason string     `json:"reason,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// setStatus applies f to status of user in path. Operation labels metric of successful change.
+func (u User) setStatus(req *restful.Request, res *restful.Response, operation string, f func(s *auth.AccountStatus)) {
+	uid, err := uuid.Parse(req.PathParameter("userUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
+		return
+	}
+
+	usr, err := u.ds.GetUserByID(uid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+
+	f(&usr.Status)
+
+	if err := usr.Status.Validate(); err != nil {
+		apierror.Write(req, res, apierror.Invalid(err.Error()))
+		return
+	}
+
+	usr.RotateSecurityStamp()
+
+	err = u.ds.UpdateUser(usr)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+	metrics.UserOperations.Inc(operation)
+
+	err = res.WriteEntity(usr.Status)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (u User) updateUserStatus(req *restful.Request, res *restful.Response) {
+	status := new(auth.AccountStatus)
+	err := req.ReadEntity(status)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	u.setStatus(req, res, "update_status", func(s *auth.AccountStatus) {
+		*s = *status
+	})
+}
+
+// disableUser disables user keeping account expiry
+func (u User) disableUser(req *restful.Request, res *restful.Response) {
+	info := new(statusInfo)
+	if req.Request.ContentLength != 0 {
+		err := req.ReadEntity(info)
+		if err != nil {
+			apierror.Write(req, res, apierror.BadRequest(err.Error()))
+			return
+		}
+	}
+
+	u.setStatus(req, res, "disable", func(s *auth.AccountStatus) {
+		*s = s.Disable(info.Reason, info.Until)
+	})
+}
+
+// enableUser activates user. Expiry is cleared only if account has already expired.
+func (u User) enableUser(req *restful.Request, res *restful.Response) {
+	u.setStatus(req, res, "enable", func(s *auth.AccountStatus) {
+		*s = s.Enable(time.Now())
+	})
+}
+
+// signOutUser revokes every refresh token of user
+func (u User) signOutUser(req *restful.Request, res *restful.Response) {
+	uid, err := uuid.Parse(req.PathParameter("userUUID"))
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest("Invalid user UUID"))
+		return
+	}
+
+	usr, err := u.ds.GetUserByID(uid)
+	if err != nil {
+		apierror.Write(req, res, apierror.For("User", err))
+		return
+	}
+
+	usr.RotateSecurityStamp()
 
 	err = u.ds.UpdateUser(usr)
 	if err != nil {
-		_ = res.WriteError(http.StatusInternalServerError, err)
+		apierror.Write(req, res, apierror.For("User", err))
 		return
 	}
+	metrics.UserOperations.Inc("signout")
 
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func (u User) getAttributeSchemas(req *restful.Request, res *restful.Response) {
+	schemas, err := u.ds.GetAttributeSchemas()
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = res.WriteEntity(schemas)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+// setAttributeSchemas replaces every attribute schema. Existing attribute values are validated on next profile update.
+func (u User) setAttributeSchemas(req *restful.Request, res *restful.Response) {
+	schemas := make([]auth.AttributeSchema, 0)
+	err := req.ReadEntity(&schemas)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	err = auth.ValidateAttributeSchemas(schemas)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	err = u.ds.SetAttributeSchemas(schemas)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+	metrics.UserOperations.Inc("set_attribute_schemas")
 }
 
 func (u User) WebService() *restful.WebService {
@@ -197,10 +465,26 @@ func (u User) WebService() *restful.WebService {
 	ws.
 		Path("/user").
 		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
 
 	ws.Route(ws.GET("/").To(u.getUsers).
-		Doc("Get all users").
+		Doc("Get page of users. Total count and next page cursor are returned in X-Total-Count and X-Next-Cursor headers").
+		Param(ws.QueryParameter("prefix", "Username prefix")).
+		Param(ws.QueryParameter("permission", "Permission granted by user permissions")).
+		Param(ws.QueryParameter("sort", "Sort order").
+			AllowableValues(map[string]string{
+				string(auth.SortByUsername):     "username ascending",
+				string(auth.SortByUsernameDesc): "username descending",
+				string(auth.SortByID):           "ID ascending",
+				string(auth.SortByIDDesc):       "ID descending",
+			}).
+			DefaultValue(string(auth.SortByUsername))).
+		Param(ws.QueryParameter("cursor", "Cursor of next page")).
+		Param(ws.QueryParameter("limit", "Page size").
+			DataType("integer").
+			DefaultValue(strconv.Itoa(auth.DefaultPageSize))).
 		Writes(&[]auth.User{}))
 
 	ws.Route(ws.POST("/").To(u.addUser).
@@ -216,12 +500,46 @@ func (u User) WebService() *restful.WebService {
 		Doc("Delete user with provided UUID"))
 
 	ws.Route(ws.POST("/{userUUID}/credential").To(u.updateUserCredentials).
-		Doc("Update user credentials").
-		Reads(&userInfo{}, "permissions field not used"))
+		Doc("Update user credentials. Refresh tokens of user are revoked").
+		Reads(&userInfo{}, "permissions and profile fields not used"))
 
 	ws.Route(ws.POST("/{userUUID}/permissions").To(u.updateUserPerms).
-		Doc("Update user permissions").
+		Doc("Update user permissions. Refresh tokens of user are revoked").
 		Reads([]permission.Permission{}))
 
+	ws.Route(ws.POST("/{userUUID}/profile").To(u.updateUserProfile).
+		Doc("Replace user email, display name and custom attributes").
+		Reads(&profileInfo{}))
+
+	ws.Route(ws.PUT("/{userUUID}/status").To(u.updateUserStatus).
+		Doc("Replace account status including state, reason and expiry").
+		Reads(auth.AccountStatus{}).
+		Writes(auth.AccountStatus{}))
+
+	ws.Route(ws.POST("/{userUUID}/disable").To(u.disableUser).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Disable account. Disabled user can't sign in or refresh tokens").
+		Reads(statusInfo{}, "optional; until re-enables account automatically").
+		Writes(auth.AccountStatus{}))
+
+	ws.Route(ws.POST("/{userUUID}/enable").To(u.enableUser).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Re-enable disabled, locked or expired account").
+		Writes(auth.AccountStatus{}))
+
+	ws.Route(ws.POST("/{userUUID}/signout").To(u.signOutUser).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Sign user out everywhere by revoking every refresh token of user").
+		Returns(http.StatusNoContent, "No Content", nil).
+		Returns(http.StatusNotFound, "Not Found", nil))
+
+	ws.Route(ws.GET("/attribute-schemas").To(u.getAttributeSchemas).
+		Doc("Get custom attribute schemas").
+		Writes([]auth.AttributeSchema{}))
+
+	ws.Route(ws.PUT("/attribute-schemas").To(u.setAttributeSchemas).
+		Doc("Replace custom attribute schemas").
+		Reads([]auth.AttributeSchema{}))
+
 	return ws
 }
@@ -0,0 +1,397 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+func createTempDS() auth.DataStore {
+	return auth.NewMemoryStore()
+}
+
+// serve sends JSON request with body to c
+func serve(c *restful.Container, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if body != "" {
+		req.Header.Set("Content-Type", restful.MIME_JSON)
+	}
+	res := httptest.NewRecorder()
+
+	c.ServeHTTP(res, req)
+	return res
+}
+
+// errorCode decodes error response of res
+func errorCode(t *testing.T, res *httptest.ResponseRecorder) apierror.Code {
+	e := new(apierror.Response)
+	if err := json.NewDecoder(res.Body).Decode(e); err != nil {
+		t.Fatal(err)
+	}
+	return e.Code
+}
+
+func addUser(t *testing.T, ds auth.DataStore, username string) *auth.User {
+	u := &auth.User{ID: uuid.New(), Username: username}
+	u.RotateSecurityStamp()
+	if err := ds.AddUser(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestUser_GetUsers(t *testing.T) {
+	ds := createTempDS()
+
+	c := restful.NewContainer()
+	c.Add(New(ds).WebService())
+
+	for i := 0; i < 3; i++ {
+		addUser(t, ds, fmt.Sprintf("user%d", i))
+	}
+	addUser(t, ds, "admin")
+
+	// Scenario 01 : Total count and next page are returned in headers
+	var next string
+	{
+		res := serve(c, "GET", "/user/?prefix=user&limit=2", "")
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if total := res.Header().Get(TotalCountHeader); total != "3" {
+			t.Errorf("Expected total 3 but got %q", total)
+		}
+
+		next = res.Header().Get(NextCursorHeader)
+		if next == "" {
+			t.Fatal("Expected next cursor")
+		}
+
+		if link := res.Header().Get("Link"); !strings.Contains(link, "cursor=") || !strings.Contains(link, "prefix=user") || !strings.HasSuffix(link, `; rel="next"`) {
+			t.Errorf("Expected link to next page keeping query but got %q", link)
+		}
+
+		var users []auth.User
+		if err := json.NewDecoder(res.Body).Decode(&users); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(users) != 2 || users[0].Username != "user0" || users[1].Username != "user1" {
+			t.Errorf("Unexpected page %+v", users)
+		}
+	}
+
+	// Scenario 02 : Last page has no next page headers
+	{
+		res := serve(c, "GET", "/user/?prefix=user&limit=2&cursor="+next, "")
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if res.Header().Get(NextCursorHeader) != "" || res.Header().Get("Link") != "" {
+			t.Errorf("Expected no next page but got %v", res.Header())
+		}
+
+		var users []auth.User
+		if err := json.NewDecoder(res.Body).Decode(&users); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(users) != 1 || users[0].Username != "user2" {
+			t.Errorf("Unexpected page %+v", users)
+		}
+	}
+
+	// Scenario 03 : Invalid query parameters are rejected
+	{
+		for _, q := range []string{"limit=many", "sort=age", "cursor=invalid", "permission=*:"} {
+			res := serve(c, "GET", "/user/?"+q, "")
+			if res.Code != http.StatusBadRequest {
+				t.Errorf("%s: Expected Bad Request but got %d", q, res.Code)
+			}
+		}
+	}
+}
+
+func TestUser_Profile(t *testing.T) {
+	ds := createTempDS()
+
+	c := restful.NewContainer()
+	c.Add(New(ds).WebService())
+
+	u := addUser(t, ds, "hello")
+	profilePath := "/user/" + u.ID.String() + "/profile"
+
+	// Scenario 01 : Attribute schemas are replaced and read back
+	{
+		res := serve(c, "PUT", "/user/attribute-schemas", `[{"name":"department","required":true,"pattern":"[a-z]+"},{"name":"floor","type":"integer"}]`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		res = serve(c, "GET", "/user/attribute-schemas", "")
+
+		var schemas []auth.AttributeSchema
+		if err := json.NewDecoder(res.Body).Decode(&schemas); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(schemas) != 2 || schemas[0].Name != "department" || !schemas[0].Required || schemas[1].Type != auth.AttributeInteger {
+			t.Errorf("Unexpected schemas %+v", schemas)
+		}
+	}
+
+	// Scenario 02 : Invalid attribute schemas are rejected
+	{
+		for _, body := range []string{`[{"name":"email"}]`, `[{"name":"a"},{"name":"a"}]`, `[{"name":"a","type":"date"}]`, `{}`} {
+			res := serve(c, "PUT", "/user/attribute-schemas", body)
+			if res.Code != http.StatusBadRequest {
+				t.Errorf("%s: Expected Bad Request but got %d", body, res.Code)
+			}
+		}
+	}
+
+	// Scenario 03 : Profile is validated against attribute schemas
+	{
+		for _, body := range []string{
+			`{"email":"hello@example.com"}`,
+			`{"email":"hello@example.com","attributes":{"department":"Sales"}}`,
+			`{"email":"hello@example.com","attributes":{"department":"sales","floor":"first"}}`,
+			`{"email":"hello","attributes":{"department":"sales"}}`,
+			`{"attributes":{"department":"sales","not a name":"x"}}`,
+		} {
+			res := serve(c, "POST", profilePath, body)
+			if res.Code != http.StatusBadRequest || errorCode(t, res) != apierror.CodeValidation {
+				t.Errorf("%s: Expected validation failure but got %d", body, res.Code)
+			}
+		}
+	}
+
+	// Scenario 04 : Valid profile replaces email, display name and attributes
+	{
+		res := serve(c, "POST", profilePath, `{"email":"hello@example.com","displayName":"Hello","attributes":{"department":"sales","floor":"3"}}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		res = serve(c, "GET", "/user/"+u.ID.String(), "")
+
+		got := new(auth.User)
+		if err := json.NewDecoder(res.Body).Decode(got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Email != "hello@example.com" || got.DisplayName != "Hello" || got.Attributes["floor"] != "3" || got.EmailVerified {
+			t.Errorf("Unexpected profile %+v", got)
+		}
+	}
+
+	// Scenario 05 : Email change unverifies email and revokes password reset links
+	{
+		usr, err := ds.GetUserByID(u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		usr.EmailVerified = true
+		if err := ds.UpdateUser(usr); err != nil {
+			t.Fatal(err)
+		}
+
+		_, rec := auth.NewOneTimeToken(auth.PurposePasswordReset, usr, time.Hour)
+		if err := ds.AddOneTimeToken(rec); err != nil {
+			t.Fatal(err)
+		}
+
+		// Unchanged email keeps verification and reset link
+		res := serve(c, "POST", profilePath, `{"email":"hello@example.com","displayName":"Hi","attributes":{"department":"sales"}}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if usr, _ := ds.GetUserByID(u.ID); !usr.EmailVerified {
+			t.Error("Expected email to stay verified")
+		}
+
+		res = serve(c, "POST", profilePath, `{"email":"other@example.com","attributes":{"department":"sales"}}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if usr, _ := ds.GetUserByID(u.ID); usr.EmailVerified {
+			t.Error("Expected changed email to be unverified")
+		}
+
+		if _, err := ds.ConsumeOneTimeToken(auth.PurposePasswordReset, rec.Hash); err != auth.ErrNotFound {
+			t.Errorf("Expected reset token to be deleted but got %v", err)
+		}
+	}
+
+	// Scenario 06 : Profile of unknown user is not found
+	{
+		res := serve(c, "POST", "/user/"+uuid.New().String()+"/profile", `{}`)
+		if res.Code != http.StatusNotFound {
+			t.Errorf("Expected Not Found but got %d", res.Code)
+		}
+	}
+}
+
+func TestUser_Status(t *testing.T) {
+	ds := createTempDS()
+
+	c := restful.NewContainer()
+	c.Add(New(ds).WebService())
+
+	u := addUser(t, ds, "hello")
+	userPath := "/user/" + u.ID.String()
+
+	// readStatus decodes status response and checks that user in data store has it
+	readStatus := func(res *httptest.ResponseRecorder) auth.AccountStatus {
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		var s auth.AccountStatus
+		if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+
+		usr, err := ds.GetUserByID(u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if usr.Status.State != s.State || usr.Status.Reason != s.Reason {
+			t.Errorf("Expected stored status %+v but got %+v", s, usr.Status)
+		}
+
+		if usr.SecurityStamp == u.SecurityStamp {
+			t.Error("Expected security stamp to be rotated")
+		}
+		u.SecurityStamp = usr.SecurityStamp
+
+		return s
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	// Scenario 01 : Status is replaced
+	{
+		s := readStatus(serve(c, "PUT", userPath+"/status", `{"state":"locked","reason":"audit","expiresAt":"`+expires.Format(time.RFC3339)+`"}`))
+		if s.State != auth.StateLocked || s.Reason != "audit" || s.ExpiresAt == nil || !s.ExpiresAt.Equal(expires) {
+			t.Errorf("Unexpected status %+v", s)
+		}
+	}
+
+	// Scenario 02 : Unknown state is rejected
+	{
+		res := serve(c, "PUT", userPath+"/status", `{"state":"frozen"}`)
+		if res.Code != http.StatusBadRequest || errorCode(t, res) != apierror.CodeValidation {
+			t.Errorf("Expected validation failure but got %d", res.Code)
+		}
+	}
+
+	// Scenario 03 : Disable keeps expiry, with or without request body
+	{
+		s := readStatus(serve(c, "POST", userPath+"/disable", `{"reason":"left"}`))
+		if s.State != auth.StateDisabled || s.Reason != "left" || s.Until != nil || s.ExpiresAt == nil {
+			t.Errorf("Unexpected status %+v", s)
+		}
+
+		s = readStatus(serve(c, "POST", userPath+"/disable", ""))
+		if s.State != auth.StateDisabled || s.Reason != "" || s.ExpiresAt == nil {
+			t.Errorf("Unexpected status %+v", s)
+		}
+	}
+
+	// Scenario 04 : Enable keeps expiry in future
+	{
+		s := readStatus(serve(c, "POST", userPath+"/enable", ""))
+		if s.State != auth.StateActive || s.ExpiresAt == nil {
+			t.Errorf("Unexpected status %+v", s)
+		}
+	}
+
+	// Scenario 05 : Status of unknown user is not found
+	{
+		for _, path := range []string{"/disable", "/enable"} {
+			res := serve(c, "POST", "/user/"+uuid.New().String()+path, "")
+			if res.Code != http.StatusNotFound {
+				t.Errorf("%s: Expected Not Found but got %d", path, res.Code)
+			}
+		}
+	}
+}
+
+func TestUser_RevokesTokens(t *testing.T) {
+	ds := createTempDS()
+
+	c := restful.NewContainer()
+	c.Add(New(ds).WebService())
+
+	u := addUser(t, ds, "hello")
+	userPath := "/user/" + u.ID.String()
+
+	// Scenario 01 : Sign out, credential and permission changes rotate security stamp
+	{
+		for _, change := range []struct {
+			path, body string
+			code       int
+		}{
+			{"/signout", "", http.StatusNoContent},
+			{"/credential", `{"password":"world"}`, http.StatusOK},
+			{"/credential", `{"username":"hi"}`, http.StatusOK},
+			{"/permissions", `["+:gosso"]`, http.StatusOK},
+		} {
+			res := serve(c, "POST", userPath+change.path, change.body)
+			if res.Code != change.code {
+				t.Errorf("%s: Expected %d but got %d", change.path, change.code, res.Code)
+			}
+
+			usr, err := ds.GetUserByID(u.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if usr.SecurityStamp == u.SecurityStamp {
+				t.Errorf("%s: Expected security stamp to be rotated", change.path)
+			}
+			u = usr
+		}
+
+		if !u.Password.Validate("world") || u.Username != "hi" || len(u.Permissions) != 1 {
+			t.Errorf("Unexpected user %+v", u)
+		}
+	}
+
+	// Scenario 02 : Profile change keeps security stamp
+	{
+		res := serve(c, "POST", userPath+"/profile", `{"displayName":"Hello"}`)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		if usr, _ := ds.GetUserByID(u.ID); usr.SecurityStamp != u.SecurityStamp {
+			t.Error("Expected security stamp to be kept")
+		}
+	}
+
+	// Scenario 03 : Sign out of unknown user is not found
+	{
+		res := serve(c, "POST", "/user/"+uuid.New().String()+"/signout", "")
+		if res.Code != http.StatusNotFound || errorCode(t, res) != apierror.CodeNotFound {
+			t.Errorf("Expected Not Found but got %d", res.Code)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package apierror writes error responses shared by every service.
+// Errors are JSON objects holding machine-readable code, message and request ID.
+// Storage and validation errors are mapped to status codes, and internal errors are logged instead of exposed.
+package apierror
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries request ID of request and response
+const RequestIDHeader = "X-Request-ID"
+
+// Code is machine-readable error code
+type Code string
+
+const (
+	CodeBadRequest   Code = "bad_request"
+	CodeValidation   Code = "validation_failed"
+	CodeUnauthorized Code = "unauthorized"
+	// CodeInvalidCredentials is reported by sign in for unknown username or wrong password alike
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeForbidden          Code = "forbidden"
+	CodeInvalidToken       Code = "invalid_token"
+	CodeTokenRevoked       Code = "token_revoked"
+	CodeAccountDisabled    Code = "account_disabled"
+	CodeAccountLocked      Code = "account_locked"
+	CodeAccountExpired     Code = "account_expired"
+	CodeNotFound           Code = "not_found"
+	CodeConflict           Code = "conflict"
+	CodeNotImplemented     Code = "not_implemented"
+	CodeUpstream           Code = "upstream_failed"
+	CodeInternal           Code = "internal_error"
+)
+
+// Response is body of error response
+type Response struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Error is error with response status and code
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// New creates error responding status with code and message
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// BadRequest creates 400 error for malformed request
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// Invalid creates 400 error for request failing validation
+func Invalid(message string) *Error {
+	return New(http.StatusBadRequest, CodeValidation, message)
+}
+
+// Forbidden creates 403 error
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+// NotFound creates 404 error for missing resource named what
+func NotFound(what string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, what+" not found")
+}
+
+// Conflict creates 409 error
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+// For maps not found and already exists errors of data store into errors naming resource.
+// Other errors are returned unchanged.
+func For(resource string, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		return NotFound(resource)
+	case errors.Is(err, auth.ErrAlreadyExists):
+		return Conflict(resource + " already exists")
+	}
+	return err
+}
+
+// From maps err into Error. Errors unknown to mapping become internal error without message of err.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var pErr auth.ProfileError
+	switch {
+	case errors.Is(err, auth.ErrNotFound):
+		return NotFound("Resource")
+	case errors.Is(err, auth.ErrAlreadyExists):
+		return Conflict("Resource already exists")
+	case errors.Is(err, auth.ErrAlreadyBootstrapped):
+		return Conflict(err.Error())
+	case errors.Is(err, auth.ErrAccountDisabled):
+		return New(http.StatusForbidden, CodeAccountDisabled, err.Error())
+	case errors.Is(err, auth.ErrAccountLocked):
+		return New(http.StatusForbidden, CodeAccountLocked, err.Error())
+	case errors.Is(err, auth.ErrAccountExpired):
+		return New(http.StatusForbidden, CodeAccountExpired, err.Error())
+	case errors.As(err, &pErr),
+		errors.Is(err, auth.ErrInvalidAttributeSchema),
+		errors.Is(err, auth.ErrUnknownClaim),
+		errors.Is(err, auth.ErrInvalidCursor),
+		errors.Is(err, auth.ErrInvalidSort):
+		return Invalid(err.Error())
+	case errors.Is(err, auth.ErrBackupUnsupported):
+		return New(http.StatusNotImplemented, CodeNotImplemented, err.Error())
+	}
+
+	return New(http.StatusInternalServerError, CodeInternal, http.StatusText(http.StatusInternalServerError))
+}
+
+// Write writes err as error response. Internal errors are logged with request ID.
+func Write(req *restful.Request, res *restful.Response, err error) {
+	status, body := response(res.Header(), req.Request, err)
+	_ = res.WriteHeaderAndJson(status, body, restful.MIME_JSON)
+}
+
+// WriteAuthError writes authentication failure of gosso.Validator as error response
+func WriteAuthError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	code := CodeForbidden
+	if status == http.StatusUnauthorized {
+		code = CodeUnauthorized
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gosso"`)
+	}
+
+	status, body := response(w.Header(), r, New(status, code, err.Error()))
+	w.Header().Set("Content-Type", restful.MIME_JSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// AccessTokenFilter is filter accepting GoSSO access tokens verified with puk and having every permission of perms.
+// Refresh tokens are rejected, and failures are written by WriteAuthError.
+func AccessTokenFilter(puk crypto.PublicKey, perms ...permission.Permission) restful.FilterFunction {
+	return gosso.NewValidator(puk,
+		gosso.WithIssuer(gosso.Issuer),
+		gosso.WithErrorHandler(WriteAuthError),
+		gosso.RejectRefreshTokens(),
+		gosso.RequirePermissions(perms...),
+	).Filter()
+}
+
+// response maps err into status and body of error response
+func response(h http.Header, r *http.Request, err error) (int, Response) {
+	e := From(err)
+	id := requestID(h, r)
+
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v", id, r.Method, r.URL.Path, err)
+	}
+
+	return e.Status, Response{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestID: id,
+	}
+}
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID is filter assigning request ID to request. Valid request ID sent by client is kept.
+func RequestID(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	id := req.HeaderParameter(RequestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = uuid.New().String()
+	}
+
+	res.Header().Set(RequestIDHeader, id)
+	req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), requestIDKey{}, id))
+	chain.ProcessFilter(req, res)
+}
+
+// requestID returns request ID assigned by filter, or assigns new one
+func requestID(h http.Header, r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return id
+	}
+
+	id := uuid.New().String()
+	h.Set(RequestIDHeader, id)
+	return id
+}
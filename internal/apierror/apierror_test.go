@@ -0,0 +1,116 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+)
+
+func TestFrom(t *testing.T) {
+	for i, v := range []struct {
+		err    error
+		status int
+		code   Code
+	}{
+		{NotFound("User"), http.StatusNotFound, CodeNotFound},
+		{auth.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{fmt.Errorf("loading: %w", auth.ErrAlreadyExists), http.StatusConflict, CodeConflict},
+		{For("User", auth.ErrNotFound), http.StatusNotFound, CodeNotFound},
+		{For("User", auth.ErrAlreadyExists), http.StatusConflict, CodeConflict},
+		{auth.ProfileError{Field: "email", Reason: "not an email address"}, http.StatusBadRequest, CodeValidation},
+		{auth.ErrInvalidCursor, http.StatusBadRequest, CodeValidation},
+		{auth.ErrAccountLocked, http.StatusForbidden, CodeAccountLocked},
+		{errors.New("storm: bucket not found"), http.StatusInternalServerError, CodeInternal},
+	} {
+		e := From(v.err)
+		if e.Status != v.status || e.Code != v.code {
+			t.Errorf("%d: Expected %d %s but got %d %s", i, v.status, v.code, e.Status, e.Code)
+		}
+	}
+
+	if e := From(For("User", auth.ErrNotFound)); e.Message != "User not found" {
+		t.Errorf("Expected User not found but got %s", e.Message)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	ws := new(restful.WebService)
+	ws.Path("/test").Filter(RequestID)
+	ws.Route(ws.GET("/internal").To(func(req *restful.Request, res *restful.Response) {
+		Write(req, res, errors.New("storm: secret detail"))
+	}))
+	ws.Route(ws.GET("/missing").To(func(req *restful.Request, res *restful.Response) {
+		Write(req, res, For("User", auth.ErrNotFound))
+	}))
+
+	c := restful.NewContainer()
+	c.Add(ws)
+
+	for i, v := range []struct {
+		path      string
+		requestID string
+		status    int
+		code      Code
+		message   string
+	}{
+		{"/test/internal", "", http.StatusInternalServerError, CodeInternal, "Internal Server Error"},
+		{"/test/missing", "abc-123", http.StatusNotFound, CodeNotFound, "User not found"},
+		{"/test/missing", "bad id\n", http.StatusNotFound, CodeNotFound, "User not found"},
+	} {
+		req := httptest.NewRequest("GET", v.path, nil)
+		if v.requestID != "" {
+			req.Header.Set(RequestIDHeader, v.requestID)
+		}
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != v.status {
+			t.Errorf("%d: Expected %d but got %d", i, v.status, res.Code)
+		}
+
+		body := new(Response)
+		if err := json.NewDecoder(res.Body).Decode(body); err != nil {
+			t.Fatal(err)
+		}
+
+		if body.Code != v.code || body.Message != v.message {
+			t.Errorf("%d: Expected %s %q but got %+v", i, v.code, v.message, body)
+		}
+
+		id := res.Header().Get(RequestIDHeader)
+		if id == "" || body.RequestID != id {
+			t.Errorf("%d: Expected request ID %q in body but got %q", i, id, body.RequestID)
+		}
+
+		if v.requestID == "abc-123" && id != v.requestID {
+			t.Errorf("%d: Expected request ID of client but got %q", i, id)
+		}
+	}
+}
+
+func TestWriteAuthError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+
+	WriteAuthError(res, req, http.StatusUnauthorized, errors.New("gosso: token is expired"))
+
+	if res.Code != http.StatusUnauthorized || res.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected 401 with WWW-Authenticate but got %d", res.Code)
+	}
+
+	body := new(Response)
+	if err := json.NewDecoder(res.Body).Decode(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Code != CodeUnauthorized || body.RequestID == "" {
+		t.Errorf("Expected unauthorized with request ID but got %+v", body)
+	}
+}
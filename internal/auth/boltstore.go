@@ -0,0 +1,655 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm/v3"
+	"github.com/asdine/storm/v3/codec/gob"
+	"github.com/asdine/storm/v3/q"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore stores users and groups in bolt file using storm.
+// Bolt file can be opened by only one process at a time.
+type BoltStore struct {
+	db *storm.DB
+}
+
+// NewDataStore opens data store and applies pending migrations.
+// Data store holding users is copied to file next to path named by time before migrations are applied.
+func NewDataStore(path string) (*BoltStore, error) {
+	d, err := OpenDataStore(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", path, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := migrateOnOpen(d, backup); err != nil {
+		_ = d.Close()
+		return nil, fmt.Errorf("migrating %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// OpenDataStore opens data store waiting for file lock held by other process up to timeout.
+// Zero timeout waits indefinitely. Migrations are not applied, so pending ones can be inspected with Migrate.
+func OpenDataStore(path string, timeout time.Duration) (*BoltStore, error) {
+	db, err := storm.Open(path, storm.Codec(gob.Codec), storm.BoltOptions(0600, &bolt.Options{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+const (
+	metaBucket       = "meta"
+	schemaVersionKey = "schemaVersion"
+	attributesKey    = "attributeSchemas"
+)
+
+type boltMigration struct {
+	Migration
+	up func(tx storm.Node) error
+}
+
+// boltMigrations must stay in version order. Released migrations must not be changed.
+var boltMigrations = []boltMigration{
+	{
+		Migration: Migration{Version: 1, Description: "Re-encode users and groups with current record layout"},
+		up: func(tx storm.Node) error {
+			users := make([]User, 0)
+			if err := tx.All(&users); err != nil {
+				return err
+			}
+
+			for i := range users {
+				if err := tx.Save(&users[i]); err != nil {
+					return err
+				}
+			}
+
+			groups := make([]Group, 0)
+			if err := tx.All(&groups); err != nil {
+				return err
+			}
+
+			for i := range groups {
+				if err := tx.Save(&groups[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+func (d BoltStore) SchemaVersion() (int, error) {
+	var v int
+	err := d.db.Get(metaBucket, schemaVersionKey, &v)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (d BoltStore) Migrations() []Migration {
+	m := make([]Migration, len(boltMigrations))
+	for i := range boltMigrations {
+		m[i] = boltMigrations[i].Migration
+	}
+	return m
+}
+
+// Backup writes copy of bolt file. Data store stays readable and writable while backup is taken.
+func (d BoltStore) Backup(w io.Writer) error {
+	return d.db.Bolt.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// RestoreBoltFile replaces bolt file at path with backup. Backup is validated before replacing,
+// and path must not be opened by other process, waiting for its file lock up to timeout.
+func RestoreBoltFile(backup, path string, timeout time.Duration) error {
+	b, err := OpenDataStore(backup, timeout)
+	if err != nil {
+		return err
+	}
+
+	_, err = PendingMigrations(b)
+	if err == nil {
+		_, err = b.GetAllUsers()
+	}
+	_ = b.Close()
+	if err != nil {
+		return fmt.Errorf("invalid backup %s: %w", backup, err)
+	}
+
+	// Hold lock of current file while copying so running instance isn't overwritten
+	cur, err := OpenDataStore(path, timeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = cur.Close() }()
+
+	src, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	tmp := path + ".restore"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func (d BoltStore) applyMigration(version int) error {
+	for _, m := range boltMigrations {
+		if m.Version != version {
+			continue
+		}
+
+		tx, err := d.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		if err := m.up(tx); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+
+		if err := tx.Set(metaBucket, schemaVersionKey, version); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+	return fmt.Errorf("migration %d: %w", version, ErrNotFound)
+}
+
+// stormError translates storm errors into data store errors
+func stormError(err error) error {
+	switch err {
+	case storm.ErrNotFound:
+		return ErrNotFound
+	case storm.ErrAlreadyExists:
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (d BoltStore) Close() error {
+	return d.db.Close()
+}
+
+func (d BoltStore) AddUser(user *User) error {
+	if _, err := d.GetUserByID(user.ID); err == nil {
+		return ErrAlreadyExists // prevent overriding
+	}
+
+	return stormError(d.db.Save(user))
+}
+
+// Bootstrap adds first user of data store. User count is checked in same write transaction,
+// so only one of concurrent bootstrap attempts succeeds.
+func (d BoltStore) Bootstrap(user *User) error {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	n, err := tx.Count(&User{})
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return ErrAlreadyBootstrapped
+	}
+
+	if err := tx.Save(user); err != nil {
+		return stormError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (d BoltStore) UpdateUser(user *User) error {
+	if _, err := d.GetUserByID(user.ID); err != nil {
+		return err
+	}
+
+	return stormError(d.db.Save(user))
+}
+
+func (d BoltStore) DeleteUser(user *User) error {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Groups are read on write transaction, so member added concurrently isn't left dangling
+	groups, err := groupsByMember(tx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range groups {
+		groups[i].RemoveMember(user.ID)
+		if err := tx.Save(&groups[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.DeleteStruct(user); err != nil {
+		return stormError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (d BoltStore) GetUserByID(id uuid.UUID) (*User, error) {
+	user := new(User)
+	err := d.db.One("ID", id, user)
+	if err != nil {
+		return nil, stormError(err)
+	}
+	return user, nil
+}
+
+func (d BoltStore) GetUserByUsername(username string) (*User, error) {
+	user := new(User)
+	err := d.db.One("Username", username, user)
+	if err != nil {
+		return nil, stormError(err)
+	}
+	return user, nil
+}
+
+func (d BoltStore) Size() int {
+	s, err := d.db.Count(&User{})
+	if err != nil {
+		log.Println(err)
+		return -1
+	}
+	return s
+}
+
+func (d BoltStore) GetAllUsers() ([]User, error) {
+	uList := make([]User, 0)
+	err := d.db.All(&uList)
+	if err != nil {
+		return nil, err
+	}
+	return uList, nil
+}
+
+// QueryUsers walks username index from cursor, so only users of requested page are decoded.
+// Queries sorted by ID or filtered by permission stream every user through query pager instead.
+func (d BoltStore) QueryUsers(query UserQuery) (*UserPage, error) {
+	p, err := newUserPager(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.query.Permission == nil && (p.query.Sort == SortByUsername || p.query.Sort == SortByUsernameDesc) {
+		var page *UserPage
+		err := d.db.Bolt.View(func(tx *bolt.Tx) (err error) {
+			page, err = d.walkUsernameIndex(tx, p)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+
+	err = d.db.Select().Each(new(User), func(record interface{}) error {
+		p.add(*record.(*User))
+		return nil
+	})
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return p.result(), nil
+}
+
+// usernameIndex is bucket storm keeps unique index of User.Username in, mapping username to record key
+const usernameIndex = "__storm_index_Username"
+
+// walkUsernameIndex decodes page of users after cursor and counts users matching prefix on index keys.
+// Usernames are unique, so index order is query order.
+func (d BoltStore) walkUsernameIndex(tx *bolt.Tx, p *userPager) (*UserPage, error) {
+	page := make([]User, 0, p.query.Limit+1)
+
+	users := tx.Bucket([]byte("User"))
+	if users == nil {
+		return p.ordered(page, 0), nil
+	}
+	idx := users.Bucket([]byte(usernameIndex))
+	if idx == nil {
+		return p.ordered(page, 0), nil
+	}
+
+	prefix := []byte(p.query.Prefix)
+	desc := p.query.Sort == SortByUsernameDesc
+
+	c := idx.Cursor()
+	total := 0
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		total++
+	}
+
+	// last positions cursor on last username with prefix
+	last := func() ([]byte, []byte) {
+		end := prefixEnd(prefix)
+		if k, _ := c.Seek(end); k == nil || end == nil {
+			return c.Last()
+		}
+		return c.Prev()
+	}
+
+	var k, key []byte
+	switch {
+	case p.after != nil && !desc:
+		k, key = c.Seek([]byte(p.after.Username))
+		if k != nil && string(k) == p.after.Username {
+			k, key = c.Next()
+		}
+		if k != nil && bytes.Compare(k, prefix) < 0 {
+			k, key = c.Seek(prefix)
+		}
+	case p.after != nil:
+		// Seek lands on cursor or first username after it, so previous key is first of page
+		if k, _ = c.Seek([]byte(p.after.Username)); k == nil {
+			k, key = c.Last()
+		} else {
+			k, key = c.Prev()
+		}
+		if k != nil && !bytes.HasPrefix(k, prefix) && bytes.Compare(k, prefix) > 0 {
+			k, key = last()
+		}
+	case !desc:
+		k, key = c.Seek(prefix)
+	default:
+		k, key = last()
+	}
+
+	for k != nil && bytes.HasPrefix(k, prefix) && len(page) <= p.query.Limit {
+		var u User
+		if err := d.db.Codec().Unmarshal(users.Get(key), &u); err != nil {
+			return nil, err
+		}
+		page = append(page, u)
+
+		if desc {
+			k, key = c.Prev()
+		} else {
+			k, key = c.Next()
+		}
+	}
+
+	return p.ordered(page, total), nil
+}
+
+// prefixEnd returns smallest key greater than every key with prefix, or nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+func (d BoltStore) AddGroup(group *Group) error {
+	if _, err := d.GetGroupByID(group.ID); err == nil {
+		return ErrAlreadyExists // prevent overriding
+	}
+
+	return stormError(d.db.Save(group))
+}
+
+func (d BoltStore) UpdateGroup(group *Group) error {
+	if _, err := d.GetGroupByID(group.ID); err != nil {
+		return err
+	}
+
+	return stormError(d.db.Save(group))
+}
+
+func (d BoltStore) DeleteGroup(group *Group) error {
+	return stormError(d.db.DeleteStruct(group))
+}
+
+// editGroup applies edit to group on write transaction and saves it unless edit fails
+func (d BoltStore) editGroup(id uuid.UUID, edit func(g *Group) error) error {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	g := new(Group)
+	if err := tx.One("ID", id, g); err != nil {
+		return stormError(err)
+	}
+
+	if err := edit(g); err != nil {
+		return err
+	}
+
+	if err := tx.Save(g); err != nil {
+		return stormError(err)
+	}
+
+	return tx.Commit()
+}
+
+func (d BoltStore) AddGroupMember(groupID, userID uuid.UUID) (bool, error) {
+	added := false
+	err := d.editGroup(groupID, func(g *Group) error {
+		added = g.AddMember(userID)
+		return nil
+	})
+	return added, err
+}
+
+func (d BoltStore) RemoveGroupMember(groupID, userID uuid.UUID) error {
+	return d.editGroup(groupID, func(g *Group) error {
+		if !g.RemoveMember(userID) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (d BoltStore) SetGroupMembers(groupID uuid.UUID, members []uuid.UUID) ([]uuid.UUID, error) {
+	var old []uuid.UUID
+	err := d.editGroup(groupID, func(g *Group) error {
+		old = g.Members
+		g.Members = members
+		return nil
+	})
+	return old, err
+}
+
+func (d BoltStore) GetGroupByID(id uuid.UUID) (*Group, error) {
+	group := new(Group)
+	err := d.db.One("ID", id, group)
+	if err != nil {
+		return nil, stormError(err)
+	}
+	return group, nil
+}
+
+func (d BoltStore) GetGroupByName(name string) (*Group, error) {
+	group := new(Group)
+	err := d.db.One("Name", name, group)
+	if err != nil {
+		return nil, stormError(err)
+	}
+	return group, nil
+}
+
+func (d BoltStore) GetAllGroups() ([]Group, error) {
+	gList := make([]Group, 0)
+	err := d.db.All(&gList)
+	if err != nil {
+		return nil, err
+	}
+	return gList, nil
+}
+
+func (d BoltStore) GetGroupsByMember(id uuid.UUID) ([]Group, error) {
+	return groupsByMember(d.db, id)
+}
+
+// groupsByMember reads groups having member id from n, which is either data store or transaction
+func groupsByMember(n storm.Node, id uuid.UUID) ([]Group, error) {
+	all := make([]Group, 0)
+	if err := n.All(&all); err != nil {
+		return nil, err
+	}
+
+	gList := make([]Group, 0)
+	for _, g := range all {
+		if g.HasMember(id) {
+			gList = append(gList, g)
+		}
+	}
+
+	sort.Slice(gList, func(i, j int) bool {
+		return gList[i].Name < gList[j].Name
+	})
+
+	return gList, nil
+}
+
+func (d BoltStore) GetAttributeSchemas() ([]AttributeSchema, error) {
+	schemas := make([]AttributeSchema, 0)
+	err := d.db.Get(metaBucket, attributesKey, &schemas)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+func (d BoltStore) SetAttributeSchemas(schemas []AttributeSchema) error {
+	s := append(make([]AttributeSchema, 0, len(schemas)), schemas...)
+	SortAttributeSchemas(s)
+	return d.db.Set(metaBucket, attributesKey, s)
+}
+
+func (d BoltStore) AddOneTimeToken(token *OneTimeToken) error {
+	var existing OneTimeToken
+	if err := d.db.One("Hash", token.Hash, &existing); err == nil {
+		return ErrAlreadyExists
+	}
+	return d.db.Save(token)
+}
+
+func (d BoltStore) ConsumeOneTimeToken(purpose TokenPurpose, hash string) (*OneTimeToken, error) {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	t := new(OneTimeToken)
+	if err := tx.One("Hash", hash, t); err != nil {
+		return nil, stormError(err)
+	}
+
+	if t.Purpose != purpose {
+		return nil, ErrNotFound
+	}
+
+	if err := tx.DeleteStruct(t); err != nil {
+		return nil, err
+	}
+
+	return t, tx.Commit()
+}
+
+func (d BoltStore) DeleteOneTimeTokens(userID uuid.UUID, purpose TokenPurpose) error {
+	err := d.db.Select(q.Eq("UserID", userID), q.Eq("Purpose", purpose)).Delete(new(OneTimeToken))
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (d BoltStore) AddInvitation(invitation *Invitation) error {
+	var existing Invitation
+	if err := d.db.One("ID", invitation.ID, &existing); err == nil {
+		return ErrAlreadyExists
+	}
+	return stormError(d.db.Save(invitation))
+}
+
+func (d BoltStore) GetAllInvitations() ([]Invitation, error) {
+	iList := make([]Invitation, 0)
+	if err := d.db.All(&iList); err != nil {
+		return nil, err
+	}
+
+	sortInvitations(iList)
+	return iList, nil
+}
+
+func (d BoltStore) DeleteInvitation(id uuid.UUID) error {
+	return stormError(d.db.DeleteStruct(&Invitation{ID: id}))
+}
+
+func (d BoltStore) ConsumeInvitation(hash string) (*Invitation, error) {
+	tx, err := d.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	i := new(Invitation)
+	if err := tx.One("Hash", hash, i); err != nil {
+		return nil, stormError(err)
+	}
+
+	if err := tx.DeleteStruct(i); err != nil {
+		return nil, err
+	}
+
+	return i, tx.Commit()
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieConfig describes the cookie used to carry refresh token.
+// Domain can be set to parent domain (e.g. corp.example) to share session across subdomains.
+type CookieConfig struct {
+	Name     string
+	Domain   string
+	Path     string
+	SameSite http.SameSite
+	Secure   bool
+
+	// Persistent cookie expires with refresh token. Session cookie is removed when browser closes.
+	Persistent bool
+}
+
+// DefaultCookieConfig returns cookie configuration compatible with previous hardcoded values
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     "token",
+		Domain:   "",
+		Path:     "",
+		SameSite: 0,
+		Secure:   true,
+	}
+}
+
+// Cookie creates HTTPOnly cookie holding value
+func (c CookieConfig) Cookie(value string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+
+	if c.Persistent {
+		cookie.Expires = expires
+		cookie.MaxAge = int(time.Until(expires).Seconds())
+	}
+
+	return cookie
+}
+
+// Expired creates cookie removing refresh token cookie from browser
+func (c CookieConfig) Expired() *http.Cookie {
+	cookie := c.Cookie("", time.Unix(0, 0))
+	cookie.Expires = time.Unix(0, 0)
+	cookie.MaxAge = -1
+	return cookie
+}
+
+// ParseSameSite converts lax, strict, none or default into http.SameSite
+// Empty string leaves SameSite attribute unset.
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "default":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode: %s", s)
+}
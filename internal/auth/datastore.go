@@ -1,76 +1,85 @@
 package auth
 
 import (
-	"log"
+	"errors"
 
-	"github.com/asdine/storm/v3"
-	"github.com/asdine/storm/v3/codec/gob"
+	"github.com/dfkdream/permission"
 	"github.com/google/uuid"
 )
 
-type DataStore struct {
-	db *storm.DB
-}
+var (
+	ErrNotFound      = errors.New("datastore: not found")
+	ErrAlreadyExists = errors.New("datastore: already exists")
 
-func NewDataStore(path string) (*DataStore, error) {
-	db, err := storm.Open(path, storm.Codec(gob.Codec))
-	if err != nil {
-		return nil, err
-	}
+	// ErrAlreadyBootstrapped is returned by Bootstrap if any user exists
+	ErrAlreadyBootstrapped = errors.New("datastore: already bootstrapped")
+)
 
-	return &DataStore{
-		db: db,
-	}, nil
-}
+// DataStore stores users and groups.
+// Lookups return ErrNotFound for missing records,
+// and adds and updates return ErrAlreadyExists on ID, username or group name conflict.
+type DataStore interface {
+	Close() error
 
-func (d DataStore) Size() int {
-	s, err := d.db.Count(&User{})
-	if err != nil {
-		log.Println(err)
-		return -1
-	}
-	return s
-}
+	AddUser(user *User) error
+	// Bootstrap adds user only if data store has no user. Concurrent attempts are serialized.
+	Bootstrap(user *User) error
+	// UpdateUser overwrites whole user
+	UpdateUser(user *User) error
+	// DeleteUser deletes user and removes it from every group
+	DeleteUser(user *User) error
+	GetUserByID(id uuid.UUID) (*User, error)
+	GetUserByUsername(username string) (*User, error)
+	GetAllUsers() ([]User, error)
+	// Size returns number of users, or -1 if counting failed
+	Size() int
+	// QueryUsers returns page of users matching query
+	QueryUsers(query UserQuery) (*UserPage, error)
 
-func (d DataStore) AddUser(user *User) error {
-	if _, err := d.GetUserByID(user.ID); err == nil {
-		return storm.ErrAlreadyExists // prevent overriding
-	}
+	AddGroup(group *Group) error
+	// UpdateGroup overwrites whole group so members and permissions can be cleared
+	UpdateGroup(group *Group) error
+	DeleteGroup(group *Group) error
+	GetGroupByID(id uuid.UUID) (*Group, error)
+	GetGroupByName(name string) (*Group, error)
+	GetAllGroups() ([]Group, error)
+	// GetGroupsByMember returns groups containing user sorted by name
+	GetGroupsByMember(id uuid.UUID) ([]Group, error)
+	// AddGroupMember adds user to group in single transaction, so concurrent member changes aren't lost.
+	// It returns false if user is already member.
+	AddGroupMember(groupID, userID uuid.UUID) (bool, error)
+	// RemoveGroupMember removes user from group in single transaction. Missing group or member returns ErrNotFound.
+	RemoveGroupMember(groupID, userID uuid.UUID) error
+	// SetGroupMembers replaces members of group in single transaction and returns members it replaced
+	SetGroupMembers(groupID uuid.UUID, members []uuid.UUID) ([]uuid.UUID, error)
 
-	return d.db.Save(user)
-}
+	// GetAttributeSchemas returns custom attribute schemas sorted by name
+	GetAttributeSchemas() ([]AttributeSchema, error)
+	// SetAttributeSchemas replaces every attribute schema. Stored attribute values are not revalidated.
+	SetAttributeSchemas(schemas []AttributeSchema) error
 
-func (d DataStore) UpdateUser(user *User) error {
-	return d.db.Update(user)
-}
-
-func (d DataStore) DeleteUser(user *User) error {
-	return d.db.DeleteStruct(user)
-}
+	AddOneTimeToken(token *OneTimeToken) error
+	// ConsumeOneTimeToken deletes and returns token with hash and purpose, expired or not.
+	// Only one of concurrent consumers gets token, and others get ErrNotFound.
+	ConsumeOneTimeToken(purpose TokenPurpose, hash string) (*OneTimeToken, error)
+	// DeleteOneTimeTokens deletes every token of user with purpose
+	DeleteOneTimeTokens(userID uuid.UUID, purpose TokenPurpose) error
 
-func (d DataStore) GetUserByID(id uuid.UUID) (*User, error) {
-	user := new(User)
-	err := d.db.One("ID", id, user)
-	if err != nil {
-		return nil, err
-	}
-	return user, nil
+	AddInvitation(invitation *Invitation) error
+	// GetAllInvitations returns unaccepted invitations including expired ones, sorted by creation time
+	GetAllInvitations() ([]Invitation, error)
+	DeleteInvitation(id uuid.UUID) error
+	// ConsumeInvitation deletes and returns invitation with token hash, expired or not.
+	// Only one of concurrent consumers gets invitation, and others get ErrNotFound.
+	ConsumeInvitation(hash string) (*Invitation, error)
 }
 
-func (d DataStore) GetUserByUsername(username string) (*User, error) {
-	user := new(User)
-	err := d.db.One("Username", username, user)
+// GetEffectivePermissions returns user permissions merged with permissions of groups user belongs to
+func GetEffectivePermissions(ds DataStore, user *User) ([]permission.Permission, error) {
+	groups, err := ds.GetGroupsByMember(user.ID)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
-}
 
-func (d DataStore) GetAllUsers() ([]User, error) {
-	uList := make([]User, 0)
-	err := d.db.All(&uList)
-	if err != nil {
-		return nil, err
-	}
-	return uList, nil
+	return EffectivePermissions(*user, groups), nil
 }
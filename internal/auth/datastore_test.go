@@ -1,24 +1,28 @@
 package auth
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
-
-	"github.com/asdine/storm/v3"
+	"time"
 
 	"github.com/dfkdream/permission"
 
 	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func compareUser(u1, u2 User) bool {
 	return fmt.Sprintf("%+v", u1) == fmt.Sprintf("%+v", u2)
 }
 
-func createTempDS() *DataStore {
+func createTempDS() DataStore {
 	testDir, err := ioutil.TempDir("", "datastore")
 	//fmt.Println(testDir)
 	if err != nil {
@@ -31,6 +35,43 @@ func createTempDS() *DataStore {
 	return d
 }
 
+func createTempSQLStore() DataStore {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(testDir, "test.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	d, err := NewSQLStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return d
+}
+
+// forEachDataStore runs test against every data store implementation
+func forEachDataStore(t *testing.T, test func(t *testing.T, ds DataStore)) {
+	stores := map[string]func() DataStore{
+		"bolt":   createTempDS,
+		"memory": func() DataStore { return NewMemoryStore() },
+		"sql":    createTempSQLStore,
+	}
+
+	for name, create := range stores {
+		t.Run(name, func(t *testing.T) {
+			ds := create()
+			defer func() { _ = ds.Close() }()
+
+			test(t, ds)
+		})
+	}
+}
+
 func mustHashPassword(password string) Password {
 	p, err := HashPassword(password)
 	if err != nil {
@@ -44,273 +85,992 @@ func TestNewDataStore(t *testing.T) {
 }
 
 func TestDataStore_AddUser(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1.Username = "hello-a"
-	err = ds.AddUser(u1)
-	if err == nil {
-		t.Error("Expected ErrAlreadyExists error but got nil")
-	}
+		u1.Username = "hello-a"
+		err = ds.AddUser(u1)
+		if err == nil {
+			t.Error("Expected ErrAlreadyExists error but got nil")
+		}
 
-	u1.ID = uuid.New()
-	u1.Username = "hello"
-	err = ds.AddUser(u1)
-	if err != storm.ErrAlreadyExists {
-		t.Error("Expected ErrAlreadyExists error but got nil")
-	}
+		u1.ID = uuid.New()
+		u1.Username = "hello"
+		err = ds.AddUser(u1)
+		if err != ErrAlreadyExists {
+			t.Error("Expected ErrAlreadyExists error but got nil")
+		}
+	})
 }
 
 func TestDataStore_GetUserByID(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	_, err = ds.GetUserByID(uuid.New())
-	if err != storm.ErrNotFound {
-		t.Error(err)
-	}
+		_, err = ds.GetUserByID(uuid.New())
+		if err != ErrNotFound {
+			t.Error(err)
+		}
 
-	u, err := ds.GetUserByID(u1.ID)
-	if err != nil {
-		t.Error(err)
-	}
+		u, err := ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Error(err)
+		}
 
-	if !compareUser(*u1, *u) {
-		t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
-	}
+		if !compareUser(*u1, *u) {
+			t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
+		}
+	})
 }
 
 func TestDataStore_GetUserByUsername(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	_, err = ds.GetUserByUsername("hello-a")
-	if err != storm.ErrNotFound {
-		t.Error(err)
-	}
+		_, err = ds.GetUserByUsername("hello-a")
+		if err != ErrNotFound {
+			t.Error(err)
+		}
 
-	u, err := ds.GetUserByUsername(u1.Username)
-	if err != nil {
-		t.Error(err)
-	}
+		u, err := ds.GetUserByUsername(u1.Username)
+		if err != nil {
+			t.Error(err)
+		}
 
-	if !compareUser(*u1, *u) {
-		t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
-	}
+		if !compareUser(*u1, *u) {
+			t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
+		}
+	})
 }
 
 func TestDataStore_UpdateUser(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1.Username = "hola"
-	err = ds.UpdateUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		u1.Username = "hola"
+		err = ds.UpdateUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	_, err = ds.GetUserByUsername("hello")
-	if err != storm.ErrNotFound {
-		t.Error(err)
-	}
+		_, err = ds.GetUserByUsername("hello")
+		if err != ErrNotFound {
+			t.Error(err)
+		}
 
-	u, err := ds.GetUserByID(u1.ID)
-	if err != nil {
-		t.Error(err)
-	}
+		u, err := ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Error(err)
+		}
 
-	if !compareUser(*u1, *u) {
-		t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
-	}
+		if !compareUser(*u1, *u) {
+			t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
+		}
+	})
 }
 
 func TestDataStore_DeleteUser(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	err = ds.DeleteUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.DeleteUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	_, err = ds.GetUserByID(u1.ID)
-	if err != storm.ErrNotFound {
-		t.Error(err)
-	}
+		_, err = ds.GetUserByID(u1.ID)
+		if err != ErrNotFound {
+			t.Error(err)
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	err = ds.DeleteUser(&User{
-		ID: u1.ID,
-	})
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.DeleteUser(&User{
+			ID: u1.ID,
+		})
+		if err != nil {
+			t.Error(err)
+		}
 
-	_, err = ds.GetUserByID(u1.ID)
-	if err != storm.ErrNotFound {
-		t.Error(err)
-	}
+		_, err = ds.GetUserByID(u1.ID)
+		if err != ErrNotFound {
+			t.Error(err)
+		}
+	})
 }
 
 func TestDataStore_Size(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		if s := ds.Size(); s != 0 {
+			t.Errorf("Expected Size()==0 but got %d", s)
+		}
 
-	if s := ds.Size(); s != 0 {
-		t.Errorf("Expected Size()==0 but got %d", s)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		if s := ds.Size(); s != 1 {
+			t.Errorf("Expected Size()==1 but got %d", s)
+		}
 
-	if s := ds.Size(); s != 1 {
-		t.Errorf("Expected Size()==1 but got %d", s)
-	}
+		u1.ID = uuid.New()
+		u1.Username = "hola"
 
-	u1.ID = uuid.New()
-	u1.Username = "hola"
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
-
-	if s := ds.Size(); s != 2 {
-		t.Errorf("Expected Size()==2 but got %d", s)
-	}
+		if s := ds.Size(); s != 2 {
+			t.Errorf("Expected Size()==2 but got %d", s)
+		}
+	})
 }
 
 func TestDataStore_GetAllUsers(t *testing.T) {
-	ds := createTempDS()
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
 
-	p, err := permission.FromString("+:sso")
-	if err != nil {
-		t.Error(err)
-	}
+		p, err := permission.FromString("+:sso")
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1 := &User{
-		ID:          uuid.New(),
-		Username:    "hello",
-		Password:    mustHashPassword("world"),
-		Permissions: []permission.Permission{p},
-	}
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{p},
+		}
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
-	}
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
 
-	u1.ID = uuid.New()
-	u1.Username = "hola"
+		u1.ID = uuid.New()
+		u1.Username = "hola"
 
-	err = ds.AddUser(u1)
-	if err != nil {
-		t.Error(err)
+		err = ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		all, err := ds.GetAllUsers()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if len(all) != 2 {
+			t.Errorf("Expeted len(all)==2 but got %d", len(all))
+		}
+	})
+}
+
+func TestDataStore_Groups(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+
+		u1 := &User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Password:    mustHashPassword("world"),
+			Permissions: []permission.Permission{mustPermission("+:sso")},
+		}
+
+		err := ds.AddUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		g1 := &Group{
+			ID:          uuid.New(),
+			Name:        "admins",
+			Permissions: []permission.Permission{mustPermission("+:gosso")},
+			Members:     []uuid.UUID{u1.ID},
+		}
+
+		err = ds.AddGroup(g1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = ds.AddGroup(&Group{ID: uuid.New(), Name: "admins"})
+		if err != ErrAlreadyExists {
+			t.Errorf("Expected ErrAlreadyExists but got %v", err)
+		}
+
+		g, err := ds.GetGroupByName("admins")
+		if err != nil {
+			t.Error(err)
+		}
+
+		if g.ID != g1.ID {
+			t.Errorf("Expected %s but got %s", g1.ID, g.ID)
+		}
+
+		perms, err := GetEffectivePermissions(ds, u1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !mustPermission("gosso").HasPermission(perms) || !mustPermission("sso").HasPermission(perms) {
+			t.Errorf("Expected merged permissions but got %v", perms)
+		}
+
+		g1.Members = nil
+		err = ds.UpdateGroup(g1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		groups, err := ds.GetGroupsByMember(u1.ID)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if len(groups) != 0 {
+			t.Errorf("Expected len(groups)==0 but got %d", len(groups))
+		}
+
+		g1.Members = []uuid.UUID{u1.ID}
+		err = ds.UpdateGroup(g1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = ds.DeleteUser(u1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		g, err = ds.GetGroupByID(g1.ID)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if g.HasMember(u1.ID) {
+			t.Error("Expected deleted user removed from group")
+		}
+
+		err = ds.DeleteGroup(g1)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = ds.GetGroupByID(g1.ID)
+		if err != ErrNotFound {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDataStore_GroupMembers(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		g := &Group{ID: uuid.New(), Name: "admins"}
+		if err := ds.AddGroup(g); err != nil {
+			t.Fatal(err)
+		}
+
+		u1, u2 := uuid.New(), uuid.New()
+
+		// Scenario 01 : Add members in order
+		{
+			for _, id := range []uuid.UUID{u1, u2} {
+				if added, err := ds.AddGroupMember(g.ID, id); err != nil || !added {
+					t.Errorf("Expected member added but got %t, %v", added, err)
+				}
+			}
+
+			if added, err := ds.AddGroupMember(g.ID, u1); err != nil || added {
+				t.Errorf("Expected existing member not added but got %t, %v", added, err)
+			}
+
+			if _, err := ds.AddGroupMember(uuid.New(), u1); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound but got %v", err)
+			}
+
+			got, err := ds.GetGroupByID(g.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Members) != 2 || got.Members[0] != u1 || got.Members[1] != u2 {
+				t.Errorf("Unexpected members %v", got.Members)
+			}
+		}
+
+		// Scenario 02 : Remove member
+		{
+			if err := ds.RemoveGroupMember(g.ID, u1); err != nil {
+				t.Error(err)
+			}
+
+			if err := ds.RemoveGroupMember(g.ID, u1); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound but got %v", err)
+			}
+
+			if added, err := ds.AddGroupMember(g.ID, u1); err != nil || !added {
+				t.Errorf("Expected member added but got %t, %v", added, err)
+			}
+
+			got, err := ds.GetGroupByID(g.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Members) != 2 || got.Members[0] != u2 || got.Members[1] != u1 {
+				t.Errorf("Unexpected members %v", got.Members)
+			}
+		}
+
+		// Scenario 03 : Replace members
+		{
+			old, err := ds.SetGroupMembers(g.ID, []uuid.UUID{u1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(old) != 2 || old[0] != u2 || old[1] != u1 {
+				t.Errorf("Unexpected replaced members %v", old)
+			}
+
+			got, err := ds.GetGroupByID(g.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Members) != 1 || got.Members[0] != u1 {
+				t.Errorf("Unexpected members %v", got.Members)
+			}
+
+			if _, err := ds.SetGroupMembers(uuid.New(), nil); err != ErrNotFound {
+				t.Errorf("Expected ErrNotFound but got %v", err)
+			}
+		}
+
+		// Scenario 04 : Concurrent additions aren't lost
+		{
+			if _, err := ds.SetGroupMembers(g.ID, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := ds.AddGroupMember(g.ID, uuid.New()); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			got, err := ds.GetGroupByID(g.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got.Members) != 10 {
+				t.Errorf("Expected 10 members but got %d", len(got.Members))
+			}
+		}
+	})
+}
+
+func TestDataStore_Bootstrap(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- ds.Bootstrap(&User{
+					ID:       uuid.New(),
+					Username: fmt.Sprintf("admin%d", i),
+				})
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		created := 0
+		for err := range errs {
+			switch err {
+			case nil:
+				created++
+			case ErrAlreadyBootstrapped:
+			default:
+				t.Error(err)
+			}
+		}
+
+		if created != 1 {
+			t.Errorf("Expected 1 bootstrapped user but got %d", created)
+		}
+
+		if n := ds.Size(); n != 1 {
+			t.Errorf("Expected Size()==1 but got %d", n)
+		}
+	})
+}
+
+func TestDataStore_QueryUsers(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		for i := 0; i < 7; i++ {
+			err := ds.AddUser(&User{ID: uuid.New(), Username: fmt.Sprintf("user%d", i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := ds.AddUser(&User{
+			ID:          uuid.New(),
+			Username:    "admin",
+			Permissions: []permission.Permission{mustPermission("+:gosso")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Scenario 01 : Follow cursors through every page
+		{
+			var names []string
+			q := UserQuery{Prefix: "user", Limit: 3}
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatal("Expected 3 pages")
+				}
+
+				p, err := ds.QueryUsers(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if p.Total != 7 {
+					t.Errorf("Expected total 7 but got %d", p.Total)
+				}
+
+				for _, u := range p.Users {
+					names = append(names, u.Username)
+				}
+
+				if p.NextCursor == "" {
+					break
+				}
+				q.Cursor = p.NextCursor
+			}
+
+			if fmt.Sprint(names) != "[user0 user1 user2 user3 user4 user5 user6]" {
+				t.Errorf("Unexpected users %v", names)
+			}
+		}
+
+		// Scenario 02 : Descending sort
+		{
+			p, err := ds.QueryUsers(UserQuery{Sort: SortByUsernameDesc, Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p.Users) != 2 || p.Users[0].Username != "user6" || p.Users[1].Username != "user5" || p.Total != 8 {
+				t.Errorf("Unexpected page %+v", p)
+			}
+
+			p, err = ds.QueryUsers(UserQuery{Sort: SortByUsernameDesc, Cursor: p.NextCursor, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(p.Users) != 6 || p.Users[5].Username != "admin" || p.NextCursor != "" {
+				t.Errorf("Unexpected page %+v", p)
+			}
+		}
+
+		// Scenario 03 : Permission filter
+		{
+			perm := mustPermission("gosso:user")
+			p, err := ds.QueryUsers(UserQuery{Permission: &perm})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Total != 1 || p.Users[0].Username != "admin" {
+				t.Errorf("Unexpected page %+v", p)
+			}
+		}
+
+		// Scenario 04 : Prefix is case sensitive
+		{
+			p, err := ds.QueryUsers(UserQuery{Prefix: "USER"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Total != 0 || len(p.Users) != 0 {
+				t.Errorf("Unexpected page %+v", p)
+			}
+		}
+
+		// Scenario 05 : Cursor of other sort is rejected
+		{
+			p, err := ds.QueryUsers(UserQuery{Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ds.QueryUsers(UserQuery{Sort: SortByID, Cursor: p.NextCursor})
+			if err != ErrInvalidCursor {
+				t.Errorf("Expected ErrInvalidCursor but got %v", err)
+			}
+
+			_, err = ds.QueryUsers(UserQuery{Cursor: "invalid"})
+			if err != ErrInvalidCursor {
+				t.Errorf("Expected ErrInvalidCursor but got %v", err)
+			}
+		}
+
+		// Scenario 06 : Every sort pages through users matching prefix in order
+		{
+			pages := func(q UserQuery) []string {
+				var names []string
+				for n := 0; ; n++ {
+					if n > 8 {
+						t.Fatal("Expected cursors to end")
+					}
+
+					p, err := ds.QueryUsers(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					for _, u := range p.Users {
+						names = append(names, u.Username)
+					}
+
+					if p.NextCursor == "" {
+						return names
+					}
+					q.Cursor = p.NextCursor
+				}
+			}
+
+			if names := pages(UserQuery{Prefix: "user", Sort: SortByUsernameDesc, Limit: 3}); fmt.Sprint(names) != "[user6 user5 user4 user3 user2 user1 user0]" {
+				t.Errorf("Unexpected users %v", names)
+			}
+
+			if names := pages(UserQuery{Prefix: "adm", Sort: SortByUsernameDesc}); fmt.Sprint(names) != "[admin]" {
+				t.Errorf("Unexpected users %v", names)
+			}
+
+			all, err := ds.GetAllUsers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(all, func(a, b int) bool { return all[a].ID.String() < all[b].ID.String() })
+
+			var byID []string
+			for _, u := range all {
+				byID = append(byID, u.Username)
+			}
+
+			if names := pages(UserQuery{Sort: SortByID, Limit: 3}); fmt.Sprint(names) != fmt.Sprint(byID) {
+				t.Errorf("Expected %v but got %v", byID, names)
+			}
+
+			for i, j := 0, len(byID)-1; i < j; i, j = i+1, j-1 {
+				byID[i], byID[j] = byID[j], byID[i]
+			}
+
+			if names := pages(UserQuery{Sort: SortByIDDesc, Limit: 3}); fmt.Sprint(names) != fmt.Sprint(byID) {
+				t.Errorf("Expected %v but got %v", byID, names)
+			}
+		}
+	})
+}
+
+func TestDataStore_Profile(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		u1 := &User{
+			ID:       uuid.New(),
+			Username: "hello",
+			Password: mustHashPassword("world"),
+		}
+
+		if err := ds.AddUser(u1); err != nil {
+			t.Fatal(err)
+		}
+
+		u1.Email = "hello@example.com"
+		u1.DisplayName = "Hello World"
+		u1.Attributes = map[string]string{"team": "sso", "floor": "3"}
+		if err := ds.UpdateUser(u1); err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !compareUser(*u1, *u) {
+			t.Errorf("u1 (%+v) != u (%+v)", *u1, *u)
+		}
+
+		schemas, err := ds.GetAttributeSchemas()
+		if err != nil || len(schemas) != 0 {
+			t.Errorf("Expected no attribute schema but got %v (%v)", schemas, err)
+		}
+
+		err = ds.SetAttributeSchemas([]AttributeSchema{
+			{Name: "team", Claim: true},
+			{Name: "floor", Type: AttributeInteger, Required: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		schemas, err = ds.GetAttributeSchemas()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(schemas) != 2 || schemas[0].Name != "floor" || schemas[0].Type != AttributeInteger || !schemas[1].Claim {
+			t.Errorf("Unexpected attribute schemas %+v", schemas)
+		}
+
+		if err := ds.SetAttributeSchemas(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		schemas, err = ds.GetAttributeSchemas()
+		if err != nil || len(schemas) != 0 {
+			t.Errorf("Expected no attribute schema but got %v (%v)", schemas, err)
+		}
+	})
+}
+
+func TestDataStore_OneTimeTokens(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		u := &User{ID: uuid.New(), Username: "hello", Email: "hello@example.com"}
+
+		tok, rec := NewOneTimeToken(PurposePasswordReset, u, time.Hour)
+		if err := ds.AddOneTimeToken(rec); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ds.AddOneTimeToken(rec); err != ErrAlreadyExists {
+			t.Errorf("Expected ErrAlreadyExists but got %v", err)
+		}
+
+		// Token of other purpose can't be consumed
+		if _, err := ds.ConsumeOneTimeToken(PurposeEmailVerification, HashOneTimeToken(tok)); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+
+		got, err := ds.ConsumeOneTimeToken(PurposePasswordReset, HashOneTimeToken(tok))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.UserID != u.ID || got.Email != u.Email || got.Expired() || got.ExpiresAt.Unix() != rec.ExpiresAt.Unix() {
+			t.Errorf("Unexpected token %+v", got)
+		}
+
+		// Token is single-use
+		if _, err := ds.ConsumeOneTimeToken(PurposePasswordReset, HashOneTimeToken(tok)); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+
+		_, r1 := NewOneTimeToken(PurposePasswordReset, u, time.Hour)
+		_, r2 := NewOneTimeToken(PurposeEmailVerification, u, time.Hour)
+		for _, r := range []*OneTimeToken{r1, r2} {
+			if err := ds.AddOneTimeToken(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := ds.DeleteOneTimeTokens(u.ID, PurposePasswordReset); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ds.ConsumeOneTimeToken(PurposePasswordReset, r1.Hash); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+
+		if _, err := ds.ConsumeOneTimeToken(PurposeEmailVerification, r2.Hash); err != nil {
+			t.Errorf("Expected token of other purpose to be kept but got %v", err)
+		}
+	})
+}
+
+func TestDataStore_Invitations(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		admin := uuid.New()
+
+		tok1, i1 := NewInvitation([]permission.Permission{mustPermission("+:sso")}, "hello@example.com", admin, time.Hour)
+		_, i2 := NewInvitation(nil, "", admin, time.Hour)
+		i2.CreatedAt = i1.CreatedAt.Add(time.Second)
+
+		for _, i := range []*Invitation{i1, i2} {
+			if err := ds.AddInvitation(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := ds.AddInvitation(i1); err != ErrAlreadyExists {
+			t.Errorf("Expected ErrAlreadyExists but got %v", err)
+		}
+
+		iList, err := ds.GetAllInvitations()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(iList) != 2 || iList[0].ID != i1.ID || iList[1].ID != i2.ID {
+			t.Fatalf("Unexpected invitations %+v", iList)
+		}
+
+		if iList[0].Email != i1.Email || iList[0].CreatedBy != admin || !iList[0].ExpiresAt.Equal(i1.ExpiresAt) || len(iList[0].Permissions) != 1 {
+			t.Errorf("Unexpected invitation %+v", iList[0])
+		}
+
+		i, err := ds.ConsumeInvitation(HashOneTimeToken(tok1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i.ID != i1.ID || i.Expired() {
+			t.Errorf("Unexpected invitation %+v", i)
+		}
+
+		// Invitation is single-use
+		if _, err := ds.ConsumeInvitation(HashOneTimeToken(tok1)); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+
+		if err := ds.DeleteInvitation(i2.ID); err != nil {
+			t.Error(err)
+		}
+
+		if err := ds.DeleteInvitation(i2.ID); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound but got %v", err)
+		}
+
+		if iList, _ := ds.GetAllInvitations(); len(iList) != 0 {
+			t.Errorf("Expected no invitation but got %d", len(iList))
+		}
+	})
+}
+
+func TestDataStore_Status(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		until := time.Now().Add(time.Hour)
+		expires := time.Now().Add(24 * time.Hour)
+
+		u1 := &User{
+			ID:       uuid.New(),
+			Username: "hello",
+			Status: AccountStatus{
+				State:     StateLocked,
+				Reason:    "too many attempts",
+				Until:     &until,
+				ExpiresAt: &expires,
+			},
+		}
+
+		if err := ds.AddUser(u1); err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s := u.Status
+		if s.State != StateLocked || s.Reason != u1.Status.Reason || s.Until == nil || !s.Until.Equal(until) || s.ExpiresAt == nil || !s.ExpiresAt.Equal(expires) {
+			t.Errorf("Unexpected status %+v", s)
+		}
+
+		u.Status = AccountStatus{}
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		u, err = ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.Status.State != "" || u.Status.Until != nil || u.Status.ExpiresAt != nil {
+			t.Errorf("Expected cleared status but got %+v", u.Status)
+		}
+	})
+}
+
+func TestDataStore_SecurityStamp(t *testing.T) {
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		u1 := &User{
+			ID:       uuid.New(),
+			Username: "hello",
+		}
+		u1.RotateSecurityStamp()
+
+		if err := ds.AddUser(u1); err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := ds.GetUserByID(u1.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u.SecurityStamp == "" || u.SecurityStamp != u1.SecurityStamp {
+			t.Errorf("Expected %q but got %q", u1.SecurityStamp, u.SecurityStamp)
+		}
+
+		u.RotateSecurityStamp()
+		if u.SecurityStamp == u1.SecurityStamp {
+			t.Error("Expected rotated security stamp")
+		}
+
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		u2, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if u2.SecurityStamp != u.SecurityStamp {
+			t.Errorf("Expected %q but got %q", u.SecurityStamp, u2.SecurityStamp)
+		}
+	})
+}
+
+func TestSQLStore_ConflictError(t *testing.T) {
+	s := createTempSQLStore().(*SQLStore)
+
+	u := &User{ID: uuid.New(), Username: "hello"}
+	if err := insertUser(s.db, u); err != nil {
+		t.Fatal(err)
 	}
 
-	all, err := ds.GetAllUsers()
-	if err != nil {
-		t.Error(err)
+	// Scenario 00 : Writer losing race against concurrent insert gets ErrAlreadyExists
+	{
+		err := insertUser(s.db, &User{ID: uuid.New(), Username: "hello"})
+		if err == nil {
+			t.Fatal("Expected unique constraint violation")
+		}
+
+		if err := conflictError(err); err != ErrAlreadyExists {
+			t.Errorf("Expected ErrAlreadyExists but got %v", err)
+		}
 	}
 
-	if len(all) != 2 {
-		t.Errorf("Expeted len(all)==2 but got %d", len(all))
+	// Scenario 01 : Other errors are kept
+	{
+		err := errors.New("test error")
+		if conflictError(err) != err || conflictError(nil) != nil {
+			t.Error("Expected error to be kept")
+		}
 	}
 }
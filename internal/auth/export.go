@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+// ExportVersion is version of export format written by ExportDataStore
+const ExportVersion = 1
+
+var ErrUnsupportedExport = errors.New("datastore: unsupported export version")
+
+// ConflictMode decides what ImportDataStore does with record whose ID, username or group name already exists
+type ConflictMode string
+
+const (
+	ConflictSkip      ConflictMode = "skip"
+	ConflictOverwrite ConflictMode = "overwrite"
+	ConflictFail      ConflictMode = "fail"
+)
+
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch m := ConflictMode(s); m {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return m, nil
+	}
+	return "", fmt.Errorf("datastore: invalid conflict mode: %s", s)
+}
+
+// ExportedUser includes password hash, salt and security stamp, unlike JSON encoding of User
+type ExportedUser struct {
+	ID            uuid.UUID               `json:"id"`
+	Username      string                  `json:"username"`
+	Password      Password                `json:"password"`
+	Permissions   []permission.Permission `json:"permissions"`
+	Email         string                  `json:"email,omitempty"`
+	EmailVerified bool                    `json:"emailVerified,omitempty"`
+	DisplayName   string                  `json:"displayName,omitempty"`
+	Attributes    map[string]string       `json:"attributes,omitempty"`
+	Status        AccountStatus           `json:"status"`
+	SecurityStamp string                  `json:"securityStamp,omitempty"`
+}
+
+// Export is portable copy of data store, independent of storage backend
+type Export struct {
+	Version int            `json:"version"`
+	Users   []ExportedUser `json:"users"`
+	Groups  []Group        `json:"groups"`
+
+	AttributeSchemas []AttributeSchema `json:"attributeSchemas,omitempty"`
+}
+
+// ExportDataStore exports every user, group and attribute schema
+func ExportDataStore(ds DataStore) (*Export, error) {
+	users, err := ds.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := ds.GetAllGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, err := ds.GetAttributeSchemas()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Export{
+		Version:          ExportVersion,
+		Users:            make([]ExportedUser, len(users)),
+		Groups:           groups,
+		AttributeSchemas: schemas,
+	}
+
+	for i, u := range users {
+		e.Users[i] = ExportedUser{
+			ID:            u.ID,
+			Username:      u.Username,
+			Password:      u.Password,
+			Permissions:   u.Permissions,
+			Email:         u.Email,
+			EmailVerified: u.EmailVerified,
+			DisplayName:   u.DisplayName,
+			Attributes:    u.Attributes,
+			Status:        u.Status,
+			SecurityStamp: u.SecurityStamp,
+		}
+	}
+
+	return e, nil
+}
+
+type ImportResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// ImportError lists conflicting records found by ConflictFail import
+type ImportError struct {
+	Conflicts []string
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("datastore: %d conflicting records: %v", len(e.Conflicts), e.Conflicts)
+}
+
+// PartialImportError reports import that failed after writing records counted by Result.
+// Records written before failure are kept.
+type PartialImportError struct {
+	Result ImportResult
+	Err    error
+}
+
+func (e PartialImportError) Error() string {
+	return fmt.Sprintf("datastore: import stopped after %d created, %d overwritten and %d skipped records: %v",
+		e.Result.Created, e.Result.Overwritten, e.Result.Skipped, e.Err)
+}
+
+func (e PartialImportError) Unwrap() error {
+	return e.Err
+}
+
+// userConflict returns existing user with same ID or username
+func userConflict(ds DataStore, u ExportedUser) (*User, error) {
+	existing, err := ds.GetUserByID(u.ID)
+	if err != ErrNotFound {
+		return existing, err
+	}
+
+	existing, err = ds.GetUserByUsername(u.Username)
+	if err != ErrNotFound {
+		return existing, err
+	}
+	return nil, nil
+}
+
+// groupConflict returns existing group with same ID or name
+func groupConflict(ds DataStore, g Group) (*Group, error) {
+	existing, err := ds.GetGroupByID(g.ID)
+	if err != ErrNotFound {
+		return existing, err
+	}
+
+	existing, err = ds.GetGroupByName(g.Name)
+	if err != ErrNotFound {
+		return existing, err
+	}
+	return nil, nil
+}
+
+// ImportDataStore imports attribute schemas, users and then groups.
+// ConflictFail checks every record before writing, so nothing is imported if any record conflicts.
+// ConflictOverwrite replaces record with same ID in place, and replaces record with same name but different ID.
+//
+// Import is not atomic, since data stores have no transaction spanning several records.
+// Records are written one by one, and failure while writing returns PartialImportError counting records written before it.
+// Those records are kept, so import can be resumed with ConflictSkip once cause is fixed.
+func ImportDataStore(ds DataStore, e *Export, mode ConflictMode) (ImportResult, error) {
+	var res ImportResult
+	partial := func(err error) (ImportResult, error) {
+		return res, PartialImportError{Result: res, Err: err}
+	}
+
+	if e.Version != ExportVersion {
+		return res, ErrUnsupportedExport
+	}
+
+	if _, err := ParseConflictMode(string(mode)); err != nil {
+		return res, err
+	}
+
+	existingSchemas, err := ds.GetAttributeSchemas()
+	if err != nil {
+		return res, err
+	}
+
+	schemaIndex := make(map[string]int)
+	for i, a := range existingSchemas {
+		schemaIndex[a.Name] = i
+	}
+
+	if mode == ConflictFail {
+		var conflicts []string
+		for _, a := range e.AttributeSchemas {
+			if _, ok := schemaIndex[a.Name]; ok {
+				conflicts = append(conflicts, "attribute schema "+a.Name)
+			}
+		}
+
+		for _, u := range e.Users {
+			existing, err := userConflict(ds, u)
+			if err != nil {
+				return res, err
+			}
+			if existing != nil {
+				conflicts = append(conflicts, "user "+u.Username)
+			}
+		}
+
+		for _, g := range e.Groups {
+			existing, err := groupConflict(ds, g)
+			if err != nil {
+				return res, err
+			}
+			if existing != nil {
+				conflicts = append(conflicts, "group "+g.Name)
+			}
+		}
+
+		if len(conflicts) > 0 {
+			return res, ImportError{Conflicts: conflicts}
+		}
+	}
+
+	if len(e.AttributeSchemas) > 0 {
+		schemas := existingSchemas
+		for _, a := range e.AttributeSchemas {
+			i, ok := schemaIndex[a.Name]
+			switch {
+			case !ok:
+				schemaIndex[a.Name] = len(schemas)
+				schemas = append(schemas, a)
+				res.Created++
+			case mode == ConflictSkip:
+				res.Skipped++
+			default:
+				schemas[i] = a
+				res.Overwritten++
+			}
+		}
+
+		if err := ValidateAttributeSchemas(schemas); err != nil {
+			return ImportResult{}, err
+		}
+
+		if err := ds.SetAttributeSchemas(schemas); err != nil {
+			return ImportResult{}, err
+		}
+	}
+
+	for _, eu := range e.Users {
+		u := &User{
+			ID:            eu.ID,
+			Username:      eu.Username,
+			Password:      eu.Password,
+			Permissions:   eu.Permissions,
+			Email:         eu.Email,
+			EmailVerified: eu.EmailVerified,
+			DisplayName:   eu.DisplayName,
+			Attributes:    eu.Attributes,
+			Status:        eu.Status,
+			SecurityStamp: eu.SecurityStamp,
+		}
+
+		existing, err := userConflict(ds, eu)
+		if err != nil {
+			return partial(err)
+		}
+
+		counter := &res.Overwritten
+		switch {
+		case existing == nil:
+			err = ds.AddUser(u)
+			counter = &res.Created
+		case mode == ConflictSkip:
+			counter = &res.Skipped
+		case mode == ConflictFail:
+			// Record conflicts with one written earlier in same import
+			err = ErrAlreadyExists
+		case existing.ID == u.ID:
+			err = ds.UpdateUser(u)
+		default:
+			if err = ds.DeleteUser(existing); err == nil {
+				err = ds.AddUser(u)
+			}
+		}
+
+		if err != nil {
+			return partial(fmt.Errorf("user %s: %w", u.Username, err))
+		}
+		*counter++
+	}
+
+	for i := range e.Groups {
+		g := &e.Groups[i]
+
+		existing, err := groupConflict(ds, *g)
+		if err != nil {
+			return partial(err)
+		}
+
+		counter := &res.Overwritten
+		switch {
+		case existing == nil:
+			err = ds.AddGroup(g)
+			counter = &res.Created
+		case mode == ConflictSkip:
+			counter = &res.Skipped
+		case mode == ConflictFail:
+			// Record conflicts with one written earlier in same import
+			err = ErrAlreadyExists
+		case existing.ID == g.ID:
+			err = ds.UpdateGroup(g)
+		default:
+			if err = ds.DeleteGroup(existing); err == nil {
+				err = ds.AddGroup(g)
+			}
+		}
+
+		if err != nil {
+			return partial(fmt.Errorf("group %s: %w", g.Name, err))
+		}
+		*counter++
+	}
+
+	return res, nil
+}
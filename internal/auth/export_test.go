@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+func TestImportDataStore(t *testing.T) {
+	src := NewMemoryStore()
+
+	u1 := &User{
+		ID:          uuid.New(),
+		Username:    "hello",
+		Password:    mustHashPassword("world"),
+		Permissions: []permission.Permission{mustPermission("+:sso")},
+		Email:       "hello@example.com",
+		Attributes:  map[string]string{"team": "sso"},
+	}
+
+	if err := src.AddUser(u1); err != nil {
+		t.Fatal(err)
+	}
+
+	g1 := &Group{
+		ID:          uuid.New(),
+		Name:        "admins",
+		Permissions: []permission.Permission{mustPermission("+:gosso")},
+		Members:     []uuid.UUID{u1.ID},
+	}
+
+	if err := src.AddGroup(g1); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ExportDataStore(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Export survives JSON round trip including password hash
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e = new(Export)
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(e); err != nil {
+		t.Fatal(err)
+	}
+
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		// Scenario 01 : Import into empty data store
+		{
+			r, err := ImportDataStore(ds, e, ConflictFail)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Created != 2 {
+				t.Errorf("Expected 2 created but got %+v", r)
+			}
+
+			u, err := ds.GetUserByUsername("hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !u.Password.Validate("world") {
+				t.Error("Expected imported password to be valid")
+			}
+
+			if u.Email != u1.Email || u.Attributes["team"] != "sso" {
+				t.Errorf("Expected imported profile but got %+v", u)
+			}
+
+			g, err := ds.GetGroupByName("admins")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !g.HasMember(u1.ID) {
+				t.Error("Expected imported group member")
+			}
+		}
+
+		// Scenario 02 : Conflicts fail without writing
+		{
+			_, err := ImportDataStore(ds, e, ConflictFail)
+			if ie, ok := err.(ImportError); !ok || len(ie.Conflicts) != 2 {
+				t.Errorf("Expected ImportError with 2 conflicts but got %v", err)
+			}
+		}
+
+		// Scenario 03 : Skip keeps existing records
+		{
+			u := *u1
+			u.Permissions = nil
+			if err := ds.UpdateUser(&u); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := ImportDataStore(ds, e, ConflictSkip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Skipped != 2 {
+				t.Errorf("Expected 2 skipped but got %+v", r)
+			}
+
+			stored, err := ds.GetUserByID(u1.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stored.Permissions) != 0 {
+				t.Errorf("Expected existing user kept but got %v", stored.Permissions)
+			}
+		}
+
+		// Scenario 04 : Overwrite replaces records with same ID or name
+		{
+			other := &User{ID: uuid.New(), Username: "hello2"}
+			if err := ds.AddUser(other); err != nil {
+				t.Fatal(err)
+			}
+
+			renamed := *e
+			renamed.Users = []ExportedUser{{ID: uuid.New(), Username: "hello2", Password: mustHashPassword("hi")}}
+			renamed.Groups = nil
+
+			r, err := ImportDataStore(ds, e, ConflictOverwrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Overwritten != 2 {
+				t.Errorf("Expected 2 overwritten but got %+v", r)
+			}
+
+			stored, err := ds.GetUserByID(u1.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stored.Permissions) != 1 {
+				t.Errorf("Expected imported permissions but got %v", stored.Permissions)
+			}
+
+			if _, err := ImportDataStore(ds, &renamed, ConflictOverwrite); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ds.GetUserByID(other.ID); err != ErrNotFound {
+				t.Errorf("Expected replaced user deleted but got %v", err)
+			}
+
+			if u, err := ds.GetUserByUsername("hello2"); err != nil || !u.Password.Validate("hi") {
+				t.Errorf("Expected imported user but got %v", err)
+			}
+		}
+	})
+}
+
+func TestImportDataStore_Version(t *testing.T) {
+	_, err := ImportDataStore(NewMemoryStore(), &Export{Version: ExportVersion + 1}, ConflictFail)
+	if err != ErrUnsupportedExport {
+		t.Errorf("Expected ErrUnsupportedExport but got %v", err)
+	}
+}
+
+func TestImportDataStore_Partial(t *testing.T) {
+	// Second user takes username of first one, which checking against data store before import can't find
+	e := &Export{
+		Version: ExportVersion,
+		Users: []ExportedUser{
+			{ID: uuid.New(), Username: "hello"},
+			{ID: uuid.New(), Username: "hello"},
+		},
+	}
+
+	forEachDataStore(t, func(t *testing.T, ds DataStore) {
+		// Scenario 01 : Failure reports records written before it
+		{
+			_, err := ImportDataStore(ds, e, ConflictFail)
+
+			var partial PartialImportError
+			if !errors.As(err, &partial) || partial.Result.Created != 1 || !errors.Is(err, ErrAlreadyExists) {
+				t.Fatalf("Expected partial import of 1 record but got %v", err)
+			}
+
+			if _, err := ds.GetUserByID(e.Users[0].ID); err != nil {
+				t.Errorf("Expected written user to be kept but got %v", err)
+			}
+		}
+
+		// Scenario 02 : Import is resumed skipping written records
+		{
+			r, err := ImportDataStore(ds, e, ConflictSkip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Created != 0 || r.Skipped != 2 {
+				t.Errorf("Unexpected result %+v", r)
+			}
+		}
+	})
+}
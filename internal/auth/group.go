@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+type Group struct {
+	ID          uuid.UUID               `storm:"unique" json:"id"`
+	Name        string                  `storm:"unique" json:"name"`
+	Permissions []permission.Permission `json:"permissions"`
+	Members     []uuid.UUID             `json:"members"`
+}
+
+func (g Group) HasMember(id uuid.UUID) bool {
+	for _, m := range g.Members {
+		if m == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AddMember returns false if user is already a member
+func (g *Group) AddMember(id uuid.UUID) bool {
+	if g.HasMember(id) {
+		return false
+	}
+	g.Members = append(g.Members, id)
+	return true
+}
+
+// RemoveMember returns false if user is not a member
+func (g *Group) RemoveMember(id uuid.UUID) bool {
+	for i, m := range g.Members {
+		if m == id {
+			g.Members = append(g.Members[:i], g.Members[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// EffectivePermissions merges direct grants with permissions inherited from groups.
+// permission.HasPermission uses first matching rule, so direct grants take precedence over group grants,
+// and groups take precedence in provided order.
+func EffectivePermissions(u User, groups []Group) []permission.Permission {
+	perms := make([]permission.Permission, 0, len(u.Permissions))
+	seen := make(map[string]bool)
+
+	add := func(p []permission.Permission) {
+		for _, v := range p {
+			if seen[v.String()] {
+				continue
+			}
+			seen[v.String()] = true
+			perms = append(perms, v)
+		}
+	}
+
+	add(u.Permissions)
+	for _, g := range groups {
+		add(g.Permissions)
+	}
+
+	return perms
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+func mustPermission(s string) permission.Permission {
+	p, err := permission.FromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestGroup_Members(t *testing.T) {
+	g := Group{}
+	id := uuid.New()
+
+	if !g.AddMember(id) {
+		t.Error("expected true but got false")
+	}
+
+	if g.AddMember(id) {
+		t.Error("expected false but got true")
+	}
+
+	if !g.HasMember(id) {
+		t.Error("expected true but got false")
+	}
+
+	if !g.RemoveMember(id) {
+		t.Error("expected true but got false")
+	}
+
+	if g.RemoveMember(id) {
+		t.Error("expected false but got true")
+	}
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	u := User{
+		Permissions: []permission.Permission{mustPermission("-:app:admin"), mustPermission("+:gosso")},
+	}
+
+	groups := []Group{
+		{Name: "a", Permissions: []permission.Permission{mustPermission("+:app:*"), mustPermission("+:gosso")}},
+		{Name: "b", Permissions: []permission.Permission{mustPermission("+:report")}},
+	}
+
+	perms := EffectivePermissions(u, groups)
+
+	if len(perms) != 4 {
+		t.Errorf("Expected len(perms)==4 but got %d", len(perms))
+	}
+
+	for i, v := range []struct {
+		perm string
+		has  bool
+	}{
+		{"app:admin", false},
+		{"app:user", true},
+		{"report", true},
+		{"gosso", true},
+		{"other", false},
+	} {
+		if mustPermission(v.perm).HasPermission(perms) != v.has {
+			t.Errorf("%d: %s: expected %t", i, v.perm, v.has)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+// Invitation lets invitee create user with preset permissions, choosing own username and password.
+// Like one-time tokens, only hash of invitation token is stored.
+type Invitation struct {
+	ID          uuid.UUID               `storm:"id" json:"id"`
+	Hash        string                  `storm:"unique" json:"-"`
+	Permissions []permission.Permission `json:"permissions"`
+	// Email becomes email of created user
+	Email string `json:"email,omitempty"`
+	// Mailed is set if invitation link was mailed to Email. Email of created user is verified only then,
+	// since link shared by other means doesn't prove invitee owns address.
+	Mailed    bool      `json:"mailed"`
+	CreatedBy uuid.UUID `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NewInvitation generates invitation token and invitation valid until now + timeout
+func NewInvitation(perms []permission.Permission, email string, createdBy uuid.UUID, timeout time.Duration) (string, *Invitation) {
+	token := generateToken()
+	now := time.Now()
+
+	return token, &Invitation{
+		ID:          uuid.New(),
+		Hash:        HashOneTimeToken(token),
+		Permissions: perms,
+		Email:       email,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(timeout),
+	}
+}
+
+func (i Invitation) Expired() bool {
+	return !time.Now().Before(i.ExpiresAt)
+}
+
+// sortInvitations sorts invitations by creation time, and by ID if created at same time
+func sortInvitations(iList []Invitation) {
+	sort.Slice(iList, func(a, b int) bool {
+		if !iList[a].CreatedAt.Equal(iList[b].CreatedAt) {
+			return iList[a].CreatedAt.Before(iList[b].CreatedAt)
+		}
+		return iList[a].ID.String() < iList[b].ID.String()
+	})
+}
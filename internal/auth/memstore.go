@@ -0,0 +1,483 @@
+package auth
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps users and groups in memory. Records are copied in and out,
+// so callers can't modify stored records without update.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	users  map[uuid.UUID]User
+	groups map[uuid.UUID]Group
+
+	attributeSchemas []AttributeSchema
+	tokens           map[string]OneTimeToken
+	invitations      map[uuid.UUID]Invitation
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:       make(map[uuid.UUID]User),
+		groups:      make(map[uuid.UUID]Group),
+		tokens:      make(map[string]OneTimeToken),
+		invitations: make(map[uuid.UUID]Invitation),
+	}
+}
+
+func copyPermissions(p []permission.Permission) []permission.Permission {
+	if p == nil {
+		return nil
+	}
+	return append(make([]permission.Permission, 0, len(p)), p...)
+}
+
+func copyAttributes(a map[string]string) map[string]string {
+	if a == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(a))
+	for k, v := range a {
+		c[k] = v
+	}
+	return c
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	c := *t
+	return &c
+}
+
+func copyUser(u User) User {
+	u.Permissions = copyPermissions(u.Permissions)
+	u.Attributes = copyAttributes(u.Attributes)
+	u.Status.Until = copyTime(u.Status.Until)
+	u.Status.ExpiresAt = copyTime(u.Status.ExpiresAt)
+	return u
+}
+
+func copyGroup(g Group) Group {
+	g.Permissions = copyPermissions(g.Permissions)
+	if g.Members != nil {
+		g.Members = append(make([]uuid.UUID, 0, len(g.Members)), g.Members...)
+	}
+	return g
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// usernameTaken reports whether username is used by user other than id
+func (m *MemoryStore) usernameTaken(id uuid.UUID, username string) bool {
+	for _, u := range m.users {
+		if u.ID != id && u.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) AddUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.ID]; ok || m.usernameTaken(user.ID, user.Username) {
+		return ErrAlreadyExists
+	}
+
+	m.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+func (m *MemoryStore) Bootstrap(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.users) > 0 {
+		return ErrAlreadyBootstrapped
+	}
+
+	m.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+func (m *MemoryStore) UpdateUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+
+	if m.usernameTaken(user.ID, user.Username) {
+		return ErrAlreadyExists
+	}
+
+	m.users[user.ID] = copyUser(*user)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUser(user *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+
+	for id, g := range m.groups {
+		if g.RemoveMember(user.ID) {
+			m.groups[id] = g
+		}
+	}
+
+	delete(m.users, user.ID)
+	return nil
+}
+
+func (m *MemoryStore) GetUserByID(id uuid.UUID) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	u = copyUser(u)
+	return &u, nil
+}
+
+func (m *MemoryStore) GetUserByUsername(username string) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			u = copyUser(u)
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetAllUsers returns users sorted by ID
+func (m *MemoryStore) Size() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.users)
+}
+
+func (m *MemoryStore) GetAllUsers() ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	uList := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		uList = append(uList, copyUser(u))
+	}
+
+	sort.Slice(uList, func(i, j int) bool {
+		return uList[i].ID.String() < uList[j].ID.String()
+	})
+
+	return uList, nil
+}
+
+func (m *MemoryStore) QueryUsers(query UserQuery) (*UserPage, error) {
+	p, err := newUserPager(query)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		p.add(copyUser(u))
+	}
+
+	return p.result(), nil
+}
+
+// groupNameTaken reports whether name is used by group other than id
+func (m *MemoryStore) groupNameTaken(id uuid.UUID, name string) bool {
+	for _, g := range m.groups {
+		if g.ID != id && g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) AddGroup(group *Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[group.ID]; ok || m.groupNameTaken(group.ID, group.Name) {
+		return ErrAlreadyExists
+	}
+
+	m.groups[group.ID] = copyGroup(*group)
+	return nil
+}
+
+func (m *MemoryStore) UpdateGroup(group *Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[group.ID]; !ok {
+		return ErrNotFound
+	}
+
+	if m.groupNameTaken(group.ID, group.Name) {
+		return ErrAlreadyExists
+	}
+
+	m.groups[group.ID] = copyGroup(*group)
+	return nil
+}
+
+func (m *MemoryStore) DeleteGroup(group *Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[group.ID]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.groups, group.ID)
+	return nil
+}
+
+// editGroup applies edit to copy of group and stores it unless edit fails
+func (m *MemoryStore) editGroup(id uuid.UUID, edit func(g *Group) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g, ok := m.groups[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	g = copyGroup(g)
+	if err := edit(&g); err != nil {
+		return err
+	}
+
+	m.groups[id] = g
+	return nil
+}
+
+func (m *MemoryStore) AddGroupMember(groupID, userID uuid.UUID) (bool, error) {
+	added := false
+	err := m.editGroup(groupID, func(g *Group) error {
+		added = g.AddMember(userID)
+		return nil
+	})
+	return added, err
+}
+
+func (m *MemoryStore) RemoveGroupMember(groupID, userID uuid.UUID) error {
+	return m.editGroup(groupID, func(g *Group) error {
+		if !g.RemoveMember(userID) {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStore) SetGroupMembers(groupID uuid.UUID, members []uuid.UUID) ([]uuid.UUID, error) {
+	var old []uuid.UUID
+	err := m.editGroup(groupID, func(g *Group) error {
+		old = g.Members
+		g.Members = append(make([]uuid.UUID, 0, len(members)), members...)
+		return nil
+	})
+	return old, err
+}
+
+func (m *MemoryStore) GetGroupByID(id uuid.UUID) (*Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	g, ok := m.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	g = copyGroup(g)
+	return &g, nil
+}
+
+func (m *MemoryStore) GetGroupByName(name string) (*Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, g := range m.groups {
+		if g.Name == name {
+			g = copyGroup(g)
+			return &g, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetAllGroups returns groups sorted by ID
+func (m *MemoryStore) GetAllGroups() ([]Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	gList := make([]Group, 0, len(m.groups))
+	for _, g := range m.groups {
+		gList = append(gList, copyGroup(g))
+	}
+
+	sort.Slice(gList, func(i, j int) bool {
+		return gList[i].ID.String() < gList[j].ID.String()
+	})
+
+	return gList, nil
+}
+
+func (m *MemoryStore) GetGroupsByMember(id uuid.UUID) ([]Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	gList := make([]Group, 0)
+	for _, g := range m.groups {
+		if g.HasMember(id) {
+			gList = append(gList, copyGroup(g))
+		}
+	}
+
+	sort.Slice(gList, func(i, j int) bool {
+		return gList[i].Name < gList[j].Name
+	})
+
+	return gList, nil
+}
+
+func (m *MemoryStore) GetAttributeSchemas() ([]AttributeSchema, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append(make([]AttributeSchema, 0, len(m.attributeSchemas)), m.attributeSchemas...), nil
+}
+
+func (m *MemoryStore) SetAttributeSchemas(schemas []AttributeSchema) error {
+	s := append(make([]AttributeSchema, 0, len(schemas)), schemas...)
+	SortAttributeSchemas(s)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.attributeSchemas = s
+	return nil
+}
+
+func (m *MemoryStore) AddOneTimeToken(token *OneTimeToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[token.Hash]; ok {
+		return ErrAlreadyExists
+	}
+
+	m.tokens[token.Hash] = *token
+	return nil
+}
+
+func (m *MemoryStore) ConsumeOneTimeToken(purpose TokenPurpose, hash string) (*OneTimeToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[hash]
+	if !ok || t.Purpose != purpose {
+		return nil, ErrNotFound
+	}
+
+	delete(m.tokens, hash)
+	return &t, nil
+}
+
+func (m *MemoryStore) DeleteOneTimeTokens(userID uuid.UUID, purpose TokenPurpose) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for h, t := range m.tokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			delete(m.tokens, h)
+		}
+	}
+	return nil
+}
+
+func copyInvitation(i Invitation) Invitation {
+	i.Permissions = copyPermissions(i.Permissions)
+	return i
+}
+
+func (m *MemoryStore) AddInvitation(invitation *Invitation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.invitations {
+		if i.ID == invitation.ID || i.Hash == invitation.Hash {
+			return ErrAlreadyExists
+		}
+	}
+
+	m.invitations[invitation.ID] = copyInvitation(*invitation)
+	return nil
+}
+
+func (m *MemoryStore) GetAllInvitations() ([]Invitation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	iList := make([]Invitation, 0, len(m.invitations))
+	for _, i := range m.invitations {
+		iList = append(iList, copyInvitation(i))
+	}
+
+	sortInvitations(iList)
+	return iList, nil
+}
+
+func (m *MemoryStore) DeleteInvitation(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.invitations[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(m.invitations, id)
+	return nil
+}
+
+func (m *MemoryStore) ConsumeInvitation(hash string) (*Invitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, i := range m.invitations {
+		if i.Hash == hash {
+			delete(m.invitations, id)
+			return &i, nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+var (
+	ErrSchemaTooNew       = errors.New("datastore: schema version is newer than supported")
+	ErrBackupUnsupported  = errors.New("datastore: backup is not supported by data store")
+	errMigrationsUnsorted = errors.New("datastore: migrations are not in version order")
+)
+
+// Migration upgrades stored records to Version.
+// Each migration is applied in single transaction with version update, so interrupted migration can be rerun.
+type Migration struct {
+	Version     int
+	Description string
+}
+
+type MigrateOptions struct {
+	// DryRun reports pending migrations without applying them
+	DryRun bool
+	// BackupPath receives copy of data store before first pending migration is applied.
+	// Existing file is never overwritten.
+	BackupPath string
+}
+
+// Backuper is implemented by data stores supporting consistent snapshot of live data
+type Backuper interface {
+	Backup(w io.Writer) error
+}
+
+// Migrator is implemented by data stores with persisted schema
+type Migrator interface {
+	Backuper
+
+	// SchemaVersion returns version of last applied migration. New data store has version 0.
+	SchemaVersion() (int, error)
+	// Migrations returns every known migration in version order
+	Migrations() []Migration
+
+	applyMigration(version int) error
+}
+
+// Migrate applies pending migrations of data store in version order and returns them.
+// Data stores without persisted schema are left untouched.
+// NewDataStore and NewSQLStore run it on open, so it's called directly only to dry run or migrate stopped instance.
+func Migrate(ds DataStore, opts MigrateOptions) ([]Migration, error) {
+	m, ok := ds.(Migrator)
+	if !ok {
+		return nil, nil
+	}
+
+	pending, err := PendingMigrations(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) == 0 || opts.DryRun {
+		return pending, nil
+	}
+
+	if opts.BackupPath != "" {
+		if err := backupToFile(m, opts.BackupPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, mig := range pending {
+		if err := m.applyMigration(mig.Version); err != nil {
+			return pending[:i], err
+		}
+	}
+
+	return pending, nil
+}
+
+// migrateOnOpen applies pending migrations of data store opened for use.
+// Data store holding users is copied to backupPath first if path is not empty, so failed migration can be rolled back.
+func migrateOnOpen(ds DataStore, backupPath string) error {
+	m, ok := ds.(Migrator)
+	if !ok {
+		return nil
+	}
+
+	pending, err := PendingMigrations(m)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	opts := MigrateOptions{}
+	if backupPath != "" && ds.Size() != 0 {
+		opts.BackupPath = backupPath
+	}
+
+	applied, err := Migrate(ds, opts)
+	for _, mig := range applied {
+		log.Printf("datastore: applied migration %d: %s", mig.Version, mig.Description)
+	}
+	return err
+}
+
+// PendingMigrations returns migrations newer than schema version of data store
+func PendingMigrations(m Migrator) ([]Migration, error) {
+	current, err := m.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	all := m.Migrations()
+	latest := 0
+	pending := make([]Migration, 0)
+	for _, mig := range all {
+		if mig.Version <= latest {
+			return nil, errMigrationsUnsorted
+		}
+		latest = mig.Version
+
+		if mig.Version > current {
+			pending = append(pending, mig)
+		}
+	}
+
+	if current > latest {
+		return nil, ErrSchemaTooNew
+	}
+
+	return pending, nil
+}
+
+func backupToFile(m Migrator, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = m.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMigrate_Bolt(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := OpenDataStore(filepath.Join(testDir, "test.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.Close() }()
+
+	err = ds.AddUser(&User{ID: uuid.New(), Username: "hello", Password: mustHashPassword("world")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scenario 01 : Dry run doesn't apply migrations
+	{
+		pending, err := Migrate(ds, MigrateOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pending) != len(boltMigrations) {
+			t.Errorf("Expected %d pending migrations but got %d", len(boltMigrations), len(pending))
+		}
+
+		if v, _ := ds.SchemaVersion(); v != 0 {
+			t.Errorf("Expected version 0 but got %d", v)
+		}
+	}
+
+	// Scenario 02 : Migrate with backup
+	backup := filepath.Join(testDir, "backup.db")
+	{
+		applied, err := Migrate(ds, MigrateOptions{BackupPath: backup})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(applied) != len(boltMigrations) {
+			t.Errorf("Expected %d applied migrations but got %d", len(boltMigrations), len(applied))
+		}
+
+		latest := boltMigrations[len(boltMigrations)-1].Version
+		if v, _ := ds.SchemaVersion(); v != latest {
+			t.Errorf("Expected version %d but got %d", latest, v)
+		}
+
+		if _, err := ds.GetUserByUsername("hello"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Scenario 03 : Backup holds data before migration
+	{
+		b, err := OpenDataStore(backup, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v, _ := b.SchemaVersion(); v != 0 {
+			t.Errorf("Expected backup version 0 but got %d", v)
+		}
+
+		if _, err := b.GetUserByUsername("hello"); err != nil {
+			t.Error(err)
+		}
+		_ = b.Close()
+	}
+
+	// Scenario 04 : Migrations are applied once
+	{
+		applied, err := Migrate(ds, MigrateOptions{BackupPath: backup})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(applied) != 0 {
+			t.Errorf("Expected no migration but got %+v", applied)
+		}
+	}
+
+	// Scenario 05 : Newer schema is rejected
+	{
+		if err := ds.db.Set(metaBucket, schemaVersionKey, 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Migrate(ds, MigrateOptions{}); err != ErrSchemaTooNew {
+			t.Errorf("Expected ErrSchemaTooNew but got %v", err)
+		}
+	}
+}
+
+func TestMigrate_SQL(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(testDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := openSQLStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.Close() }()
+
+	// Scenario 01 : Backup is not supported
+	{
+		_, err := Migrate(ds, MigrateOptions{BackupPath: filepath.Join(testDir, "backup")})
+		if err != ErrBackupUnsupported {
+			t.Errorf("Expected ErrBackupUnsupported but got %v", err)
+		}
+
+		if v, _ := ds.SchemaVersion(); v != 0 {
+			t.Errorf("Expected version 0 but got %d", v)
+		}
+	}
+
+	// Scenario 02 : Migrate creates tables
+	{
+		if _, err := Migrate(ds, MigrateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		latest := sqlMigrations[len(sqlMigrations)-1].Version
+		if v, _ := ds.SchemaVersion(); v != latest {
+			t.Errorf("Expected version %d but got %d", latest, v)
+		}
+
+		if err := ds.AddUser(&User{ID: uuid.New(), Username: "hello"}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Scenario 03 : Migrations are applied once
+	{
+		pending, err := Migrate(ds, MigrateOptions{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(pending) != 0 {
+			t.Errorf("Expected no migration but got %+v", pending)
+		}
+	}
+
+}
+
+func TestNewDataStore_Migrates(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(testDir, "test.db")
+	latest := boltMigrations[len(boltMigrations)-1].Version
+
+	// Scenario 01 : New data store is migrated without backup
+	{
+		ds, err := NewDataStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v, _ := ds.SchemaVersion(); v != latest {
+			t.Errorf("Expected version %d but got %d", latest, v)
+		}
+
+		if err := ds.AddUser(&User{ID: uuid.New(), Username: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Roll back to unmigrated data store holding user
+		if err := ds.db.Delete(metaBucket, schemaVersionKey); err != nil {
+			t.Fatal(err)
+		}
+		_ = ds.Close()
+
+		if backups, _ := filepath.Glob(path + ".*.bak"); len(backups) != 0 {
+			t.Errorf("Expected no backup but got %v", backups)
+		}
+	}
+
+	// Scenario 02 : Data store holding users is backed up before migration
+	{
+		ds, err := NewDataStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = ds.Close() }()
+
+		if v, _ := ds.SchemaVersion(); v != latest {
+			t.Errorf("Expected version %d but got %d", latest, v)
+		}
+
+		backups, _ := filepath.Glob(path + ".*.bak")
+		if len(backups) != 1 {
+			t.Fatalf("Expected one backup but got %v", backups)
+		}
+
+		b, err := OpenDataStore(backups[0], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = b.Close() }()
+
+		if v, _ := b.SchemaVersion(); v != 0 || b.Size() != 1 {
+			t.Errorf("Expected unmigrated backup holding user but got version %d and %d users", v, b.Size())
+		}
+	}
+}
+
+func TestNewSQLStore_Migrates(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(testDir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := NewSQLStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ds.Close() }()
+
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+	if v, _ := ds.SchemaVersion(); v != latest {
+		t.Errorf("Expected version %d but got %d", latest, v)
+	}
+
+	if err := ds.AddUser(&User{ID: uuid.New(), Username: "hello"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrate_Memory(t *testing.T) {
+	applied, err := Migrate(NewMemoryStore(), MigrateOptions{})
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Expected no migration but got %v, %v", applied, err)
+	}
+}
+
+func TestRestoreBoltFile(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "datastore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(testDir, "test.db")
+	backup := filepath.Join(testDir, "backup.db")
+
+	ds, err := NewDataStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.AddUser(&User{ID: uuid.New(), Username: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backupToFile(ds, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.AddUser(&User{ID: uuid.New(), Username: "hola"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scenario 01 : Data store in use can't be replaced
+	{
+		if err := RestoreBoltFile(backup, path, 100*time.Millisecond); err == nil {
+			t.Error("Expected error restoring opened data store")
+		}
+	}
+
+	_ = ds.Close()
+
+	// Scenario 02 : Invalid backup is rejected
+	{
+		invalid := filepath.Join(testDir, "invalid.db")
+		if err := ioutil.WriteFile(invalid, []byte("invalid"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := RestoreBoltFile(invalid, path, time.Second); err == nil {
+			t.Error("Expected error restoring invalid backup")
+		}
+	}
+
+	// Scenario 03 : Restore replaces data store
+	{
+		if err := RestoreBoltFile(backup, path, time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		ds, err := NewDataStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = ds.Close() }()
+
+		if n := ds.Size(); n != 1 {
+			t.Errorf("Expected Size()==1 but got %d", n)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenPurpose separates one-time tokens of different flows, so token of one flow can't be used in other
+type TokenPurpose string
+
+const (
+	PurposePasswordReset     TokenPurpose = "password-reset"
+	PurposeEmailVerification TokenPurpose = "email-verification"
+)
+
+// OneTimeToken is record of single-use token sent to user.
+// Only hash of token is stored, so leaked data store can't be used to reset passwords.
+type OneTimeToken struct {
+	Hash    string       `storm:"id"`
+	Purpose TokenPurpose `storm:"index"`
+	UserID  uuid.UUID    `storm:"index"`
+	// Email is address token was sent to
+	Email     string
+	ExpiresAt time.Time
+}
+
+// NewOneTimeToken generates random token and its record valid until now + timeout
+func NewOneTimeToken(purpose TokenPurpose, user *User, timeout time.Duration) (string, *OneTimeToken) {
+	token := generateToken()
+
+	return token, &OneTimeToken{
+		Hash:      HashOneTimeToken(token),
+		Purpose:   purpose,
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(timeout),
+	}
+}
+
+// generateToken generates random URL safe token with 256 bits of entropy
+func generateToken() string {
+	return base64.RawURLEncoding.EncodeToString(generateSalt(32))
+}
+
+// HashOneTimeToken returns hex encoded SHA-256 hash of token.
+// Tokens have 256 bits of entropy, so salt and slow hash are not needed.
+func HashOneTimeToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func (t OneTimeToken) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt)
+}
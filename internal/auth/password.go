@@ -3,19 +3,24 @@ package auth
 import (
 	"bytes"
 	"crypto/rand"
+	"time"
 
 	"golang.org/x/crypto/scrypt"
 )
 
+// PasswordHashObserver is called with duration of every password key derivation, by operation "hash" or "validate".
+// Auth doesn't depend on metrics, so it's set by package collecting them and is nil otherwise.
+var PasswordHashObserver func(operation string, d time.Duration)
+
 type Password struct {
-	Hash []byte
-	Salt []byte
+	Hash []byte `json:"hash"`
+	Salt []byte `json:"salt"`
 }
 
 func HashPassword(password string) (Password, error) {
 	salt := generateSalt(32)
 
-	hash, err := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+	hash, err := deriveKey(password, salt, "hash")
 	if err != nil {
 		return Password{}, err
 	}
@@ -27,13 +32,21 @@ func HashPassword(password string) (Password, error) {
 }
 
 func (p Password) Validate(password string) bool {
-	hash, err := scrypt.Key([]byte(password), p.Salt, 32768, 8, 1, 32)
+	hash, err := deriveKey(password, p.Salt, "validate")
 	if err != nil {
 		return false
 	}
 	return bytes.Equal(hash, p.Hash)
 }
 
+// deriveKey derives key of password, reporting duration to PasswordHashObserver by operation
+func deriveKey(password string, salt []byte, operation string) ([]byte, error) {
+	if observe := PasswordHashObserver; observe != nil {
+		defer func(start time.Time) { observe(operation, time.Since(start)) }(time.Now())
+	}
+	return scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+}
+
 func generateSalt(bytes int) []byte {
 	buff := make([]byte, bytes)
 	_, _ = rand.Read(buff)
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+)
+
+const (
+	MaxEmailLength       = 254
+	MaxDisplayNameLength = 256
+	// MaxAttributeLength limits attribute values, including values of attributes without schema
+	MaxAttributeLength = 1024
+)
+
+// Claim names of standard profile fields. Custom attributes are selected by attribute name.
+const (
+	ClaimEmail       = "email"
+	ClaimDisplayName = "display_name"
+)
+
+var (
+	ErrInvalidAttributeSchema = errors.New("profile: invalid attribute schema")
+	ErrUnknownClaim           = errors.New("profile: unknown claim")
+)
+
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// AttributeType is type of attribute value. Values are stored as strings regardless of type.
+type AttributeType string
+
+const (
+	AttributeString  AttributeType = "string"
+	AttributeInteger AttributeType = "integer"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeEmail   AttributeType = "email"
+	AttributeURL     AttributeType = "url"
+)
+
+// AttributeSchema validates custom attribute with same name.
+// Attributes without schema are accepted as long as name and length are valid.
+type AttributeSchema struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Type defaults to string
+	Type     AttributeType `json:"type,omitempty"`
+	Required bool          `json:"required,omitempty"`
+	// Pattern is regular expression whole value must match
+	Pattern   string `json:"pattern,omitempty"`
+	MaxLength int    `json:"maxLength,omitempty"`
+	// Claim allows attribute to be selected into access token claims
+	Claim bool `json:"claim,omitempty"`
+}
+
+// ProfileError reports invalid profile field
+type ProfileError struct {
+	Field  string
+	Reason string
+}
+
+func (e ProfileError) Error() string {
+	return fmt.Sprintf("profile: %s: %s", e.Field, e.Reason)
+}
+
+func (s AttributeSchema) compile() (*regexp.Regexp, error) {
+	if s.Pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(`^(?:` + s.Pattern + `)$`)
+}
+
+// ValidateAttributeSchemas checks that names are valid and unique, and types and patterns are known
+func ValidateAttributeSchemas(schemas []AttributeSchema) error {
+	names := make(map[string]bool)
+	for _, s := range schemas {
+		if !attributeNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("%w: invalid name %q", ErrInvalidAttributeSchema, s.Name)
+		}
+
+		if s.Name == ClaimEmail || s.Name == ClaimDisplayName {
+			return fmt.Errorf("%w: name %q is reserved for profile field", ErrInvalidAttributeSchema, s.Name)
+		}
+
+		if names[s.Name] {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidAttributeSchema, s.Name)
+		}
+		names[s.Name] = true
+
+		switch s.Type {
+		case "", AttributeString, AttributeInteger, AttributeBoolean, AttributeEmail, AttributeURL:
+		default:
+			return fmt.Errorf("%w: %s: unknown type %q", ErrInvalidAttributeSchema, s.Name, s.Type)
+		}
+
+		if s.MaxLength < 0 || s.MaxLength > MaxAttributeLength {
+			return fmt.Errorf("%w: %s: max length out of range", ErrInvalidAttributeSchema, s.Name)
+		}
+
+		if _, err := s.compile(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidAttributeSchema, s.Name, err)
+		}
+	}
+	return nil
+}
+
+// SortAttributeSchemas sorts schemas by name
+func SortAttributeSchemas(schemas []AttributeSchema) {
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Name < schemas[j].Name
+	})
+}
+
+func validEmail(s string) bool {
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Address == s
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s AttributeSchema) validate(v string) string {
+	if s.MaxLength > 0 && utf8.RuneCountInString(v) > s.MaxLength {
+		return fmt.Sprintf("longer than %d characters", s.MaxLength)
+	}
+
+	switch s.Type {
+	case AttributeInteger:
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "not an integer"
+		}
+	case AttributeBoolean:
+		if v != "true" && v != "false" {
+			return "not a boolean"
+		}
+	case AttributeEmail:
+		if !validEmail(v) {
+			return "not an email address"
+		}
+	case AttributeURL:
+		if !validURL(v) {
+			return "not an http or https URL"
+		}
+	}
+
+	if re, err := s.compile(); err == nil && re != nil && !re.MatchString(v) {
+		return "doesn't match pattern"
+	}
+	return ""
+}
+
+// ValidateProfile checks profile fields of user against attribute schemas
+func ValidateProfile(u *User, schemas []AttributeSchema) error {
+	if u.Email != "" && (len(u.Email) > MaxEmailLength || !validEmail(u.Email)) {
+		return ProfileError{Field: "email", Reason: "not an email address"}
+	}
+
+	if utf8.RuneCountInString(u.DisplayName) > MaxDisplayNameLength {
+		return ProfileError{Field: "displayName", Reason: fmt.Sprintf("longer than %d characters", MaxDisplayNameLength)}
+	}
+
+	for k, v := range u.Attributes {
+		if !attributeNamePattern.MatchString(k) {
+			return ProfileError{Field: "attributes." + k, Reason: "invalid attribute name"}
+		}
+
+		if utf8.RuneCountInString(v) > MaxAttributeLength {
+			return ProfileError{Field: "attributes." + k, Reason: fmt.Sprintf("longer than %d characters", MaxAttributeLength)}
+		}
+	}
+
+	for _, s := range schemas {
+		v, ok := u.Attributes[s.Name]
+		if !ok {
+			if s.Required {
+				return ProfileError{Field: "attributes." + s.Name, Reason: "required"}
+			}
+			continue
+		}
+
+		if reason := s.validate(v); reason != "" {
+			return ProfileError{Field: "attributes." + s.Name, Reason: reason}
+		}
+	}
+
+	return nil
+}
+
+// SelectProfile returns profile claims named in claims.
+// Custom attribute can be selected only if its schema allows claim. Empty values are omitted.
+func SelectProfile(u User, schemas []AttributeSchema, claims []string) (gosso.Profile, error) {
+	var p gosso.Profile
+
+	for _, c := range claims {
+		switch c {
+		case ClaimEmail:
+			p.Email = u.Email
+			continue
+		case ClaimDisplayName:
+			p.DisplayName = u.DisplayName
+			continue
+		}
+
+		allowed := false
+		for _, s := range schemas {
+			if s.Name == c && s.Claim {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return gosso.Profile{}, fmt.Errorf("%w: %s", ErrUnknownClaim, c)
+		}
+
+		if v, ok := u.Attributes[c]; ok {
+			if p.Attributes == nil {
+				p.Attributes = make(map[string]string)
+			}
+			p.Attributes[c] = v
+		}
+	}
+
+	return p, nil
+}
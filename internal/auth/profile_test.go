@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+var testSchemas = []AttributeSchema{
+	{Name: "employee_id", Type: AttributeInteger, Required: true, Claim: true},
+	{Name: "team", Pattern: "[a-z]+", MaxLength: 8, Claim: true},
+	{Name: "homepage", Type: AttributeURL},
+}
+
+func TestValidateAttributeSchemas(t *testing.T) {
+	if err := ValidateAttributeSchemas(testSchemas); err != nil {
+		t.Error(err)
+	}
+
+	for i, v := range [][]AttributeSchema{
+		{{Name: "Team"}},
+		{{Name: "team"}, {Name: "team"}},
+		{{Name: "email"}},
+		{{Name: "team", Type: "date"}},
+		{{Name: "team", Pattern: "("}},
+		{{Name: "team", MaxLength: -1}},
+	} {
+		if err := ValidateAttributeSchemas(v); !errors.Is(err, ErrInvalidAttributeSchema) {
+			t.Errorf("%d: Expected ErrInvalidAttributeSchema but got %v", i, err)
+		}
+	}
+}
+
+func TestValidateProfile(t *testing.T) {
+	for i, v := range []struct {
+		user  User
+		field string
+	}{
+		{User{Email: "hello@example.com", Attributes: map[string]string{"employee_id": "42", "team": "sso", "room": "any value"}}, ""},
+		{User{Email: "Hello <hello@example.com>", Attributes: map[string]string{"employee_id": "42"}}, "email"},
+		{User{Attributes: map[string]string{"team": "sso"}}, "attributes.employee_id"},
+		{User{Attributes: map[string]string{"employee_id": "forty two"}}, "attributes.employee_id"},
+		{User{Attributes: map[string]string{"employee_id": "42", "team": "SSO"}}, "attributes.team"},
+		{User{Attributes: map[string]string{"employee_id": "42", "team": "ssoteamxyz"}}, "attributes.team"},
+		{User{Attributes: map[string]string{"employee_id": "42", "homepage": "ftp://example.com"}}, "attributes.homepage"},
+		{User{Attributes: map[string]string{"employee_id": "42", "Room": "1"}}, "attributes.Room"},
+	} {
+		err := ValidateProfile(&v.user, testSchemas)
+		if v.field == "" {
+			if err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+			continue
+		}
+
+		if pe, ok := err.(ProfileError); !ok || pe.Field != v.field {
+			t.Errorf("%d: Expected error on %s but got %v", i, v.field, err)
+		}
+	}
+}
+
+func TestSelectProfile(t *testing.T) {
+	u := User{
+		Email:       "hello@example.com",
+		DisplayName: "Hello",
+		Attributes:  map[string]string{"employee_id": "42", "homepage": "https://example.com"},
+	}
+
+	p, err := SelectProfile(u, testSchemas, []string{ClaimEmail, "employee_id", "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Email != u.Email || p.DisplayName != "" || len(p.Attributes) != 1 || p.Attributes["employee_id"] != "42" {
+		t.Errorf("Unexpected profile %+v", p)
+	}
+
+	// Attributes without claim permission can't be selected
+	for _, c := range []string{"homepage", "room"} {
+		if _, err := SelectProfile(u, testSchemas, []string{c}); !errors.Is(err, ErrUnknownClaim) {
+			t.Errorf("%s: Expected ErrUnknownClaim but got %v", c, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import "github.com/dfkdream/permission"
+
+// ScopePermissions returns intersection of granted permissions and requested permission subset.
+// Permissions are matched by first rule, so rules are emitted in order of requested rules:
+// requested deny rule is kept as is, and requested allow rule is replaced by every granted rule
+// narrowed to its namespace, in original order of granted rules. Each rule is emitted once.
+func ScopePermissions(granted, requested []permission.Permission) []permission.Permission {
+	scoped := make([]permission.Permission, 0)
+
+	add := func(p permission.Permission) {
+		for _, s := range scoped {
+			if s.Equals(p) {
+				return
+			}
+		}
+		scoped = append(scoped, p)
+	}
+
+	for _, r := range requested {
+		if !r.Allow {
+			add(r)
+			continue
+		}
+
+		for _, g := range granted {
+			if ns, ok := intersectNamespaces(r.Namespaces, g.Namespaces); ok {
+				add(permission.Permission{Allow: g.Allow, Namespaces: ns})
+			}
+		}
+	}
+
+	return scoped
+}
+
+// intersectNamespaces returns namespace matched by both a and b, taking literal segment over wildcard
+func intersectNamespaces(a, b []string) ([]string, bool) {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+
+	ns := make([]string, len(a))
+	copy(ns, a)
+
+	for i, v := range b {
+		switch {
+		case v == "*":
+		case ns[i] == "*":
+			ns[i] = v
+		case ns[i] != v:
+			return nil, false
+		}
+	}
+
+	return ns, true
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/dfkdream/permission"
+)
+
+func TestScopePermissions(t *testing.T) {
+	for i, v := range []struct {
+		granted   []string
+		requested []string
+		checks    map[string]bool
+	}{
+		{
+			granted:   []string{"+:*"},
+			requested: []string{"app:read"},
+			checks:    map[string]bool{"app:read": true, "app:write": false, "gosso": false},
+		},
+		{
+			granted:   []string{"-:app:admin", "+:app:*"},
+			requested: []string{"app:*"},
+			checks:    map[string]bool{"app:read": true, "app:admin": false, "gosso": false},
+		},
+		{
+			granted:   []string{"+:app:read"},
+			requested: []string{"app:*"},
+			checks:    map[string]bool{"app:read": true, "app:write": false},
+		},
+		{
+			granted:   []string{"+:app:*"},
+			requested: []string{"-:app:write", "app:*"},
+			checks:    map[string]bool{"app:read": true, "app:write": false},
+		},
+		{
+			// Granted deny rule outside namespace of requested allow rule is kept
+			granted:   []string{"-:*:secret", "+:a"},
+			requested: []string{"+:a"},
+			checks:    map[string]bool{"a": true, "a:secret": false, "a:public": true},
+		},
+		{
+			// Specific allow rule before broad deny rule keeps precedence
+			granted:   []string{"+:app:read", "-:app"},
+			requested: []string{"+:app"},
+			checks:    map[string]bool{"app:read": true, "app:write": false, "app": false},
+		},
+		{
+			granted:   []string{"+:app", "-:app:read:secret"},
+			requested: []string{"+:app:read"},
+			checks:    map[string]bool{"app:read": true, "app:read:secret": true, "app:write": false},
+		},
+		{
+			// Granted rule partially overlapping requested rule is narrowed to overlap
+			granted:   []string{"+:*:read"},
+			requested: []string{"+:app:*"},
+			checks:    map[string]bool{"app:read": true, "app:write": false, "other:read": false},
+		},
+		{
+			granted:   []string{"+:gosso"},
+			requested: []string{"app:read"},
+			checks:    map[string]bool{"app:read": false, "gosso": false},
+		},
+	} {
+		granted := make([]permission.Permission, 0)
+		for _, p := range v.granted {
+			granted = append(granted, mustPermission(p))
+		}
+
+		requested := make([]permission.Permission, 0)
+		for _, p := range v.requested {
+			requested = append(requested, mustPermission(p))
+		}
+
+		scoped := ScopePermissions(granted, requested)
+
+		seen := make(map[string]bool)
+		for _, p := range scoped {
+			if seen[p.String()] {
+				t.Errorf("%d: %s is emitted twice (%v)", i, p, scoped)
+			}
+			seen[p.String()] = true
+		}
+
+		for p, has := range v.checks {
+			if mustPermission(p).HasPermission(scoped) != has {
+				t.Errorf("%d: %s: expected %t but got %t (%v)", i, p, has, !has, scoped)
+			}
+		}
+	}
+}
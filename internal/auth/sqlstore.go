@@ -0,0 +1,1109 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+// Statements use only types and syntax shared by SQLite and PostgreSQL.
+// Queries number placeholders in order of appearance, since SQLite binds $N by position of first use.
+const sqlVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER NOT NULL
+)`
+
+type sqlMigration struct {
+	Migration
+	statements []string
+}
+
+// sqlMigrations must stay in version order. Released migrations must not be changed.
+var sqlMigrations = []sqlMigration{
+	{
+		Migration: Migration{Version: 1, Description: "Create users and groups tables"},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS users (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL,
+				password_salt TEXT NOT NULL,
+				permissions TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS user_groups (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				permissions TEXT NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS group_members (
+				group_id TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				PRIMARY KEY (group_id, user_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS group_members_user_id ON group_members (user_id)`,
+			// Row locked by Bootstrap to serialize concurrent bootstrap attempts
+			`CREATE TABLE IF NOT EXISTS locks (
+				name TEXT PRIMARY KEY
+			)`,
+			`INSERT INTO locks (name) SELECT 'bootstrap' WHERE NOT EXISTS (SELECT 1 FROM locks WHERE name = 'bootstrap')`,
+		},
+	},
+	{
+		Migration: Migration{Version: 2, Description: "Add user profile columns and attribute schemas table"},
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}'`,
+			`CREATE TABLE IF NOT EXISTS attribute_schemas (
+				name TEXT PRIMARY KEY,
+				definition TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		Migration: Migration{Version: 3, Description: "Add email verification column and one-time tokens table"},
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE TABLE IF NOT EXISTS one_time_tokens (
+				hash TEXT PRIMARY KEY,
+				purpose TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				email TEXT NOT NULL,
+				-- Unix nanoseconds like every other timestamp
+				expires_at BIGINT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS one_time_tokens_user_id ON one_time_tokens (user_id)`,
+		},
+	},
+	{
+		Migration: Migration{Version: 4, Description: "Create invitations table"},
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS invitations (
+				id TEXT PRIMARY KEY,
+				hash TEXT NOT NULL UNIQUE,
+				permissions TEXT NOT NULL,
+				email TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				created_at BIGINT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		Migration: Migration{Version: 5, Description: "Add account status columns"},
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN status_state TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN status_reason TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN status_until BIGINT`,
+			`ALTER TABLE users ADD COLUMN expires_at BIGINT`,
+		},
+	},
+	{
+		Migration: Migration{Version: 6, Description: "Add security stamp column"},
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN security_stamp TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Migration: Migration{Version: 7, Description: "Add invitation mailed column"},
+		statements: []string{
+			// Existing invitations aren't known to be mailed, so their email isn't verified on accept
+			`ALTER TABLE invitations ADD COLUMN mailed BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+}
+
+const userColumns = `id, username, password_hash, password_salt, permissions, email, display_name, attributes, email_verified, status_state, status_reason, status_until, expires_at, security_stamp`
+
+const groupColumns = `id, name, permissions`
+
+const invitationColumns = `id, hash, permissions, email, created_by, created_at, expires_at, mailed`
+
+// SQLStore stores users and groups through database/sql.
+// Queries are written for both SQLite and PostgreSQL, and driver is registered by caller.
+// SQLite database should be opened with single connection or busy timeout to avoid lock errors.
+type SQLStore struct {
+	db *sql.DB
+}
+
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// NewSQLStore creates schema version table if it doesn't exist and applies pending migrations.
+// Database isn't backed up before migrations, so take backup with database tools such as pg_dump before upgrading.
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	s, err := openSQLStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateOnOpen(s, ""); err != nil {
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+	return s, nil
+}
+
+// openSQLStore creates schema version table only, leaving migrations pending
+func openSQLStore(db *sql.DB) (*SQLStore, error) {
+	if _, err := db.Exec(sqlVersionTable); err != nil {
+		return nil, err
+	}
+
+	return &SQLStore{
+		db: db,
+	}, nil
+}
+
+// OpenSQLStore opens database using registered driver
+func OpenSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSQLStore(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s SQLStore) SchemaVersion() (int, error) {
+	var v int
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&v)
+	return v, err
+}
+
+func (s SQLStore) Migrations() []Migration {
+	m := make([]Migration, len(sqlMigrations))
+	for i := range sqlMigrations {
+		m[i] = sqlMigrations[i].Migration
+	}
+	return m
+}
+
+// Backup is not supported since SQL databases have their own backup tools such as pg_dump
+func (s SQLStore) Backup(_ io.Writer) error {
+	return ErrBackupUnsupported
+}
+
+func (s SQLStore) applyMigration(version int) error {
+	for _, m := range sqlMigrations {
+		if m.Version != version {
+			continue
+		}
+
+		return s.inTx(func(tx *sql.Tx) error {
+			for _, q := range m.statements {
+				if _, err := tx.Exec(q); err != nil {
+					return fmt.Errorf("migration %d: %w", version, err)
+				}
+			}
+
+			if _, err := tx.Exec(`DELETE FROM schema_version`); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version)
+			return err
+		})
+	}
+	return fmt.Errorf("migration %d: %w", version, ErrNotFound)
+}
+
+func (s SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// inTx runs f in transaction, committing if f succeeds
+func (s SQLStore) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// conflictError translates unique constraint violation into ErrAlreadyExists.
+// Existence checks before writes don't hold against concurrent writers, so losing writer hits the constraint instead.
+// Drivers are registered by caller, so violation is recognized by SQLSTATE or driver message instead of driver error types.
+func conflictError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) && state.SQLState() == "23505" {
+		return ErrAlreadyExists
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, "UNIQUE constraint failed") || strings.Contains(msg, "duplicate key value violates unique constraint") {
+		return ErrAlreadyExists
+	}
+
+	return err
+}
+
+func marshalPermissions(p []permission.Permission) (string, error) {
+	if p == nil {
+		p = make([]permission.Permission, 0)
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func unmarshalPermissions(s string) ([]permission.Permission, error) {
+	p := make([]permission.Permission, 0)
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func marshalAttributes(a map[string]string) (string, error) {
+	if a == nil {
+		a = make(map[string]string)
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// unmarshalAttributes returns nil for empty attributes, like stores keeping user as is
+func unmarshalAttributes(s string) (map[string]string, error) {
+	a := make(map[string]string)
+	if err := json.Unmarshal([]byte(s), &a); err != nil {
+		return nil, err
+	}
+
+	if len(a) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// nullInt64 stores optional time as Unix nanoseconds
+func nullInt64(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func nullTime(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+
+	t := time.Unix(0, n.Int64)
+	return &t
+}
+
+func scanUser(row scanner) (*User, error) {
+	var id, hash, salt, perms, attrs, state string
+	var until, expires sql.NullInt64
+	u := new(User)
+
+	err := row.Scan(&id, &u.Username, &hash, &salt, &perms, &u.Email, &u.DisplayName, &attrs, &u.EmailVerified,
+		&state, &u.Status.Reason, &until, &expires, &u.SecurityStamp)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if u.ID, err = uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	if u.Password.Hash, err = base64.StdEncoding.DecodeString(hash); err != nil {
+		return nil, err
+	}
+
+	if u.Password.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+		return nil, err
+	}
+
+	if u.Permissions, err = unmarshalPermissions(perms); err != nil {
+		return nil, err
+	}
+
+	if u.Attributes, err = unmarshalAttributes(attrs); err != nil {
+		return nil, err
+	}
+
+	u.Status.State = AccountState(state)
+	u.Status.Until = nullTime(until)
+	u.Status.ExpiresAt = nullTime(expires)
+
+	return u, nil
+}
+
+// userArgs returns column values of user in userColumns order
+func userArgs(u *User) ([]interface{}, error) {
+	perms, err := marshalPermissions(u.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := marshalAttributes(u.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{
+		u.ID.String(),
+		u.Username,
+		base64.StdEncoding.EncodeToString(u.Password.Hash),
+		base64.StdEncoding.EncodeToString(u.Password.Salt),
+		perms,
+		u.Email,
+		u.DisplayName,
+		attrs,
+		u.EmailVerified,
+		string(u.Status.State),
+		u.Status.Reason,
+		nullInt64(u.Status.Until),
+		nullInt64(u.Status.ExpiresAt),
+		u.SecurityStamp,
+	}, nil
+}
+
+func insertUser(q queryer, u *User) error {
+	args, err := userArgs(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`, args...)
+	return err
+}
+
+func (s SQLStore) AddUser(user *User) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE id = $1 OR username = $2`, user.ID.String(), user.Username).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		return insertUser(tx, user)
+	}))
+}
+
+// Bootstrap locks bootstrap row first, so concurrent attempt waits until this transaction ends
+// and sees committed user.
+func (s SQLStore) Bootstrap(user *User) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE locks SET name = name WHERE name = 'bootstrap'`)
+		if err != nil {
+			return err
+		}
+
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyBootstrapped
+		}
+
+		return insertUser(tx, user)
+	}))
+}
+
+func (s SQLStore) UpdateUser(user *User) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE id <> $1 AND username = $2`, user.ID.String(), user.Username).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		args, err := userArgs(user)
+		if err != nil {
+			return err
+		}
+
+		r, err := tx.Exec(`UPDATE users SET username = $1, password_hash = $2, password_salt = $3, permissions = $4,
+			email = $5, display_name = $6, attributes = $7, email_verified = $8,
+			status_state = $9, status_reason = $10, status_until = $11, expires_at = $12, security_stamp = $13
+			WHERE id = $14`, append(args[1:], args[0])...)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(r)
+	}))
+}
+
+// requireAffected returns ErrNotFound if statement affected no row
+func requireAffected(r sql.Result) error {
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s SQLStore) DeleteUser(user *User) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM group_members WHERE user_id = $1`, user.ID.String())
+		if err != nil {
+			return err
+		}
+
+		r, err := tx.Exec(`DELETE FROM users WHERE id = $1`, user.ID.String())
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(r)
+	})
+}
+
+func (s SQLStore) GetUserByID(id uuid.UUID) (*User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id.String()))
+}
+
+func (s SQLStore) GetUserByUsername(username string) (*User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+func (s SQLStore) Size() int {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		log.Println(err)
+		return -1
+	}
+	return n
+}
+
+// GetAllUsers returns users sorted by ID
+func (s SQLStore) GetAllUsers() ([]User, error) {
+	rows, err := s.db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	uList := make([]User, 0)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		uList = append(uList, *u)
+	}
+
+	return uList, rows.Err()
+}
+
+// QueryUsers filters, sorts and pages users in database, counting total in separate query.
+// Permission filter can't be expressed in SQL, so queries with it stream rows matching prefix through query pager.
+// Order follows collation of database, which may differ from byte order used by other data stores.
+func (s SQLStore) QueryUsers(query UserQuery) (*UserPage, error) {
+	p, err := newUserPager(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.Prefix != "" {
+		// substr is case sensitive unlike LIKE in SQLite
+		where = append(where, `substr(username, 1, `+arg(utf8.RuneCountInString(query.Prefix))+`) = `+arg(query.Prefix))
+	}
+
+	if query.Permission != nil {
+		return s.streamUsers(p, where, args)
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM users`+whereClause(where), args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	order, op := `ASC`, `>`
+	if strings.HasPrefix(string(p.query.Sort), "-") {
+		order, op = `DESC`, `<`
+	}
+
+	byUsername := p.query.Sort == SortByUsername || p.query.Sort == SortByUsernameDesc
+	orderBy := `id ` + order
+	if byUsername {
+		orderBy = `username ` + order + `, ` + orderBy
+	}
+
+	if p.after != nil {
+		if byUsername {
+			where = append(where, `(username, id) `+op+` (`+arg(p.after.Username)+`, `+arg(p.after.ID.String())+`)`)
+		} else {
+			where = append(where, `id `+op+` `+arg(p.after.ID.String()))
+		}
+	}
+
+	q := `SELECT ` + userColumns + ` FROM users` + whereClause(where) + ` ORDER BY ` + orderBy + ` LIMIT ` + arg(p.query.Limit+1)
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	page := make([]User, 0, p.query.Limit+1)
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, *u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return p.ordered(page, total), nil
+}
+
+// streamUsers feeds every user matching where to pager
+func (s SQLStore) streamUsers(p *userPager, where []string, args []interface{}) (*UserPage, error) {
+	rows, err := s.db.Query(`SELECT `+userColumns+` FROM users`+whereClause(where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		p.add(*u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return p.result(), nil
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return ` WHERE ` + strings.Join(conditions, ` AND `)
+}
+
+func scanGroup(row scanner) (*Group, error) {
+	var id, perms string
+	g := new(Group)
+
+	err := row.Scan(&id, &g.Name, &perms)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if g.ID, err = uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	if g.Permissions, err = unmarshalPermissions(perms); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// queryGroups runs group query and loads members of every group
+func queryGroups(q queryer, query string, args ...interface{}) ([]Group, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	gList := make([]Group, 0)
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		gList = append(gList, *g)
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range gList {
+		if gList[i].Members, err = groupMembers(q, gList[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return gList, nil
+}
+
+func groupMembers(q queryer, id uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.Query(`SELECT user_id FROM group_members WHERE group_id = $1 ORDER BY position`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	members := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return nil, err
+		}
+
+		uid, err := uuid.Parse(m)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, uid)
+	}
+
+	return members, rows.Err()
+}
+
+// writeGroup inserts or updates group row and replaces its members
+func writeGroup(tx *sql.Tx, group *Group, update bool) error {
+	perms, err := marshalPermissions(group.Permissions)
+	if err != nil {
+		return err
+	}
+
+	if update {
+		r, err := tx.Exec(`UPDATE user_groups SET name = $1, permissions = $2 WHERE id = $3`, group.Name, perms, group.ID.String())
+		if err != nil {
+			return err
+		}
+
+		if err := requireAffected(r); err != nil {
+			return err
+		}
+	} else {
+		_, err := tx.Exec(`INSERT INTO user_groups (`+groupColumns+`) VALUES ($1, $2, $3)`, group.ID.String(), group.Name, perms)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeMembers(tx, group.ID, group.Members)
+}
+
+// writeMembers replaces members of group
+func writeMembers(tx *sql.Tx, id uuid.UUID, members []uuid.UUID) error {
+	_, err := tx.Exec(`DELETE FROM group_members WHERE group_id = $1`, id.String())
+	if err != nil {
+		return err
+	}
+
+	for i, m := range members {
+		_, err := tx.Exec(`INSERT INTO group_members (group_id, user_id, position) VALUES ($1, $2, $3)`, id.String(), m.String(), i)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockGroup locks group row until end of transaction, so member changes of group are serialized
+func lockGroup(tx *sql.Tx, id uuid.UUID) error {
+	r, err := tx.Exec(`UPDATE user_groups SET name = name WHERE id = $1`, id.String())
+	if err != nil {
+		return err
+	}
+	return requireAffected(r)
+}
+
+func (s SQLStore) AddGroupMember(groupID, userID uuid.UUID) (bool, error) {
+	added := false
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := lockGroup(tx, groupID); err != nil {
+			return err
+		}
+
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID.String(), userID.String()).Scan(&n)
+		if err != nil || n > 0 {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO group_members (group_id, user_id, position)
+			SELECT $1, $2, COALESCE(MAX(position) + 1, 0) FROM group_members WHERE group_id = $1`, groupID.String(), userID.String())
+		added = err == nil
+		return err
+	})
+	return added, err
+}
+
+func (s SQLStore) RemoveGroupMember(groupID, userID uuid.UUID) error {
+	r, err := s.db.Exec(`DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`, groupID.String(), userID.String())
+	if err != nil {
+		return err
+	}
+	return requireAffected(r)
+}
+
+func (s SQLStore) SetGroupMembers(groupID uuid.UUID, members []uuid.UUID) ([]uuid.UUID, error) {
+	var old []uuid.UUID
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := lockGroup(tx, groupID); err != nil {
+			return err
+		}
+
+		var err error
+		if old, err = groupMembers(tx, groupID); err != nil {
+			return err
+		}
+
+		return writeMembers(tx, groupID, members)
+	})
+	return old, err
+}
+
+func (s SQLStore) AddGroup(group *Group) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM user_groups WHERE id = $1 OR name = $2`, group.ID.String(), group.Name).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		return writeGroup(tx, group, false)
+	}))
+}
+
+func (s SQLStore) UpdateGroup(group *Group) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM user_groups WHERE id <> $1 AND name = $2`, group.ID.String(), group.Name).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		return writeGroup(tx, group, true)
+	}))
+}
+
+func (s SQLStore) DeleteGroup(group *Group) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM group_members WHERE group_id = $1`, group.ID.String())
+		if err != nil {
+			return err
+		}
+
+		r, err := tx.Exec(`DELETE FROM user_groups WHERE id = $1`, group.ID.String())
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(r)
+	})
+}
+
+func (s SQLStore) getGroup(query string, arg interface{}) (*Group, error) {
+	gList, err := queryGroups(s.db, `SELECT `+groupColumns+` FROM user_groups WHERE `+query, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(gList) == 0 {
+		return nil, ErrNotFound
+	}
+	return &gList[0], nil
+}
+
+func (s SQLStore) GetGroupByID(id uuid.UUID) (*Group, error) {
+	return s.getGroup(`id = $1`, id.String())
+}
+
+func (s SQLStore) GetGroupByName(name string) (*Group, error) {
+	return s.getGroup(`name = $1`, name)
+}
+
+// GetAllGroups returns groups sorted by ID
+func (s SQLStore) GetAllGroups() ([]Group, error) {
+	return queryGroups(s.db, `SELECT `+groupColumns+` FROM user_groups ORDER BY id`)
+}
+
+func (s SQLStore) GetGroupsByMember(id uuid.UUID) ([]Group, error) {
+	return queryGroups(s.db, `SELECT `+groupColumns+` FROM user_groups WHERE id IN (SELECT group_id FROM group_members WHERE user_id = $1) ORDER BY name`, id.String())
+}
+
+func (s SQLStore) GetAttributeSchemas() ([]AttributeSchema, error) {
+	rows, err := s.db.Query(`SELECT definition FROM attribute_schemas ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	schemas := make([]AttributeSchema, 0)
+	for rows.Next() {
+		var def string
+		if err := rows.Scan(&def); err != nil {
+			return nil, err
+		}
+
+		var a AttributeSchema
+		if err := json.Unmarshal([]byte(def), &a); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, a)
+	}
+
+	return schemas, rows.Err()
+}
+
+func (s SQLStore) SetAttributeSchemas(schemas []AttributeSchema) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM attribute_schemas`); err != nil {
+			return err
+		}
+
+		for _, a := range schemas {
+			b, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(`INSERT INTO attribute_schemas (name, definition) VALUES ($1, $2)`, a.Name, string(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s SQLStore) AddOneTimeToken(token *OneTimeToken) error {
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM one_time_tokens WHERE hash = $1`, token.Hash).Scan(&n); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		_, err := tx.Exec(`INSERT INTO one_time_tokens (hash, purpose, user_id, email, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+			token.Hash, string(token.Purpose), token.UserID.String(), token.Email, token.ExpiresAt.UnixNano())
+		return err
+	}))
+}
+
+// ConsumeOneTimeToken reads token and deletes it in same transaction.
+// Concurrent consumer whose delete affects no row gets ErrNotFound.
+func (s SQLStore) ConsumeOneTimeToken(purpose TokenPurpose, hash string) (*OneTimeToken, error) {
+	var t *OneTimeToken
+	err := s.inTx(func(tx *sql.Tx) error {
+		var userID string
+		var expires int64
+		t = &OneTimeToken{Hash: hash, Purpose: purpose}
+
+		err := tx.QueryRow(`SELECT user_id, email, expires_at FROM one_time_tokens WHERE hash = $1 AND purpose = $2`, hash, string(purpose)).
+			Scan(&userID, &t.Email, &expires)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if t.UserID, err = uuid.Parse(userID); err != nil {
+			return err
+		}
+		t.ExpiresAt = time.Unix(0, expires)
+
+		r, err := tx.Exec(`DELETE FROM one_time_tokens WHERE hash = $1`, hash)
+		if err != nil {
+			return err
+		}
+		return requireAffected(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s SQLStore) DeleteOneTimeTokens(userID uuid.UUID, purpose TokenPurpose) error {
+	_, err := s.db.Exec(`DELETE FROM one_time_tokens WHERE user_id = $1 AND purpose = $2`, userID.String(), string(purpose))
+	return err
+}
+
+func scanInvitation(row scanner) (*Invitation, error) {
+	var id, perms, createdBy string
+	var createdAt, expiresAt int64
+	i := new(Invitation)
+
+	err := row.Scan(&id, &i.Hash, &perms, &i.Email, &createdBy, &createdAt, &expiresAt, &i.Mailed)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if i.ID, err = uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	if i.CreatedBy, err = uuid.Parse(createdBy); err != nil {
+		return nil, err
+	}
+
+	if i.Permissions, err = unmarshalPermissions(perms); err != nil {
+		return nil, err
+	}
+
+	i.CreatedAt = time.Unix(0, createdAt)
+	i.ExpiresAt = time.Unix(0, expiresAt)
+	return i, nil
+}
+
+func (s SQLStore) AddInvitation(invitation *Invitation) error {
+	perms, err := marshalPermissions(invitation.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return conflictError(s.inTx(func(tx *sql.Tx) error {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM invitations WHERE id = $1 OR hash = $2`, invitation.ID.String(), invitation.Hash).Scan(&n)
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return ErrAlreadyExists
+		}
+
+		_, err = tx.Exec(`INSERT INTO invitations (`+invitationColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			invitation.ID.String(), invitation.Hash, perms, invitation.Email, invitation.CreatedBy.String(),
+			invitation.CreatedAt.UnixNano(), invitation.ExpiresAt.UnixNano(), invitation.Mailed)
+		return err
+	}))
+}
+
+func (s SQLStore) GetAllInvitations() ([]Invitation, error) {
+	rows, err := s.db.Query(`SELECT ` + invitationColumns + ` FROM invitations ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	iList := make([]Invitation, 0)
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		iList = append(iList, *i)
+	}
+
+	return iList, rows.Err()
+}
+
+func (s SQLStore) DeleteInvitation(id uuid.UUID) error {
+	r, err := s.db.Exec(`DELETE FROM invitations WHERE id = $1`, id.String())
+	if err != nil {
+		return err
+	}
+	return requireAffected(r)
+}
+
+// ConsumeInvitation reads invitation and deletes it in same transaction.
+// Concurrent consumer whose delete affects no row gets ErrNotFound.
+func (s SQLStore) ConsumeInvitation(hash string) (*Invitation, error) {
+	var i *Invitation
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		i, err = scanInvitation(tx.QueryRow(`SELECT `+invitationColumns+` FROM invitations WHERE hash = $1`, hash))
+		if err != nil {
+			return err
+		}
+
+		r, err := tx.Exec(`DELETE FROM invitations WHERE id = $1`, i.ID.String())
+		if err != nil {
+			return err
+		}
+		return requireAffected(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAccountDisabled = errors.New("account: disabled")
+	ErrAccountLocked   = errors.New("account: locked")
+	ErrAccountExpired  = errors.New("account: expired")
+)
+
+// AccountState is state set by admin. Empty state is active.
+type AccountState string
+
+const (
+	StateActive   AccountState = "active"
+	StateDisabled AccountState = "disabled"
+	StateLocked   AccountState = "locked"
+)
+
+func ParseAccountState(s string) (AccountState, error) {
+	switch v := AccountState(s); v {
+	case "":
+		return StateActive, nil
+	case StateActive, StateDisabled, StateLocked:
+		return v, nil
+	}
+	return "", fmt.Errorf("account: invalid state: %s", s)
+}
+
+// AccountStatus decides whether user can sign in and refresh tokens
+type AccountStatus struct {
+	State  AccountState `json:"state,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	// Until ends disabled or locked state. Nil keeps state until changed.
+	Until *time.Time `json:"until,omitempty"`
+	// ExpiresAt deactivates account from then on regardless of state
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Check returns nil if account is active at now, or error telling why it isn't
+func (s AccountStatus) Check(now time.Time) error {
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return ErrAccountExpired
+	}
+
+	if s.Until != nil && !now.Before(*s.Until) {
+		return nil
+	}
+
+	switch s.State {
+	case StateDisabled:
+		return ErrAccountDisabled
+	case StateLocked:
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// Enable returns active status. Expiry is kept unless account has already expired at now.
+func (s AccountStatus) Enable(now time.Time) AccountStatus {
+	e := AccountStatus{State: StateActive}
+	if s.ExpiresAt != nil && now.Before(*s.ExpiresAt) {
+		e.ExpiresAt = s.ExpiresAt
+	}
+	return e
+}
+
+// Disable returns disabled status keeping expiry. Nil until disables account until enabled.
+func (s AccountStatus) Disable(reason string, until *time.Time) AccountStatus {
+	return AccountStatus{
+		State:     StateDisabled,
+		Reason:    reason,
+		Until:     until,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// IsInactive reports whether err is returned by Check for inactive account
+func IsInactive(err error) bool {
+	return err == ErrAccountDisabled || err == ErrAccountLocked || err == ErrAccountExpired
+}
+
+// Validate checks that state is known
+func (s AccountStatus) Validate() error {
+	_, err := ParseAccountState(string(s.State))
+	return err
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccountStatus_Check(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	for i, v := range []struct {
+		status AccountStatus
+		err    error
+	}{
+		{AccountStatus{}, nil},
+		{AccountStatus{State: StateActive, ExpiresAt: &future}, nil},
+		{AccountStatus{State: StateActive, ExpiresAt: &past}, ErrAccountExpired},
+		{AccountStatus{State: StateDisabled}, ErrAccountDisabled},
+		{AccountStatus{State: StateDisabled, Until: &future}, ErrAccountDisabled},
+		{AccountStatus{State: StateDisabled, Until: &past}, nil},
+		{AccountStatus{State: StateLocked, Until: &future}, ErrAccountLocked},
+		{AccountStatus{State: StateLocked, Until: &past, ExpiresAt: &past}, ErrAccountExpired},
+	} {
+		if err := v.status.Check(now); err != v.err {
+			t.Errorf("%d: Expected %v but got %v", i, v.err, err)
+		}
+	}
+}
+
+func TestAccountStatus_Enable(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	s := AccountStatus{ExpiresAt: &future}.Disable("left", nil)
+	if s.Check(now) != ErrAccountDisabled || s.ExpiresAt != &future {
+		t.Errorf("Expected disabled status keeping expiry but got %+v", s)
+	}
+
+	if e := s.Enable(now); e.Check(now) != nil || e.Reason != "" || e.ExpiresAt != &future {
+		t.Errorf("Expected active status keeping expiry but got %+v", e)
+	}
+
+	// Expired account is re-enabled by clearing expiry
+	if e := (AccountStatus{ExpiresAt: &past}).Enable(now); e.Check(now) != nil || e.ExpiresAt != nil {
+		t.Errorf("Expected active status without expiry but got %+v", e)
+	}
+}
@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"encoding/base64"
+
+	"github.com/dfkdream/GoSSO/pkg/gosso"
 	"github.com/dfkdream/permission"
 	"github.com/google/uuid"
 )
@@ -10,4 +13,31 @@ type User struct {
 	Username    string                  `storm:"unique" json:"username"`
 	Password    Password                `json:"-"`
 	Permissions []permission.Permission `json:"permissions"`
+
+	Email string `json:"email,omitempty"`
+	// EmailVerified is reset whenever email changes
+	EmailVerified bool   `json:"emailVerified,omitempty"`
+	DisplayName   string `json:"displayName,omitempty"`
+	// Attributes are custom attributes validated by attribute schemas of data store
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	Status AccountStatus `json:"status"`
+
+	// SecurityStamp is embedded in refresh tokens. Rotating it revokes every outstanding refresh token.
+	SecurityStamp string `json:"-"`
+}
+
+// RotateSecurityStamp revokes refresh tokens issued before.
+// It should be called whenever credentials, permissions or account status change.
+func (u *User) RotateSecurityStamp() {
+	u.SecurityStamp = base64.RawURLEncoding.EncodeToString(generateSalt(16))
+}
+
+// Public converts user into public user type without credentials
+func (u User) Public() gosso.User {
+	return gosso.User{
+		ID:          u.ID,
+		Username:    u.Username,
+		Permissions: u.Permissions,
+	}
 }
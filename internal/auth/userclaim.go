@@ -1,46 +1,117 @@
 package auth
 
 import (
-	"errors"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
 )
 
+// UserClaim is JWT payload. Subject is user UUID and ID is unique token id.
+// User holds no profile fields, so profile is carried only if set explicitly.
 type UserClaim struct {
-	Audience  string `json:"aud"`
-	ExpiresAt int64  `json:"exp"`
-	IssuedAt  int64  `json:"iat"`
-	NotBefore int64  `json:"nbf"`
-	Issuer    string `json:"iss"`
-	User      User   `json:"usr"`
+	ID        string     `json:"jti"`
+	Subject   string     `json:"sub"`
+	Audience  string     `json:"aud,omitempty"`
+	ExpiresAt int64      `json:"exp"`
+	IssuedAt  int64      `json:"iat"`
+	NotBefore int64      `json:"nbf"`
+	Issuer    string     `json:"iss"`
+	User      gosso.User `json:"usr"`
+	// SecurityStamp is set only in refresh tokens
+	SecurityStamp string `json:"sst,omitempty"`
+
+	gosso.Profile
 }
 
-func (u UserClaim) Valid() error {
-	vErr := new(jwt.ValidationError)
-	now := time.Now().Unix()
+// Public converts claim into public claim type without credentials
+func (u UserClaim) Public() gosso.Claims {
+	var aud gosso.Audience
+	if u.Audience != "" {
+		aud = gosso.Audience{u.Audience}
+	}
 
-	// exp
-	if !(now < u.ExpiresAt) {
-		vErr.Inner = errors.New("token is expired")
-		vErr.Errors |= jwt.ValidationErrorExpired
+	return gosso.Claims{
+		ID:            u.ID,
+		Subject:       u.Subject,
+		Audience:      aud,
+		ExpiresAt:     u.ExpiresAt,
+		IssuedAt:      u.IssuedAt,
+		NotBefore:     u.NotBefore,
+		Issuer:        u.Issuer,
+		User:          u.User,
+		SecurityStamp: u.SecurityStamp,
+		Profile:       u.Profile,
 	}
+}
+
+func (u UserClaim) Valid() error {
+	return u.Public().Valid()
+}
 
-	// iat
-	if !(now >= u.IssuedAt) {
-		vErr.Inner = errors.New("token used before issued")
-		vErr.Errors |= jwt.ValidationErrorIssuedAt
+// NewUserClaim creates claim for user with registered claims filled.
+// Token is valid from now until now + timeout.
+func NewUserClaim(u User, audience string, timeout time.Duration) UserClaim {
+	now := time.Now()
+	return UserClaim{
+		ID:        uuid.New().String(),
+		Subject:   u.ID.String(),
+		Audience:  audience,
+		Issuer:    gosso.Issuer,
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(timeout).Unix(),
+		User:      u.Public(),
 	}
+}
+
+// CompactClaim is JWT payload of compact access token.
+// It carries either scoped permissions or digest of full permission set to keep token small.
+type CompactClaim struct {
+	ID               string                  `json:"jti"`
+	Subject          string                  `json:"sub"`
+	Audience         string                  `json:"aud,omitempty"`
+	ExpiresAt        int64                   `json:"exp"`
+	IssuedAt         int64                   `json:"iat"`
+	NotBefore        int64                   `json:"nbf"`
+	Issuer           string                  `json:"iss"`
+	Username         string                  `json:"name"`
+	Permissions      []permission.Permission `json:"prm,omitempty"`
+	PermissionDigest string                  `json:"pdg,omitempty"`
+
+	gosso.Profile
+}
 
-	// nbf
-	if !(now >= u.NotBefore) {
-		vErr.Inner = errors.New("token is not valid yet")
-		vErr.Errors |= jwt.ValidationErrorNotValidYet
+// NewCompactClaim creates compact claim for user.
+// If scoped, user permissions are embedded. Otherwise only digest of permissions is embedded.
+func NewCompactClaim(u User, audience string, timeout time.Duration, scoped bool) CompactClaim {
+	c := NewUserClaim(u, audience, timeout)
+
+	cc := CompactClaim{
+		ID:        c.ID,
+		Subject:   c.Subject,
+		Audience:  c.Audience,
+		ExpiresAt: c.ExpiresAt,
+		IssuedAt:  c.IssuedAt,
+		NotBefore: c.NotBefore,
+		Issuer:    c.Issuer,
+		Username:  u.Username,
 	}
 
-	if vErr.Errors == 0 {
-		return nil
+	if scoped {
+		cc.Permissions = u.Permissions
+	} else {
+		cc.PermissionDigest = gosso.PermissionDigest(u.Permissions)
 	}
 
-	return vErr
+	return cc
+}
+
+func (c CompactClaim) Valid() error {
+	return gosso.Claims{
+		ExpiresAt: c.ExpiresAt,
+		IssuedAt:  c.IssuedAt,
+		NotBefore: c.NotBefore,
+	}.Valid()
 }
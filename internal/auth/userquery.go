@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var (
+	ErrInvalidCursor = errors.New("datastore: invalid cursor")
+	ErrInvalidSort   = errors.New("datastore: invalid sort")
+)
+
+// UserSort is sort field of user query. Prefix "-" sorts in descending order.
+type UserSort string
+
+const (
+	SortByUsername     UserSort = "username"
+	SortByUsernameDesc UserSort = "-username"
+	SortByID           UserSort = "id"
+	SortByIDDesc       UserSort = "-id"
+)
+
+func ParseUserSort(s string) (UserSort, error) {
+	switch v := UserSort(s); v {
+	case "":
+		return SortByUsername, nil
+	case SortByUsername, SortByUsernameDesc, SortByID, SortByIDDesc:
+		return v, nil
+	}
+	return "", ErrInvalidSort
+}
+
+// UserQuery selects page of users
+type UserQuery struct {
+	// Prefix matches beginning of username, case sensitive
+	Prefix string
+	// Permission matches users whose own permissions grant it. Group permissions are not considered.
+	Permission *permission.Permission
+
+	Sort UserSort
+	// Cursor is NextCursor of previous page. Empty cursor selects first page.
+	Cursor string
+	// Limit defaults to DefaultPageSize and is capped at MaxPageSize
+	Limit int
+}
+
+type UserPage struct {
+	Users []User `json:"users"`
+	// Total counts every user matching query regardless of page
+	Total int `json:"total"`
+	// NextCursor is empty on last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type cursor struct {
+	Sort     UserSort  `json:"s"`
+	ID       uuid.UUID `json:"i"`
+	Username string    `json:"u,omitempty"`
+}
+
+func encodeCursor(s UserSort, u User) string {
+	c := cursor{Sort: s, ID: u.ID}
+	if s == SortByUsername || s == SortByUsernameDesc {
+		c.Username = u.Username
+	}
+
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := new(cursor)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// userPager collects page of users from records fed in any order.
+// Only Limit+1 users are kept, so data stores can stream records instead of loading all of them.
+type userPager struct {
+	query UserQuery
+	after *User
+	total int
+	page  []User
+}
+
+func newUserPager(q UserQuery) (*userPager, error) {
+	var err error
+	if q.Sort, err = ParseUserSort(string(q.Sort)); err != nil {
+		return nil, err
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+
+	p := &userPager{
+		query: q,
+		page:  make([]User, 0, q.Limit+1),
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.Sort != q.Sort {
+			return nil, ErrInvalidCursor
+		}
+
+		p.after = &User{ID: c.ID, Username: c.Username}
+	}
+
+	return p, nil
+}
+
+// less reports whether a comes before b in query order. ID breaks ties.
+func (p *userPager) less(a, b *User) bool {
+	ka, kb := "", ""
+	if p.query.Sort == SortByUsername || p.query.Sort == SortByUsernameDesc {
+		ka, kb = a.Username, b.Username
+	}
+
+	if ka == kb {
+		ka, kb = a.ID.String(), b.ID.String()
+	}
+
+	if strings.HasPrefix(string(p.query.Sort), "-") {
+		return ka > kb
+	}
+	return ka < kb
+}
+
+func (p *userPager) matches(u *User) bool {
+	if !strings.HasPrefix(u.Username, p.query.Prefix) {
+		return false
+	}
+
+	if p.query.Permission != nil && !p.query.Permission.HasPermission(u.Permissions) {
+		return false
+	}
+
+	return true
+}
+
+func (p *userPager) add(u User) {
+	if !p.matches(&u) {
+		return
+	}
+	p.total++
+
+	if p.after != nil && !p.less(p.after, &u) {
+		return
+	}
+
+	i := sort.Search(len(p.page), func(i int) bool {
+		return p.less(&u, &p.page[i])
+	})
+
+	if i > p.query.Limit {
+		return
+	}
+
+	p.page = append(p.page, User{})
+	copy(p.page[i+1:], p.page[i:])
+	p.page[i] = u
+
+	if len(p.page) > p.query.Limit+1 {
+		p.page = p.page[:p.query.Limit+1]
+	}
+}
+
+// ordered returns page of users data store fetched in query order after cursor, up to Limit+1 of them.
+// Total is counted by data store, since pager doesn't see users outside page.
+func (p *userPager) ordered(users []User, total int) *UserPage {
+	p.page, p.total = users, total
+	return p.result()
+}
+
+func (p *userPager) result() *UserPage {
+	res := &UserPage{
+		Users: p.page,
+		Total: p.total,
+	}
+
+	if len(p.page) > p.query.Limit {
+		res.Users = p.page[:p.query.Limit]
+		res.NextCursor = encodeCursor(p.query.Sort, res.Users[len(res.Users)-1])
+	}
+
+	return res
+}
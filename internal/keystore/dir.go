@@ -0,0 +1,145 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Key directory holds PEM encoded private keys named <kid>.pem,
+// and currentFile holding key id of current signing key.
+const (
+	currentFile = "current"
+	keyExt      = ".pem"
+)
+
+// Algorithms lists signing algorithms supported by GenerateKey
+var Algorithms = []string{"ES256", "ES384", "ES512", "EdDSA", "RS256"}
+
+// GenerateKey generates private key for signing algorithm
+func GenerateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, k, err := ed25519.GenerateKey(rand.Reader)
+		return k, err
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return nil, fmt.Errorf("keystore: unsupported algorithm: %s", alg)
+}
+
+// WritePrivateKey writes PEM encoded private key file readable only by owner
+func WritePrivateKey(path string, privateKey crypto.Signer) error {
+	p, err := MarshalPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, p, 0600)
+}
+
+// LoadDir loads every key in key directory.
+// Signing key must be chosen by current file, so ErrNoCurrentKey is returned if it's missing or names unknown key.
+func LoadDir(dir string) (*Store, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := ioutil.ReadFile(filepath.Join(dir, currentFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	currentID := strings.TrimSpace(string(current))
+
+	s := NewStore()
+	var cur *Key
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != keyExt {
+			continue
+		}
+
+		pk, err := LoadPrivateKey(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+
+		k, err := NewKey(pk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+
+		if k.ID == currentID {
+			cur = k
+			continue
+		}
+		s.keys = append(s.keys, k)
+	}
+
+	if cur == nil {
+		if len(s.keys) > 0 {
+			return nil, ErrNoCurrentKey
+		}
+		return s, nil
+	}
+
+	s.Rotate(cur)
+	return s, nil
+}
+
+// AddToDir writes private key to key directory and makes it current signing key
+func AddToDir(dir string, privateKey crypto.Signer) (*Key, error) {
+	k, err := NewKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if err := WritePrivateKey(filepath.Join(dir, k.ID+keyExt), privateKey); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, currentFile), []byte(k.ID+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// RemoveFromDir deletes retired key from key directory. Current signing key can't be removed.
+func RemoveFromDir(dir string, kid string) error {
+	if kid == "" || strings.ContainsAny(kid, `/\.`) {
+		return ErrKeyNotFound
+	}
+
+	current, err := ioutil.ReadFile(filepath.Join(dir, currentFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if strings.TrimSpace(string(current)) == kid {
+		return fmt.Errorf("keystore: %s is current signing key", kid)
+	}
+
+	err = os.Remove(filepath.Join(dir, kid+keyExt))
+	if os.IsNotExist(err) {
+		return ErrKeyNotFound
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := GenerateKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k1, err := AddToDir(dir, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := GenerateKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := AddToDir(dir, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur, err := s.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cur.ID != k2.ID || cur.Algorithm != "EdDSA" {
+		t.Errorf("Expected current key %s but got %s", k2.ID, cur.ID)
+	}
+
+	if len(s.Keys()) != 2 {
+		t.Errorf("Expected 2 keys but got %d", len(s.Keys()))
+	}
+
+	// Current key can't be removed
+	if err := RemoveFromDir(dir, k2.ID); err == nil {
+		t.Error("Expected error removing current key")
+	}
+
+	if err := RemoveFromDir(dir, "../current"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound but got %v", err)
+	}
+
+	if err := RemoveFromDir(dir, k1.ID); err != nil {
+		t.Error(err)
+	}
+
+	s, err = LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(k1.ID); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound but got %v", err)
+	}
+
+	// Current key isn't guessed when current file is missing or names unknown key
+	if err := ioutil.WriteFile(filepath.Join(dir, currentFile), []byte(k1.ID+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadDir(dir); err != ErrNoCurrentKey {
+		t.Errorf("Expected ErrNoCurrentKey but got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, currentFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadDir(dir); err != ErrNoCurrentKey {
+		t.Errorf("Expected ErrNoCurrentKey but got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+// Package keystore holds token signing keys.
+// Signing algorithm is chosen per key from its type: ECDSA keys use ES256/ES384/ES512,
+// Ed25519 keys use EdDSA and RSA keys use RS256.
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"sync"
+
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("keystore: unsupported key type")
+	ErrKeyNotFound    = errors.New("keystore: key not found")
+	ErrNoKey          = errors.New("keystore: no signing key")
+	// ErrNoCurrentKey is returned by LoadDir when keys are present but current file doesn't name one of them
+	ErrNoCurrentKey = errors.New("keystore: current file doesn't name key in key directory")
+)
+
+// Key is signing key. Signer can be local private key or remote signer, so key material doesn't have to be in process.
+type Key struct {
+	ID        string
+	Algorithm string
+	Signer    crypto.Signer
+}
+
+// NewKey wraps signer, deriving key id and signing algorithm from its public key
+func NewKey(signer crypto.Signer) (*Key, error) {
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	jwk, err := gosso.NewJWK(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        jwk.KeyID,
+		Algorithm: jwk.Algorithm,
+		Signer:    signer,
+	}, nil
+}
+
+func (k Key) Public() crypto.PublicKey {
+	return k.Signer.Public()
+}
+
+func (k Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func (k Key) JWK() (gosso.JWK, error) {
+	return gosso.NewJWK(k.Public())
+}
+
+// PublicKeyPEM returns PEM encoded PKIX public key
+func (k Key) PublicKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}), nil
+}
+
+// Sign signs claims through crypto.Signer and sets kid header
+func (k Key) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.SigningMethod(), claims)
+	token.Header["kid"] = k.ID
+
+	ss, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := k.sign([]byte(ss))
+	if err != nil {
+		return "", err
+	}
+
+	return ss + "." + jwt.EncodeSegment(sig), nil
+}
+
+// sign creates JWS signature of message
+func (k Key) sign(message []byte) ([]byte, error) {
+	switch puk := k.Public().(type) {
+	case ed25519.PublicKey:
+		return k.Signer.Sign(rand.Reader, message, crypto.Hash(0))
+	case *rsa.PublicKey:
+		h := crypto.SHA256.New()
+		h.Write(message)
+		return k.Signer.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	case *ecdsa.PublicKey:
+		hash := crypto.SHA256
+		switch puk.Curve.Params().BitSize {
+		case 384:
+			hash = crypto.SHA384
+		case 521:
+			hash = crypto.SHA512
+		}
+
+		h := hash.New()
+		h.Write(message)
+
+		der, err := k.Signer.Sign(rand.Reader, h.Sum(nil), hash)
+		if err != nil {
+			return nil, err
+		}
+
+		// crypto.Signer returns ASN.1 encoded signature but JWS uses fixed size R || S
+		var rs struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(der, &rs); err != nil {
+			return nil, err
+		}
+
+		size := (puk.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		rs.R.FillBytes(sig[:size])
+		rs.S.FillBytes(sig[size:])
+		return sig, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// Store holds signing keys. First key is used for signing and every key is published for verification.
+type Store struct {
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+func NewStore(keys ...*Key) *Store {
+	return &Store{
+		keys: keys,
+	}
+}
+
+// New creates store holding single signing key
+func New(signer crypto.Signer) (*Store, error) {
+	k, err := NewKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(k), nil
+}
+
+// Current returns signing key
+func (s *Store) Current() (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return nil, ErrNoKey
+	}
+	return s.keys[0], nil
+}
+
+// Keys returns every key including retired verification keys
+func (s *Store) Keys() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*Key(nil), s.keys...)
+}
+
+func (s *Store) Get(kid string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, k := range s.keys {
+		if k.ID == kid {
+			return k, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Rotate makes key current signing key. Previous keys are kept for verification.
+func (s *Store) Rotate(key *Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []*Key{key}
+	for _, k := range s.keys {
+		if k.ID != key.ID {
+			keys = append(keys, k)
+		}
+	}
+	s.keys = keys
+}
+
+// Remove retires verification key
+func (s *Store) Remove(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, k := range s.keys {
+		if k.ID == kid {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+// Sign signs claims with current key
+func (s *Store) Sign(claims jwt.Claims) (string, error) {
+	k, err := s.Current()
+	if err != nil {
+		return "", err
+	}
+	return k.Sign(claims)
+}
+
+// PublicKey implements gosso.KeySource. Empty kid resolves current key for tokens issued without kid.
+func (s *Store) PublicKey(kid string) (crypto.PublicKey, error) {
+	if kid == "" {
+		k, err := s.Current()
+		if err != nil {
+			return nil, err
+		}
+		return k.Public(), nil
+	}
+
+	k, err := s.Get(kid)
+	if err != nil {
+		return nil, err
+	}
+	return k.Public(), nil
+}
+
+// JWKSet returns every key as JWK Set
+func (s *Store) JWKSet() (gosso.JWKSet, error) {
+	set := gosso.JWKSet{Keys: make([]gosso.JWK, 0)}
+	for _, k := range s.Keys() {
+		j, err := k.JWK()
+		if err != nil {
+			return gosso.JWKSet{}, err
+		}
+		set.Keys = append(set.Keys, j)
+	}
+	return set, nil
+}
+
+// ParsePrivateKey parses PEM encoded PKCS#8, SEC 1 EC or PKCS#1 RSA private key
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("keystore: invalid PEM")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+	return s, nil
+}
+
+// LoadPrivateKey reads PEM encoded private key file. Loaded key is local signer.
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(b)
+}
+
+// MarshalPrivateKey encodes private key as PEM encoded PKCS#8
+func MarshalPrivateKey(privateKey crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
@@ -0,0 +1,159 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/google/uuid"
+)
+
+func generateKeys(t *testing.T) map[string]crypto.Signer {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{
+		"ES256": ec,
+		"EdDSA": ed,
+		"RS256": rs,
+	}
+}
+
+func TestKey_Sign(t *testing.T) {
+	for alg, pk := range generateKeys(t) {
+		s, err := New(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := s.Current()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k.Algorithm != alg {
+			t.Errorf("Expected %s but got %s", alg, k.Algorithm)
+		}
+
+		tok, err := s.Sign(auth.NewUserClaim(auth.User{ID: uuid.New(), Username: "hello"}, "", time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, ok, err := gosso.ValidateToken(tok, s)
+		if !ok || err != nil {
+			t.Errorf("%s: Expected valid token but got %v", alg, err)
+			continue
+		}
+
+		if u.Username != "hello" {
+			t.Errorf("%s: Expected hello but got %s", alg, u.Username)
+		}
+
+		// Verify using published JWK
+		set, err := s.JWKSet()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if set.Keys[0].Algorithm != alg {
+			t.Errorf("Expected published alg %s but got %s", alg, set.Keys[0].Algorithm)
+		}
+
+		puk, err := set.Keys[0].PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, err := gosso.ValidateToken(tok, puk); !ok {
+			t.Errorf("%s: Expected valid token using JWK but got %v", alg, err)
+		}
+	}
+}
+
+func TestStore_Rotate(t *testing.T) {
+	keys := generateKeys(t)
+
+	k1, err := NewKey(keys["ES256"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := NewKey(keys["EdDSA"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(k1)
+
+	old, err := s.Sign(auth.NewUserClaim(auth.User{ID: uuid.New()}, "", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Rotate(k2)
+
+	if c, _ := s.Current(); c.ID != k2.ID {
+		t.Error("Expected rotated key to be current")
+	}
+
+	if _, ok, err := gosso.ValidateToken(old, s); !ok {
+		t.Errorf("Expected token signed with previous key to be valid but got %v", err)
+	}
+
+	err = s.Remove(k1.ID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, ok, _ := gosso.ValidateToken(old, s); ok {
+		t.Error("Expected token signed with removed key to be rejected")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for alg, pk := range generateKeys(t) {
+		p, err := MarshalPrivateKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := ParsePrivateKey(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k1, err := NewKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k2, err := NewKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if k1.ID != k2.ID {
+			t.Errorf("%s: Expected same key id", alg)
+		}
+	}
+}
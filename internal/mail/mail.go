@@ -0,0 +1,157 @@
+// Package mail sends notification mails such as password reset and email verification links.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is plain text mail
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Send returns after message is accepted for delivery.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// writeTo writes message in RFC 5322 format with CRLF line endings
+func (m Message) writeTo(w io.Writer, from string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// validate rejects header injection through recipient and subject
+func (m Message) validate() error {
+	if m.To == "" {
+		return fmt.Errorf("mail: empty recipient")
+	}
+
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("mail: line break in header")
+	}
+	return nil
+}
+
+// SMTPMailer sends messages through SMTP server.
+// STARTTLS is used if server supports it, and auth is used only if username is set.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates mailer sending to SMTP server at addr (host:port) as from
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: addr,
+		from: from,
+	}
+
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+func (s *SMTPMailer) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := m.writeTo(&b, s.from); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, b.Bytes())
+}
+
+// FileMailer writes each message into its own .eml file in directory
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{
+		dir:  dir,
+		from: from,
+	}, nil
+}
+
+func (f *FileMailer) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405Z"), uuid.New())
+	file, err := os.OpenFile(filepath.Join(f.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := m.writeTo(file, f.from); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LogMailer writes messages to logger instead of sending them. Links in messages are logged as is,
+// so it should be used only in development.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer creates mailer logging to logger, or standard logger if nil
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	if logger == nil {
+		logger = log.New(log.Writer(), "", log.LstdFlags)
+	}
+
+	return &LogMailer{
+		logger: logger,
+	}
+}
+
+func (l *LogMailer) Send(m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+
+	l.logger.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
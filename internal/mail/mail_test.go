@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+)
+
+// serveSMTP accepts one SMTP session and sends received message data to data channel
+func serveSMTP(t *testing.T, l net.Listener, data chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		t.Error(err)
+		close(data)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			close(data)
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 go ahead")
+
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			data <- b.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			close(data)
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	data := make(chan string, 1)
+	go serveSMTP(t, l, data)
+
+	m := NewSMTPMailer(l.Addr().String(), "gosso@example.com", "", "")
+	err = m.Send(Message{To: "hello@example.com", Subject: "Reset password", Body: "line 1\nline 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-data
+	for _, s := range []string{"From: gosso@example.com\r\n", "To: hello@example.com\r\n", "Subject: Reset password\r\n", "\r\n\r\nline 1\r\nline 2"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("Expected %q in message %q", s, msg)
+		}
+	}
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewFileMailer(dir, "gosso@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(Message{To: "hello@example.com", Subject: "Hello", Body: "world"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files but got %d", len(files))
+	}
+}
+
+func TestLogMailer_Send(t *testing.T) {
+	var b bytes.Buffer
+	m := NewLogMailer(log.New(&b, "", 0))
+
+	if err := m.Send(Message{To: "hello@example.com", Subject: "Hello", Body: "world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), "hello@example.com") || !strings.Contains(b.String(), "world") {
+		t.Errorf("Unexpected log %q", b.String())
+	}
+
+	// Header injection is rejected
+	if err := m.Send(Message{To: "hello@example.com\r\nBcc: other@example.com", Subject: "Hello"}); err == nil {
+		t.Error("Expected error on line break in recipient")
+	}
+}
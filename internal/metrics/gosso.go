@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+)
+
+// Default holds every metric of GoSSO
+var Default = NewRegistry()
+
+var (
+	SignInSuccesses = NewCounterVec("gosso_signin_success_total",
+		"Successful sign ins")
+	// SignInFailures is partitioned by error code reported to sign in page
+	SignInFailures = NewCounterVec("gosso_signin_failures_total",
+		"Failed sign ins by reason", "reason")
+	// TokensIssued is partitioned by access, refresh and one-time token purposes
+	TokensIssued = NewCounterVec("gosso_tokens_issued_total",
+		"Tokens issued by type", "type")
+	RefreshRejections = NewCounterVec("gosso_refresh_rejections_total",
+		"Rejected access token requests of refresh tokens by reason", "reason")
+	UserOperations = NewCounterVec("gosso_user_operations_total",
+		"Successful user administration operations", "operation")
+
+	// RequestDuration is partitioned by route path template, so path parameters don't create new series
+	RequestDuration = NewHistogramVec("gosso_http_request_duration_seconds",
+		"Latency of HTTP requests", DefaultBuckets, "method", "route", "status")
+	// PasswordHashDuration buckets cover cost of scrypt parameters used by auth package
+	PasswordHashDuration = NewHistogramVec("gosso_password_hash_duration_seconds",
+		"Duration of password hashing", []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5}, "operation")
+)
+
+func init() {
+	err := Default.Register(
+		SignInSuccesses,
+		SignInFailures,
+		TokensIssued,
+		RefreshRejections,
+		UserOperations,
+		RequestDuration,
+		PasswordHashDuration,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	auth.PasswordHashObserver = func(operation string, d time.Duration) {
+		PasswordHashDuration.Observe(d.Seconds(), operation)
+	}
+}
@@ -0,0 +1,295 @@
+// Package metrics collects counters and histograms of GoSSO and writes them in Prometheus text exposition format.
+// Only the subset of Prometheus data model used by GoSSO is implemented: counters and histograms with labels.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is content type of text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector is metric family written by Registry
+type Collector interface {
+	Name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds collectors exposed together
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]Collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register adds collectors to registry. Names must be unique.
+func (r *Registry) Register(cs ...Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range cs {
+		if _, ok := r.collectors[c.Name()]; ok {
+			return fmt.Errorf("metrics: %s is already registered", c.Name())
+		}
+		r.collectors[c.Name()] = c
+	}
+	return nil
+}
+
+// WriteTo writes every collector sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for n := range r.collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	cs := make([]Collector, len(names))
+	for i, n := range names {
+		cs[i] = r.collectors[n]
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	b := bufio.NewWriter(cw)
+	for _, c := range cs {
+		c.write(b)
+	}
+	err := b.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family holds series of metric keyed by label values
+type family struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]interface{}
+}
+
+// get returns series of label values, creating it by create if missing
+func (f *family) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values but got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = create()
+		f.series[key] = s
+	}
+	return s
+}
+
+// sortedKeys returns series keys in order, so output is stable between scrapes
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *family) header(w *bufio.Writer, typ string) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(f.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, help, f.name, typ)
+}
+
+// labelPairs formats label values of series key, with extra pair appended if name is not empty
+func (f *family) labelPairs(key string, name, value string) string {
+	var values []string
+	if len(f.labels) > 0 {
+		values = strings.Split(key, "\xff")
+	}
+
+	pairs := make([]string, 0, len(f.labels)+1)
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	if name != "" {
+		pairs = append(pairs, name+`="`+escapeLabel(value)+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelReplacer.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is counter partitioned by labels
+type CounterVec struct {
+	family
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family{name: name, help: help, labels: labels, series: make(map[string]interface{})}}
+}
+
+func (c *CounterVec) Name() string {
+	return c.name
+}
+
+// Inc increments counter of label values by one
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments counter of label values by n
+func (c *CounterVec) Add(n uint64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.get(values, func() interface{} { return new(uint64) }).(*uint64)
+	*v += n
+}
+
+// Value returns current count of label values
+func (c *CounterVec) Value(values ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.series[strings.Join(values, "\xff")]
+	if !ok {
+		return 0
+	}
+	return *v.(*uint64)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w, "counter")
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, c.labelPairs(k, "", ""), *c.series[k].(*uint64))
+	}
+}
+
+// DefaultBuckets are upper bounds of request latency histogram in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec is histogram partitioned by labels
+type HistogramVec struct {
+	family
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates histogram with bucket upper bounds. Bounds are sorted, and +Inf bucket is implicit.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+
+	return &HistogramVec{
+		family:  family{name: name, help: help, labels: labels, series: make(map[string]interface{})},
+		buckets: b,
+	}
+}
+
+func (h *HistogramVec) Name() string {
+	return h.name
+}
+
+// Observe adds value to histogram of label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values, func() interface{} {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	}).(*histogram)
+
+	// Buckets are cumulative when written
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Since observes seconds elapsed from start
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns number of observations of label values
+func (h *HistogramVec) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[strings.Join(values, "\xff")]
+	if !ok {
+		return 0
+	}
+	return s.(*histogram).count
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w, "histogram")
+	for _, k := range h.sortedKeys() {
+		s := h.series[k].(*histogram)
+
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", formatFloat(b)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(k, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(k, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(k, "", ""), s.count)
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := NewCounterVec("test_total", "Test counter\nwith \\ escapes", "reason")
+	h := NewHistogramVec("test_seconds", "Test histogram", []float64{1, .5}, "op")
+	plain := NewCounterVec("test_plain_total", "Counter without labels")
+
+	if err := r.Register(c, h, plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Register(NewCounterVec("test_total", "")); err == nil {
+		t.Error("Expected duplicated name to be rejected")
+	}
+
+	c.Inc(`quo"te`)
+	c.Add(2, "b")
+	c.Inc("b")
+	plain.Inc()
+
+	h.Observe(.25, "x")
+	h.Observe(.5, "x")
+	h.Observe(.75, "x")
+	h.Observe(3, "x")
+
+	if c.Value("b") != 3 || h.Count("x") != 4 || h.Count("y") != 0 {
+		t.Errorf("Unexpected values %d %d", c.Value("b"), h.Count("x"))
+	}
+
+	b := new(bytes.Buffer)
+	if _, err := r.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_plain_total Counter without labels
+# TYPE test_plain_total counter
+test_plain_total 1
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{op="x",le="0.5"} 2
+test_seconds_bucket{op="x",le="1"} 3
+test_seconds_bucket{op="x",le="+Inf"} 4
+test_seconds_sum{op="x"} 4.5
+test_seconds_count{op="x"} 4
+# HELP test_total Test counter\nwith \\ escapes
+# TYPE test_total counter
+test_total{reason="b"} 3
+test_total{reason="quo\"te"} 1
+`
+
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
+
+func TestCounterVec_LabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on missing label value")
+		}
+	}()
+
+	NewCounterVec("test_total", "", "a", "b").Inc("a")
+}
+
+func TestPasswordHashDuration(t *testing.T) {
+	before := PasswordHashDuration.Count("hash")
+
+	p, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Validate("password")
+
+	if n := PasswordHashDuration.Count("hash"); n != before+1 {
+		t.Errorf("Expected %d observations but got %d", before+1, n)
+	}
+
+	if PasswordHashDuration.Count("validate") == 0 {
+		t.Error("Expected validation to be observed")
+	}
+}
+
+func TestMetrics_WebService(t *testing.T) {
+	ws := new(restful.WebService)
+	ws.Path("/test").Filter(Instrument)
+	ws.Route(ws.GET("/{id}").To(func(_ *restful.Request, res *restful.Response) {
+		res.WriteHeader(http.StatusTeapot)
+	}))
+
+	c := restful.NewContainer()
+	c.Add(ws)
+	c.Add(New(Default).WebService())
+
+	before := RequestDuration.Count("GET", "/test/{id}", "418")
+
+	// Scenario 00 : Latency is recorded by route template and status
+	{
+		res := httptest.NewRecorder()
+		c.ServeHTTP(res, httptest.NewRequest("GET", "/test/1", nil))
+		c.ServeHTTP(res, httptest.NewRequest("GET", "/test/2", nil))
+
+		if n := RequestDuration.Count("GET", "/test/{id}", "418"); n != before+2 {
+			t.Errorf("Expected %d observations but got %d", before+2, n)
+		}
+	}
+
+	// Scenario 01 : Metrics are served in text format
+	{
+		req := httptest.NewRequest("GET", Path, nil)
+		req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK || res.Header().Get("Content-Type") != ContentType {
+			t.Fatalf("Expected metrics but got %d %s", res.Code, res.Header().Get("Content-Type"))
+		}
+
+		body := res.Body.String()
+		for _, s := range []string{
+			"# TYPE gosso_signin_failures_total counter",
+			"# TYPE gosso_password_hash_duration_seconds histogram",
+			`gosso_http_request_duration_seconds_count{method="GET",route="/test/{id}",status="418"}`,
+		} {
+			if !strings.Contains(body, s) {
+				t.Errorf("Expected %q in\n%s", s, body)
+			}
+		}
+	}
+}
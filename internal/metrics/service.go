@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+)
+
+// Path is scrape path of Prometheus
+const Path = "/metrics"
+
+// Instrument is filter observing latency of requests to route
+func Instrument(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
+	chain.ProcessFilter(req, res)
+	RequestDuration.Since(start, req.Request.Method, req.SelectedRoutePath(), strconv.Itoa(res.StatusCode()))
+}
+
+type Metrics struct {
+	registry *Registry
+}
+
+// New creates service exposing registry. Endpoint isn't authenticated like other Prometheus targets,
+// so it should be reachable only from internal network.
+func New(registry *Registry) Metrics {
+	return Metrics{registry: registry}
+}
+
+func (m Metrics) metrics(_ *restful.Request, res *restful.Response) {
+	res.Header().Set("Content-Type", ContentType)
+	if _, err := m.registry.WriteTo(res); err != nil {
+		log.Printf("metrics: writing metrics failed: %v", err)
+	}
+}
+
+func (m Metrics) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path(Path).
+		Produces("text/plain")
+
+	ws.Route(ws.GET("").To(m.metrics).
+		Doc("get metrics in Prometheus text exposition format"))
+
+	return ws
+}
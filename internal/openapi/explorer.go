@@ -0,0 +1,202 @@
+package openapi
+
+// explorerPage renders OpenAPI document served at DocumentPath and sends requests to documented routes.
+// Page is self-contained, so it works without network access to CDNs.
+const explorerPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>API Explorer</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0 auto; max-width: 960px; padding: 1rem; color: #222; }
+header { display: flex; flex-wrap: wrap; align-items: baseline; gap: 1rem; }
+header input { flex: 1; min-width: 16rem; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: .25rem; margin-top: 2rem; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: .5rem 0; }
+summary { cursor: pointer; padding: .5rem; }
+details > div { padding: 0 .75rem .75rem; }
+.method { display: inline-block; width: 4.5rem; font-weight: bold; text-transform: uppercase; }
+.get { color: #0a6; } .post { color: #06c; } .put { color: #a60; } .delete { color: #c22; }
+.path { font-family: monospace; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border: 1px solid #eee; padding: .25rem .5rem; text-align: left; vertical-align: top; }
+pre, textarea { background: #f6f6f6; font-family: monospace; font-size: .85rem; overflow: auto; padding: .5rem; }
+textarea { box-sizing: border-box; min-height: 6rem; width: 100%; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<header>
+<h1 id="title">API Explorer</h1>
+<input id="token" type="password" placeholder="Bearer access token for authenticated routes">
+</header>
+<p id="description" class="muted"></p>
+<main id="operations"></main>
+<h2>Models</h2>
+<div id="models"></div>
+<script>
+"use strict";
+
+var doc;
+
+function el(tag, attrs, children) {
+  var e = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (k) { e.setAttribute(k, attrs[k]); });
+  (children || []).forEach(function (c) {
+    e.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+  });
+  return e;
+}
+
+function resolve(schema) {
+  if (schema && schema.$ref) {
+    return doc.components.schemas[schema.$ref.split("/").pop()];
+  }
+  return schema || {};
+}
+
+// example builds sample value of schema for request body
+function example(schema, depth) {
+  var s = resolve(schema);
+  if ((depth || 0) > 4) { return null; }
+  switch (s.type) {
+    case "object":
+      var o = {};
+      Object.keys(s.properties || {}).forEach(function (k) { o[k] = example(s.properties[k], (depth || 0) + 1); });
+      return o;
+    case "array": return [example(s.items, (depth || 0) + 1)];
+    case "integer": case "number": return 0;
+    case "boolean": return false;
+    case "string": return s.format === "uuid" ? "00000000-0000-0000-0000-000000000000" : (s.enum ? s.enum[0] : "");
+  }
+  return null;
+}
+
+function schemaText(schema) {
+  return JSON.stringify(schema.$ref ? { $ref: schema.$ref.split("/").pop() } : schema, null, 2);
+}
+
+function contentSchema(content) {
+  var types = Object.keys(content || {});
+  return types.length ? { type: types[0], schema: content[types[0]].schema } : null;
+}
+
+function send(method, path, op, inputs, body, out) {
+  var query = new URLSearchParams();
+  var headers = {};
+  (op.parameters || []).forEach(function (p) {
+    var v = inputs[p.in + ":" + p.name].value;
+    if (v === "") { return; }
+    if (p.in === "path") { path = path.replace("{" + p.name + "}", encodeURIComponent(v)); }
+    if (p.in === "query") { v.split(",").forEach(function (x) { query.append(p.name, x.trim()); }); }
+    if (p.in === "header") { headers[p.name] = v; }
+  });
+
+  var token = document.getElementById("token").value;
+  if (token) { headers.Authorization = "Bearer " + token; }
+
+  var init = { method: method.toUpperCase(), headers: headers, credentials: "same-origin" };
+  var req = contentSchema(op.requestBody && op.requestBody.content);
+  if (body && req) {
+    headers["Content-Type"] = req.type;
+    init.body = req.type === "application/json" ? body.value : new URLSearchParams(JSON.parse(body.value)).toString();
+  }
+
+  var url = path + (query.toString() ? "?" + query.toString() : "");
+  out.textContent = init.method + " " + url + "\n...";
+  fetch(url, init).then(function (res) {
+    return res.text().then(function (text) {
+      try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (e) {}
+      var id = res.headers.get("X-Request-ID");
+      out.textContent = res.status + " " + res.statusText + (id ? "  (request " + id + ")" : "") + "\n\n" + text;
+    });
+  }).catch(function (e) { out.textContent = String(e); });
+}
+
+function operation(path, method, op) {
+  var div = el("div");
+  if (op.description) { div.appendChild(el("p", {}, [op.description])); }
+
+  var inputs = {};
+  if ((op.parameters || []).length) {
+    var rows = op.parameters.map(function (p) {
+      var input = el("input", { placeholder: p.schema && p.schema.default || "" });
+      inputs[p.in + ":" + p.name] = input;
+      return el("tr", {}, [
+        el("td", {}, [p.name + (p.required ? " *" : "")]),
+        el("td", { "class": "muted" }, [p.in]),
+        el("td", {}, [p.description || ""]),
+        el("td", {}, [input])
+      ]);
+    });
+    div.appendChild(el("table", {}, [el("tr", {}, [el("th", {}, ["Parameter"]), el("th", {}, ["In"]), el("th", {}, ["Description"]), el("th", {}, ["Value"])])].concat(rows)));
+  }
+
+  var body = null;
+  var req = contentSchema(op.requestBody && op.requestBody.content);
+  if (req) {
+    div.appendChild(el("h4", {}, ["Request body (" + req.type + ")"]));
+    body = el("textarea", {}, [JSON.stringify(example(req.schema), null, 2)]);
+    div.appendChild(body);
+  }
+
+  div.appendChild(el("h4", {}, ["Responses"]));
+  Object.keys(op.responses).sort().forEach(function (code) {
+    var r = op.responses[code];
+    var res = contentSchema(r.content);
+    div.appendChild(el("p", {}, [el("b", {}, [code]), " " + r.description]));
+    if (res) { div.appendChild(el("pre", {}, [schemaText(res.schema)])); }
+  });
+
+  var out = el("pre", { "class": "muted" });
+  var button = el("button", {}, ["Send request"]);
+  button.addEventListener("click", function () { send(method, path, op, inputs, body, out); });
+  div.appendChild(button);
+  div.appendChild(out);
+
+  return el("details", {}, [
+    el("summary", {}, [el("span", { "class": "method " + method }, [method]), el("span", { "class": "path" }, [path]), " ", el("span", { "class": "muted" }, [op.summary || ""])]),
+    div
+  ]);
+}
+
+function render() {
+  document.title = doc.info.title + " API Explorer";
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  document.getElementById("description").textContent = doc.info.description || "";
+
+  var sections = {};
+  var main = document.getElementById("operations");
+  (doc.tags || []).forEach(function (t) {
+    sections[t.name] = el("section", {}, [el("h2", {}, [t.name]), el("p", { "class": "muted" }, [t.description || ""])]);
+    main.appendChild(sections[t.name]);
+  });
+
+  Object.keys(doc.paths).sort().forEach(function (path) {
+    Object.keys(doc.paths[path]).forEach(function (method) {
+      var op = doc.paths[path][method];
+      sections[op.tags[0]].appendChild(operation(path, method, op));
+    });
+  });
+
+  var models = document.getElementById("models");
+  Object.keys(doc.components.schemas || {}).sort().forEach(function (name) {
+    models.appendChild(el("details", {}, [el("summary", {}, [name]), el("div", {}, [el("pre", {}, [schemaText(doc.components.schemas[name])])])]));
+  });
+}
+
+var token = document.getElementById("token");
+token.value = sessionStorage.getItem("token") || "";
+token.addEventListener("change", function () { sessionStorage.setItem("token", token.value); });
+
+fetch("openapi.json").then(function (res) { return res.json(); }).then(function (d) {
+  doc = d;
+  render();
+}).catch(function (e) {
+  document.getElementById("description").textContent = "Loading OpenAPI document failed: " + e;
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,350 @@
+// Package openapi publishes OpenAPI 3.0 document generated from documentation of go-restful routes,
+// and serves API explorer page rendering it.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/emicklei/go-restful/v3"
+)
+
+const (
+	// Version is OpenAPI version of generated document
+	Version = "3.0.3"
+
+	DocumentPath = "/openapi.json"
+	ExplorerPath = "/api-explorer"
+)
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower case HTTP method to operation
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+// SecurityRequirement maps security scheme name to required scopes
+type SecurityRequirement map[string][]string
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// securitySchemes are schemes routes can name with SecurityKey
+var securitySchemes = map[string]*SecurityScheme{
+	BearerAuth: {
+		Type:         "http",
+		Description:  "Access token issued by /token/refresh. Refresh tokens are rejected.",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+	},
+}
+
+// Build generates document of every route registered in container
+func Build(c *restful.Container, info Info) *Document {
+	d := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+	}
+
+	s := newSchemas()
+	operationIDs := make(map[string]bool)
+
+	for _, ws := range c.RegisteredWebServices() {
+		tag := strings.Trim(ws.RootPath(), "/")
+		if tag == "" {
+			tag = "root"
+		}
+		tagged := false
+
+		for _, r := range ws.Routes() {
+			if r.Metadata[hiddenKey] == true {
+				continue
+			}
+
+			if !tagged {
+				d.Tags = append(d.Tags, Tag{Name: tag, Description: ws.Documentation()})
+				tagged = true
+			}
+
+			path, pathParams := normalizePath(r.Path)
+			params := append(append([]*restful.Parameter{}, ws.PathParameters()...), r.ParameterDocs...)
+			op := s.operation(r, params, pathParams)
+			op.Tags = []string{tag}
+
+			if scheme, ok := r.Metadata[SecurityKey].(string); ok {
+				op.Security = []SecurityRequirement{{scheme: {}}}
+				if securitySchemes[scheme] != nil {
+					if d.Components.SecuritySchemes == nil {
+						d.Components.SecuritySchemes = make(map[string]*SecurityScheme)
+					}
+					d.Components.SecuritySchemes[scheme] = securitySchemes[scheme]
+				}
+			}
+
+			op.OperationID = r.Operation
+			if operationIDs[op.OperationID] {
+				op.OperationID = tag + "_" + r.Operation
+			}
+			operationIDs[op.OperationID] = true
+
+			if d.Paths[path] == nil {
+				d.Paths[path] = make(PathItem)
+			}
+			d.Paths[path][strings.ToLower(r.Method)] = op
+		}
+	}
+
+	d.Components.Schemas = s.components
+	return d
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// normalizePath strips regular expressions of go-restful path parameters and returns parameter names
+func normalizePath(p string) (string, []string) {
+	var names []string
+	p = pathParamPattern.ReplaceAllStringFunc(p, func(m string) string {
+		name := pathParamPattern.FindStringSubmatch(m)[1]
+		names = append(names, name)
+		return "{" + name + "}"
+	})
+	return p, names
+}
+
+func (s *schemas) operation(r restful.Route, params []*restful.Parameter, pathParams []string) *Operation {
+	op := &Operation{
+		Summary:     r.Doc,
+		Description: r.Notes,
+		Responses:   make(map[string]Response),
+		Deprecated:  r.Deprecated,
+	}
+
+	documented := make(map[string]bool)
+	var form *Schema
+
+	for _, p := range params {
+		data := p.Data()
+		switch data.Kind {
+		case restful.FormParameterKind:
+			if form == nil {
+				form = &Schema{Type: "object", Properties: make(map[string]*Schema)}
+			}
+			ps := parameterSchema(data)
+			ps.Description = data.Description
+			form.Properties[data.Name] = ps
+			if data.Required {
+				form.Required = append(form.Required, data.Name)
+			}
+			continue
+		case restful.BodyParameterKind:
+			continue
+		}
+
+		in := map[int]string{
+			restful.PathParameterKind:   "path",
+			restful.QueryParameterKind:  "query",
+			restful.HeaderParameterKind: "header",
+		}[data.Kind]
+
+		documented[in+":"+data.Name] = true
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        data.Name,
+			In:          in,
+			Description: parameterDescription(data),
+			Required:    data.Required || in == "path",
+			Schema:      parameterSchema(data),
+		})
+	}
+
+	// Path parameters are required by OpenAPI even if route doesn't document them
+	for _, name := range pathParams {
+		if !documented["path:"+name] {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+
+	consumes := r.Consumes
+	if len(consumes) == 0 {
+		consumes = []string{restful.MIME_JSON}
+	}
+
+	switch {
+	case form != nil:
+		op.RequestBody = &RequestBody{Required: true, Content: make(map[string]MediaType)}
+		for _, mime := range consumes {
+			op.RequestBody.Content[mime] = MediaType{Schema: form}
+		}
+	case r.ReadSample != nil:
+		op.RequestBody = &RequestBody{Required: true, Content: make(map[string]MediaType)}
+		schema := s.schema(reflect.TypeOf(r.ReadSample))
+		for _, mime := range consumes {
+			op.RequestBody.Content[mime] = MediaType{Schema: schema}
+		}
+	}
+
+	jsonResponse := false
+	for _, mime := range r.Produces {
+		if mime == restful.MIME_JSON {
+			jsonResponse = true
+		}
+	}
+
+	content := func(model interface{}) map[string]MediaType {
+		if model == nil || len(r.Produces) == 0 {
+			return nil
+		}
+		schema := s.schema(reflect.TypeOf(model))
+		c := make(map[string]MediaType)
+		for _, mime := range r.Produces {
+			c[mime] = MediaType{Schema: schema}
+		}
+		return c
+	}
+
+	success := false
+	for code, e := range r.ResponseErrors {
+		resp := Response{Description: e.Message, Content: content(e.Model)}
+		if resp.Description == "" {
+			resp.Description = http.StatusText(code)
+		}
+
+		if code >= http.StatusBadRequest && e.Model == nil && jsonResponse {
+			resp.Content = s.errorContent()
+		}
+
+		if code >= 200 && code < 300 {
+			success = true
+		}
+		op.Responses[strconv.Itoa(code)] = resp
+	}
+
+	if !success {
+		op.Responses[strconv.Itoa(http.StatusOK)] = Response{
+			Description: http.StatusText(http.StatusOK),
+			Content:     content(r.WriteSample),
+		}
+	}
+
+	// Every JSON service writes errors as apierror.Response
+	if jsonResponse {
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     s.errorContent(),
+		}
+	}
+
+	return op
+}
+
+func (s *schemas) errorContent() map[string]MediaType {
+	return map[string]MediaType{
+		restful.MIME_JSON: {Schema: s.schema(reflect.TypeOf(apierror.Response{}))},
+	}
+}
+
+func parameterDescription(data restful.ParameterData) string {
+	if len(data.AllowableValues) == 0 {
+		return data.Description
+	}
+
+	values := make([]string, 0, len(data.AllowableValues))
+	for v, doc := range data.AllowableValues {
+		values = append(values, "`"+v+"`: "+doc)
+	}
+	sort.Strings(values)
+
+	return data.Description + "\n\n" + strings.Join(values, "\n\n")
+}
+
+func parameterSchema(data restful.ParameterData) *Schema {
+	t := data.DataType
+	if t == "" {
+		t = "string"
+	}
+
+	ps := &Schema{Type: t, Format: data.DataFormat}
+	if data.DefaultValue != "" {
+		ps.Default = data.DefaultValue
+	}
+
+	for v := range data.AllowableValues {
+		ps.Enum = append(ps.Enum, v)
+	}
+	sort.Strings(ps.Enum)
+
+	if data.AllowMultiple {
+		return &Schema{Type: "array", Items: ps}
+	}
+	return ps
+}
@@ -0,0 +1,161 @@
+package openapi_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/api/group"
+	"github.com/dfkdream/GoSSO/internal/api/token"
+	"github.com/dfkdream/GoSSO/internal/api/user"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/openapi"
+	"github.com/dfkdream/GoSSO/internal/signin"
+	"github.com/emicklei/go-restful/v3"
+)
+
+func TestOpenAPI_WebService(t *testing.T) {
+	ds := auth.NewMemoryStore()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk, err := token.New(ds, keys, time.Minute, auth.DefaultCookieConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := restful.NewContainer()
+	c.Add(openapi.New(c, "GoSSO", "test").WebService())
+	c.Add(user.New(ds).WebService())
+	c.Add(group.New(ds).WebService())
+	c.Add(tk.WebService())
+	c.Add(signin.New(ds, keys, time.Minute, auth.DefaultCookieConfig()).WebService())
+
+	// Scenario 00 : Document lists routes, parameters and models
+	{
+		req := httptest.NewRequest("GET", openapi.DocumentPath, nil)
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected OK but got %d", res.Code)
+		}
+
+		d := new(openapi.Document)
+		if err := json.NewDecoder(res.Body).Decode(d); err != nil {
+			t.Fatal(err)
+		}
+
+		if d.OpenAPI != openapi.Version || d.Info.Title != "GoSSO" {
+			t.Errorf("Unexpected document header %+v", d.Info)
+		}
+
+		if _, ok := d.Paths[openapi.DocumentPath]; ok {
+			t.Error("Expected document route to be hidden")
+		}
+
+		op := d.Paths["/user/{userUUID}/credential"]["post"]
+		if op == nil {
+			t.Fatal("Expected POST /user/{userUUID}/credential")
+		}
+
+		if len(op.Parameters) != 1 || op.Parameters[0].In != "path" || !op.Parameters[0].Required {
+			t.Errorf("Expected required userUUID path parameter but got %+v", op.Parameters)
+		}
+
+		if op.RequestBody == nil || op.RequestBody.Content[restful.MIME_JSON].Schema == nil {
+			t.Errorf("Expected JSON request body but got %+v", op.RequestBody)
+		}
+
+		if _, ok := op.Responses["default"]; !ok || op.Tags[0] != "user" {
+			t.Errorf("Expected default error response and user tag but got %+v", op)
+		}
+
+		refresh := d.Paths["/token/refresh"]["post"]
+		if refresh == nil || refresh.Responses["403"].Content == nil {
+			t.Fatalf("Expected POST /token/refresh with error model but got %+v", refresh)
+		}
+
+		for _, p := range refresh.Parameters {
+			if p.Name == "scope" && (p.Schema.Type != "array" || p.Schema.Items.Type != "string") {
+				t.Errorf("Expected scope to be array of strings but got %+v", p.Schema)
+			}
+			if p.Name == "profile" && len(p.Schema.Enum) != 2 {
+				t.Errorf("Expected profile enum but got %+v", p.Schema)
+			}
+		}
+
+		form := d.Paths["/signin/"]["post"]
+		if form == nil || form.RequestBody.Content["application/x-www-form-urlencoded"].Schema.Properties["username"] == nil {
+			t.Errorf("Expected form request body of sign in but got %+v", form)
+		}
+
+		u := d.Components.Schemas["auth.User"]
+		if u == nil {
+			t.Fatal("Expected auth.User model")
+		}
+
+		if _, ok := u.Properties["password"]; ok {
+			t.Error("Expected password to be left out like encoding/json does")
+		}
+
+		if u.Properties["id"].Format != "uuid" || u.Properties["permissions"].Items.Type != "string" {
+			t.Errorf("Unexpected auth.User properties %+v", u.Properties)
+		}
+
+		// Embedded profile fields are promoted
+		if _, ok := u.Properties["email"]; !ok {
+			t.Errorf("Expected promoted email property but got %+v", u.Properties)
+		}
+
+		if d.Components.Schemas["apierror.Response"] == nil {
+			t.Error("Expected apierror.Response model")
+		}
+
+		// Routes behind token validator require bearer authentication
+		userInfo := d.Paths["/token/userinfo"]["get"]
+		if userInfo == nil || len(userInfo.Security) != 1 || userInfo.Security[0][openapi.BearerAuth] == nil {
+			t.Errorf("Expected bearer authentication of GET /token/userinfo but got %+v", userInfo)
+		}
+
+		if refresh.Security != nil {
+			t.Errorf("Expected no security requirement of POST /token/refresh but got %+v", refresh.Security)
+		}
+
+		if scheme := d.Components.SecuritySchemes[openapi.BearerAuth]; scheme == nil || scheme.Type != "http" || scheme.Scheme != "bearer" {
+			t.Errorf("Expected bearer security scheme but got %+v", scheme)
+		}
+	}
+
+	// Scenario 01 : Explorer page is served
+	{
+		req := httptest.NewRequest("GET", openapi.ExplorerPath, nil)
+		res := httptest.NewRecorder()
+
+		c.ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK || !strings.HasPrefix(res.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("Expected HTML page but got %d %s", res.Code, res.Header().Get("Content-Type"))
+		}
+
+		if !strings.Contains(res.Body.String(), `fetch("openapi.json")`) {
+			t.Error("Expected explorer to load document")
+		}
+	}
+}
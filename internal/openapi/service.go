@@ -0,0 +1,75 @@
+package openapi
+
+import (
+	"net/http"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/emicklei/go-restful/v3"
+)
+
+// hiddenKey marks routes left out of generated document
+const hiddenKey = "openapi.hidden"
+
+const (
+	// SecurityKey is route metadata naming security scheme route requires, such as BearerAuth
+	SecurityKey = "openapi.security"
+
+	// BearerAuth is security scheme of access tokens sent in Authorization header
+	BearerAuth = "bearerAuth"
+)
+
+type OpenAPI struct {
+	container *restful.Container
+	info      Info
+}
+
+// New creates service publishing document of routes in container.
+// Document is generated on request, so services added to container later are included.
+func New(container *restful.Container, title, version string) *OpenAPI {
+	return &OpenAPI{
+		container: container,
+		info: Info{
+			Title:       title,
+			Description: "Errors are returned as JSON object holding machine-readable code, message and request ID.",
+			Version:     version,
+		},
+	}
+}
+
+func (o OpenAPI) document(req *restful.Request, res *restful.Response) {
+	err := res.WriteAsJson(Build(o.container, o.info))
+	if err != nil {
+		apierror.Write(req, res, err)
+	}
+}
+
+func (o OpenAPI) explorer(_ *restful.Request, res *restful.Response) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Inline script and style only, and API is reached from same origin
+	res.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	_, _ = res.Write([]byte(explorerPage))
+}
+
+func (o OpenAPI) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/").
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
+
+	ws.Route(ws.GET(DocumentPath).To(o.document).
+		Metadata(hiddenKey, true).
+		Produces(restful.MIME_JSON).
+		Doc("get OpenAPI document of every route").
+		Writes(Document{}).
+		Returns(http.StatusOK, "OK", Document{}))
+
+	ws.Route(ws.GET(ExplorerPath).To(o.explorer).
+		Metadata(hiddenKey, true).
+		Produces("text/html").
+		Doc("get API explorer page rendering OpenAPI document"))
+
+	return ws
+}
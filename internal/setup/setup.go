@@ -0,0 +1,219 @@
+// Package setup creates admin account of fresh instance.
+// Admin is created from environment variables, by gossoctl, or through setup endpoint
+// guarded by one-time setup token printed at startup.
+package setup
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/dfkdream/GoSSO/internal/apierror"
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/internal/must"
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	EnvAdminUsername = "GOSSO_ADMIN_USERNAME"
+	EnvAdminPassword = "GOSSO_ADMIN_PASSWORD"
+)
+
+var ErrInvalidToken = errors.New("setup: invalid setup token")
+
+var AdminPermissions = []permission.Permission{
+	must.PermissionFromString("+:gosso"),
+}
+
+// CreateAdmin creates admin account. Fails with auth.ErrAlreadyBootstrapped if any user exists.
+func CreateAdmin(ds auth.DataStore, username, password string) (*auth.User, error) {
+	if username == "" || password == "" {
+		return nil, errors.New("setup: username and password are required")
+	}
+
+	hp, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &auth.User{
+		ID:          uuid.New(),
+		Username:    username,
+		Password:    hp,
+		Permissions: AdminPermissions,
+	}
+
+	if err := ds.Bootstrap(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GenerateToken generates random setup token
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Setup serves setup endpoint. Token is discarded once admin is created.
+type Setup struct {
+	ds auth.DataStore
+
+	mu    sync.Mutex
+	token string
+}
+
+// New creates setup endpoint accepting token. Empty token disables endpoint.
+func New(dataStore auth.DataStore, token string) *Setup {
+	return &Setup{
+		ds:    dataStore,
+		token: token,
+	}
+}
+
+// Init creates admin from environment variables if set.
+// If data store still has no user, setup token is generated and printed to log.
+func Init(ds auth.DataStore) (*Setup, error) {
+	username, password := os.Getenv(EnvAdminUsername), os.Getenv(EnvAdminPassword)
+	if username != "" || password != "" {
+		_, err := CreateAdmin(ds, username, password)
+		if err != nil && err != auth.ErrAlreadyBootstrapped {
+			return nil, err
+		}
+		if err == nil {
+			log.Printf("setup: created admin %s from environment", username)
+		}
+	}
+
+	// Single user is enough to tell data store isn't empty, and Size can't report errors
+	page, err := ds.QueryUsers(auth.UserQuery{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(page.Users) > 0 {
+		return New(ds, ""), nil
+	}
+
+	token, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("setup: no admin account. create one with setup token %s", token)
+	return New(ds, token), nil
+}
+
+// Pending reports whether setup endpoint accepts requests
+func (s *Setup) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token != ""
+}
+
+// Token returns setup token. Empty after admin is created.
+func (s *Setup) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token
+}
+
+type adminInfo struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type status struct {
+	Pending bool `json:"pending"`
+}
+
+func (s *Setup) getStatus(req *restful.Request, res *restful.Response) {
+	err := res.WriteEntity(status{Pending: s.Pending()})
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (s *Setup) createAdmin(req *restful.Request, res *restful.Response) {
+	info := new(adminInfo)
+	err := req.ReadEntity(info)
+	if err != nil {
+		apierror.Write(req, res, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if info.Username == "" || info.Password == "" {
+		apierror.Write(req, res, apierror.Invalid("Insufficient request parameters"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == "" {
+		apierror.Write(req, res, auth.ErrAlreadyBootstrapped)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(info.Token), []byte(s.token)) != 1 {
+		apierror.Write(req, res, apierror.Forbidden(ErrInvalidToken.Error()))
+		return
+	}
+
+	u, err := CreateAdmin(s.ds, info.Username, info.Password)
+	if err == auth.ErrAlreadyBootstrapped {
+		s.token = ""
+		apierror.Write(req, res, err)
+		return
+	}
+
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+
+	s.token = ""
+
+	err = res.WriteHeaderAndEntity(http.StatusCreated, u.ID)
+	if err != nil {
+		apierror.Write(req, res, err)
+		return
+	}
+}
+
+func (s *Setup) WebService() *restful.WebService {
+	ws := new(restful.WebService)
+
+	ws.
+		Path("/setup").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON).
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument)
+
+	ws.Route(ws.GET("/").To(s.getStatus).
+		Doc("Reports whether admin account has to be created").
+		Writes(status{}))
+
+	ws.Route(ws.POST("/").To(s.createAdmin).
+		Doc("Create admin account using one-time setup token").
+		Reads(adminInfo{}).
+		Writes(uuid.UUID{}))
+
+	return ws
+}
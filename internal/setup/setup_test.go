@@ -0,0 +1,118 @@
+package setup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/emicklei/go-restful/v3"
+)
+
+func createTempDS() auth.DataStore {
+	return auth.NewMemoryStore()
+}
+
+func TestCreateAdmin_Concurrent(t *testing.T) {
+	ds := createTempDS()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := CreateAdmin(ds, fmt.Sprintf("admin%d", i), "password")
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			created++
+		case auth.ErrAlreadyBootstrapped:
+		default:
+			t.Error(err)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("Expected 1 admin but got %d", created)
+	}
+
+	users, err := ds.GetAllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 1 || !users[0].Public().HasPermission(AdminPermissions[0]) {
+		t.Errorf("Expected single admin but got %+v", users)
+	}
+}
+
+func TestSetup_WebService(t *testing.T) {
+	ds := createTempDS()
+
+	s, err := Init(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Pending() {
+		t.Fatal("Expected pending setup")
+	}
+
+	c := restful.NewContainer()
+	c.Add(s.WebService())
+
+	post := func(info adminInfo) int {
+		b, _ := json.Marshal(info)
+		req := httptest.NewRequest("POST", "/setup/", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		c.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// Scenario 01 : Invalid setup token
+	{
+		if code := post(adminInfo{Token: "wrong", Username: "admin", Password: "password"}); code != http.StatusForbidden {
+			t.Errorf("Expected 403 but got %d", code)
+		}
+	}
+
+	// Scenario 02 : Valid setup token
+	{
+		if code := post(adminInfo{Token: s.Token(), Username: "admin", Password: "password"}); code != http.StatusCreated {
+			t.Errorf("Expected 201 but got %d", code)
+		}
+
+		u, err := ds.GetUserByUsername("admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !u.Password.Validate("password") {
+			t.Error("Expected password to be set")
+		}
+	}
+
+	// Scenario 03 : Setup token is single use
+	{
+		if s.Pending() {
+			t.Error("Expected setup to be done")
+		}
+
+		if code := post(adminInfo{Token: "", Username: "other", Password: "password"}); code != http.StatusConflict {
+			t.Errorf("Expected 409 but got %d", code)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package signer
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate creates Unix socket under umask denying group and others, so socket file is never accessible by them.
+// Umask is process wide, so signer process shouldn't create files concurrently while listening.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0177)
+	defer syscall.Umask(old)
+
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,8 @@
+package signer
+
+import "net"
+
+// listenPrivate creates Unix socket. Windows doesn't apply file mode to sockets, so access is controlled by directory ACL.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,206 @@
+// Package signer isolates token signing key in separate process.
+// Signer process holds private key and serves signing requests over Unix socket,
+// and API process signs tokens through Remote, which implements crypto.Signer without key material.
+package signer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+)
+
+// serviceName is RPC service name of signer process
+const serviceName = "Signer"
+
+var ErrUnsupportedOpts = errors.New("signer: unsupported signer options")
+
+type PublicKeyArgs struct{}
+
+type PublicKeyReply struct {
+	// PKIX DER encoded public key
+	PublicKey []byte
+}
+
+type SignArgs struct {
+	Digest []byte
+	Hash   crypto.Hash
+}
+
+type SignReply struct {
+	Signature []byte
+}
+
+// service exposes only public key and signing operations of key
+type service struct {
+	key crypto.Signer
+}
+
+func (s *service) PublicKey(_ PublicKeyArgs, reply *PublicKeyReply) error {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		return err
+	}
+
+	reply.PublicKey = der
+	return nil
+}
+
+func (s *service) Sign(args SignArgs, reply *SignReply) error {
+	sig, err := s.key.Sign(rand.Reader, args.Digest, args.Hash)
+	if err != nil {
+		return err
+	}
+
+	reply.Signature = sig
+	return nil
+}
+
+// Server serves signing requests using key
+type Server struct {
+	rpc *rpc.Server
+}
+
+func NewServer(key crypto.Signer) (*Server, error) {
+	s := rpc.NewServer()
+	err := s.RegisterName(serviceName, &service{key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		rpc: s,
+	}, nil
+}
+
+// Serve accepts connections on l until l is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.rpc.ServeConn(conn)
+	}
+}
+
+// Listen creates Unix socket at path accessible only by owner. Stale socket file is removed.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return listenPrivate(path)
+}
+
+// Remote is crypto.Signer delegating signing to signer process
+type Remote struct {
+	path   string
+	public crypto.PublicKey
+
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+// Dial connects to signer process listening on Unix socket path and fetches its public key
+func Dial(path string) (*Remote, error) {
+	r := &Remote{
+		path: path,
+	}
+
+	reply := new(PublicKeyReply)
+	err := r.call(serviceName+".PublicKey", PublicKeyArgs{}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	r.public, err = x509.ParsePKIXPublicKey(reply.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Remote) Public() crypto.PublicKey {
+	return r.public
+}
+
+// Sign implements crypto.Signer. rand is ignored since signer process uses its own randomness.
+func (r *Remote) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := opts.(crypto.Hash)
+	if !ok {
+		return nil, ErrUnsupportedOpts
+	}
+
+	reply := new(SignReply)
+	err := r.call(serviceName+".Sign", SignArgs{Digest: digest, Hash: hash}, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Signature, nil
+}
+
+func (r *Remote) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil {
+		return nil
+	}
+
+	err := r.client.Close()
+	r.client = nil
+	return err
+}
+
+// conn returns connection to signer process, dialing if there is none
+func (r *Remote) conn() (*rpc.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil {
+		c, err := rpc.Dial("unix", r.path)
+		if err != nil {
+			return nil, err
+		}
+		r.client = c
+	}
+
+	return r.client, nil
+}
+
+// drop closes lost connection c unless other caller already replaced it
+func (r *Remote) drop(c *rpc.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_ = c.Close()
+	if r.client == c {
+		r.client = nil
+	}
+}
+
+// call invokes method, reconnecting once if connection to signer process is lost.
+// rpc.Client is safe for concurrent use, so calls share connection and lock is held only to replace it.
+func (r *Remote) call(method string, args, reply interface{}) error {
+	for retry := 0; ; retry++ {
+		c, err := r.conn()
+		if err != nil {
+			return err
+		}
+
+		err = c.Call(method, args, reply)
+		if (err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF) && retry == 0 {
+			r.drop(c)
+			continue
+		}
+		return err
+	}
+}
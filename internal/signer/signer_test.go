@@ -0,0 +1,155 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
+	"github.com/google/uuid"
+)
+
+func TestRemote(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for i, key := range []crypto.Signer{ec, ed, rs} {
+		path := filepath.Join(dir, "signer.sock")
+
+		srv, err := NewServer(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := Listen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = srv.Serve(l) }()
+
+		if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("%d: Expected socket accessible only by owner but got %v %v", i, fi.Mode(), err)
+		}
+
+		remote, err := Dial(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := keystore.New(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tok, err := store.Sign(auth.NewUserClaim(auth.User{ID: uuid.New(), Username: "hello"}, "", time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, err := gosso.ValidateToken(tok, key.Public()); !ok {
+			t.Errorf("%d: Expected valid token but got %v", i, err)
+		}
+
+		_ = remote.Close()
+		_ = l.Close()
+	}
+}
+
+func TestRemote_Concurrent(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "signer.sock")
+
+	srv, err := NewServer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() net.Listener {
+		l, err := Listen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = srv.Serve(l) }()
+		return l
+	}
+
+	l := serve()
+
+	remote, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = remote.Close() }()
+
+	sign := func() error {
+		digest := sha256.Sum256([]byte("hello"))
+		_, err := remote.Sign(nil, digest[:], crypto.SHA256)
+		return err
+	}
+
+	// Scenario 01 : Concurrent calls share connection
+	{
+		errs := make(chan error, 16)
+		for i := 0; i < cap(errs); i++ {
+			go func() { errs <- sign() }()
+		}
+
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// Scenario 02 : Lost connection is replaced once signer process is back
+	{
+		_ = l.Close()
+		_ = remote.client.Close()
+		l = serve()
+		defer func() { _ = l.Close() }()
+
+		if err := sign(); err != nil {
+			t.Errorf("Expected reconnect but got %v", err)
+		}
+	}
+}
@@ -3,42 +3,39 @@
 package signin
 
 import (
-	"crypto/ecdsa"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/emicklei/go-restful/v3"
 
-	"github.com/dfkdream/GoSSO/internal/must"
-
-	"github.com/google/uuid"
-
-	"github.com/dgrijalva/jwt-go"
+	"github.com/dfkdream/GoSSO/internal/keystore"
 
 	"github.com/dfkdream/permission"
 
+	"github.com/dfkdream/GoSSO/internal/apierror"
 	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/dfkdream/GoSSO/pkg/gosso"
 )
 
 var refreshTokenPermissions = []permission.Permission{
-	must.PermissionFromString("+:gosso:token:refresh"),
-}
-
-var defaultPermissions = []permission.Permission{
-	must.PermissionFromString("+:gosso"),
+	gosso.RefreshPermission,
 }
 
 type SignIn struct {
-	ds                  *auth.DataStore
-	pk                  *ecdsa.PrivateKey
+	ds                  auth.DataStore
+	keys                *keystore.Store
 	refreshTokenTimeout time.Duration
+	cookie              auth.CookieConfig
 }
 
-func New(dataStore *auth.DataStore, privateKey *ecdsa.PrivateKey, tokenTimeout time.Duration) SignIn {
+func New(dataStore auth.DataStore, keys *keystore.Store, tokenTimeout time.Duration, cookie auth.CookieConfig) SignIn {
 	return SignIn{
 		ds:                  dataStore,
-		pk:                  privateKey,
+		keys:                keys,
 		refreshTokenTimeout: tokenTimeout,
+		cookie:              cookie,
 	}
 }
 
@@ -46,93 +43,83 @@ func (h SignIn) signInHandler(req *restful.Request, res *restful.Response) {
 	redirect := "/signin"
 
 	redirection := func(u string) {
-		http.Redirect(res.ResponseWriter, req.Request, u, http.StatusTemporaryRedirect)
+		// Redirect through response, so status is recorded by filters
+		http.Redirect(res, req.Request, u, http.StatusTemporaryRedirect)
+	}
+
+	// fail redirects back to sign in page with machine-readable error code
+	fail := func(code apierror.Code) {
+		metrics.SignInFailures.Inc(string(code))
+		redirection(redirect + "?error=" + string(code))
 	}
 
 	username, err := req.BodyParameter("username")
 	if err != nil {
-		redirection(redirect)
+		fail(apierror.CodeBadRequest)
 		return
 	}
 
 	password, err := req.BodyParameter("password")
 	if err != nil {
-		redirection(redirect)
+		fail(apierror.CodeBadRequest)
 		return
 	}
 
 	if username == "" || password == "" {
-		redirection(redirect)
+		fail(apierror.CodeBadRequest)
 		return
 	}
 
-	// Create admin account with default permissions if user not exists
-	if h.ds.Size() == 0 {
-		pw, err := auth.HashPassword(password)
-		if err != nil {
-			redirection(redirect)
-			return
-		}
-
-		err = h.ds.AddUser(&auth.User{
-			ID:          uuid.New(),
-			Username:    username,
-			Password:    pw,
-			Permissions: defaultPermissions,
-		})
-		if err != nil {
-			redirection(redirect)
-			return
-		}
+	u, err := h.ds.GetUserByUsername(username)
+	if err == auth.ErrNotFound {
+		fail(apierror.CodeInvalidCredentials)
+		return
+	}
+	if err != nil {
+		log.Printf("signin: loading user %s failed: %v", username, err)
+		fail(apierror.CodeInternal)
+		return
 	}
 
-	u, err := h.ds.GetUserByUsername(username)
+	if !u.Password.Validate(password) {
+		fail(apierror.CodeInvalidCredentials)
+		return
+	}
+
+	// Status is checked after password, so it's revealed only to password holder
+	if err := u.Status.Check(time.Now()); err != nil {
+		fail(apierror.From(err).Code)
+		return
+	}
+
+	token, err := h.generateRefreshToken(u)
 	if err != nil {
-		redirection(redirect)
+		log.Printf("signin: signing refresh token of %s failed: %v", username, err)
+		fail(apierror.CodeInternal)
 		return
 	}
 
-	if u.Password.Validate(password) {
-
-		if r, err := req.BodyParameter("redirect"); err == nil && r != "" {
-			redirect = r
-		} else {
-			redirect = "/"
-		}
-
-		token, err := h.generateRefreshToken(u)
-		if err != nil {
-			redirection(redirect)
-			return
-		}
-
-		http.SetCookie(res, &http.Cookie{
-			Name:     "token",
-			Value:    token,
-			Path:     "",
-			Domain:   "",
-			Secure:   true,
-			HttpOnly: true,
-			SameSite: 0,
-		})
-
-		redirection(redirect)
+	if r, err := req.BodyParameter("redirect"); err == nil && r != "" {
+		redirect = r
 	} else {
-		redirection(redirect)
+		redirect = "/"
 	}
+
+	http.SetCookie(res, h.cookie.Cookie(token, time.Now().Add(h.refreshTokenTimeout)))
+
+	metrics.SignInSuccesses.Inc()
+	metrics.TokensIssued.Inc("refresh")
+	redirection(redirect)
 }
 
+// generateRefreshToken signs refresh token carrying security stamp of user
 func (h SignIn) generateRefreshToken(u *auth.User) (string, error) {
 	payload := u
 	payload.Permissions = refreshTokenPermissions
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, auth.UserClaim{
-		Issuer:    "gosso",
-		IssuedAt:  time.Now().Unix(),
-		NotBefore: time.Now().Unix(),
-		ExpiresAt: time.Now().Add(h.refreshTokenTimeout).Unix(),
-		User:      *payload,
-	})
-	return token.SignedString(h.pk)
+
+	c := auth.NewUserClaim(*payload, "", h.refreshTokenTimeout)
+	c.SecurityStamp = u.SecurityStamp
+	return h.keys.Sign(c)
 }
 
 func (h SignIn) WebService() *restful.WebService {
@@ -140,6 +127,8 @@ func (h SignIn) WebService() *restful.WebService {
 
 	ws.
 		Path("/signin").
+		Filter(apierror.RequestID).
+		Filter(metrics.Instrument).
 		Consumes("multipart/form-data",
 			"application/x-www-form-urlencoded").
 		Param(ws.FormParameter("username", "User name")).
@@ -6,11 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -19,19 +17,29 @@ import (
 	"github.com/dgrijalva/jwt-go"
 
 	"github.com/dfkdream/GoSSO/internal/auth"
+	"github.com/dfkdream/GoSSO/internal/keystore"
+	"github.com/dfkdream/GoSSO/internal/metrics"
+	"github.com/google/uuid"
 )
 
-func createTempDS() *auth.DataStore {
-	testDir, err := ioutil.TempDir("", "datastore")
-	//fmt.Println(testDir)
+func createTempDS() auth.DataStore {
+	return auth.NewMemoryStore()
+}
+
+func addUser(t *testing.T, ds auth.DataStore, username, password string) {
+	hp, err := auth.HashPassword(password)
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
-	d, err := auth.NewDataStore(filepath.Join(testDir, "test.db"))
+
+	err = ds.AddUser(&auth.User{
+		ID:       uuid.New(),
+		Username: username,
+		Password: hp,
+	})
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
-	return d
 }
 
 func TestSignIn_WebService(t *testing.T) {
@@ -42,10 +50,39 @@ func TestSignIn_WebService(t *testing.T) {
 
 	ds := createTempDS()
 
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	h := restful.NewContainer()
-	h.Add(New(ds, pk, time.Hour).WebService())
+	h.Add(New(ds, keys, time.Hour, auth.DefaultCookieConfig()).WebService())
+
+	// Scenario 00 : Sign in to empty data store doesn't create user
+	{
+		data := url.Values{}
+		data.Set("username", "hello")
+		data.Add("password", "world")
+
+		req := httptest.NewRequest("POST", "/signin", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		if len(res.Result().Cookies()) > 0 {
+			t.Errorf("Expected no cookie but got %+v", res.Result().Cookies())
+		}
+
+		if users, _ := ds.GetAllUsers(); len(users) != 0 {
+			t.Errorf("Expected no user but got %+v", users)
+		}
+	}
 
-	// Scenario 01 : Initialize User
+	addUser(t, ds, "hello", "world")
+
+	// Scenario 01 : Valid Sign in
 	{
 		data := url.Values{}
 		data.Set("username", "hello")
@@ -78,6 +115,8 @@ func TestSignIn_WebService(t *testing.T) {
 
 	// Scenario 02: Invalid Sign in attempt
 	{
+		failures := metrics.SignInFailures.Value("invalid_credentials")
+
 		data := url.Values{}
 		data.Set("username", "halo")
 		data.Add("password", "world")
@@ -92,10 +131,20 @@ func TestSignIn_WebService(t *testing.T) {
 		if len(res.Result().Cookies()) > 0 {
 			t.Errorf("Expected no cookie but got %+v", res.Result().Cookies())
 		}
+
+		if loc := res.Header().Get("Location"); loc != "/signin?error=invalid_credentials" {
+			t.Errorf("Expected redirect with invalid_credentials but got %s", loc)
+		}
+
+		if n := metrics.SignInFailures.Value("invalid_credentials"); n != failures+1 {
+			t.Errorf("Expected %d invalid_credentials failures but got %d", failures+1, n)
+		}
 	}
 
 	// Scenario 03 : Valid Sign in attempt
 	{
+		successes := metrics.SignInSuccesses.Value()
+
 		data := url.Values{}
 		data.Set("username", "hello")
 		data.Add("password", "world")
@@ -123,5 +172,95 @@ func TestSignIn_WebService(t *testing.T) {
 		if !token.Valid {
 			t.Error("token not valid")
 		}
+
+		if n := metrics.SignInSuccesses.Value(); n != successes+1 {
+			t.Errorf("Expected %d successes but got %d", successes+1, n)
+		}
+	}
+
+	// Scenario 04 : Disabled user can't sign in
+	{
+		u, err := ds.GetUserByUsername("hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u.Status = u.Status.Disable("left", nil)
+		if err := ds.UpdateUser(u); err != nil {
+			t.Fatal(err)
+		}
+
+		data := url.Values{}
+		data.Set("username", "hello")
+		data.Add("password", "world")
+
+		req := httptest.NewRequest("POST", "/signin", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+
+		res := httptest.NewRecorder()
+
+		h.ServeHTTP(res, req)
+
+		if len(res.Result().Cookies()) > 0 {
+			t.Errorf("Expected no cookie but got %+v", res.Result().Cookies())
+		}
+
+		if loc := res.Header().Get("Location"); loc != "/signin?error=account_disabled" {
+			t.Errorf("Expected redirect with account_disabled but got %s", loc)
+		}
+	}
+}
+
+func TestSignIn_Cookie(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := createTempDS()
+	addUser(t, ds, "hello", "world")
+
+	keys, err := keystore.New(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := restful.NewContainer()
+	h.Add(New(ds, keys, time.Hour, auth.CookieConfig{
+		Name:       "gosso_session",
+		Domain:     "corp.example",
+		Path:       "/",
+		SameSite:   http.SameSiteLaxMode,
+		Secure:     true,
+		Persistent: true,
+	}).WebService())
+
+	data := url.Values{}
+	data.Set("username", "hello")
+	data.Add("password", "world")
+
+	req := httptest.NewRequest("POST", "/signin", bytes.NewBufferString(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; param=value")
+
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected 1 cookie but got %d", len(cookies))
+	}
+
+	c := cookies[0]
+	if c.Name != "gosso_session" || c.Domain != "corp.example" || c.Path != "/" {
+		t.Errorf("Unexpected cookie attributes: %+v", c)
+	}
+
+	if c.SameSite != http.SameSiteLaxMode || !c.Secure || !c.HttpOnly {
+		t.Errorf("Unexpected cookie security attributes: %+v", c)
+	}
+
+	if c.MaxAge <= 0 {
+		t.Errorf("Expected persistent cookie but got MaxAge %d", c.MaxAge)
 	}
 }
@@ -0,0 +1,169 @@
+package gosso
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// Claims is JWT payload of tokens issued by GoSSO.
+// Registered claims follow RFC 7519 and user information is carried in usr claim.
+//
+// Compact tokens carry username in name claim, and either scoped permissions in prm claim
+// or digest of full permission set in pdg claim instead of usr claim.
+// Decoded compact claims are reflected to User, so User.Permissions is empty if only digest is present.
+//
+// Profile claims are present in both profiles only if requested when token was issued.
+type Claims struct {
+	ID        string   `json:"jti,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	Issuer    string   `json:"iss"`
+	User      User     `json:"usr"`
+	// SecurityStamp of refresh token must match user's current stamp when token is refreshed
+	SecurityStamp string `json:"sst,omitempty"`
+
+	Username         string                  `json:"name,omitempty"`
+	Permissions      []permission.Permission `json:"prm,omitempty"`
+	PermissionDigest string                  `json:"pdg,omitempty"`
+
+	Profile
+}
+
+// Profile is user profile carried in email, display_name and attrs claims
+type Profile struct {
+	Email       string            `json:"email,omitempty"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Attributes  map[string]string `json:"attrs,omitempty"`
+}
+
+// Audience is aud claim, which can be single string or array of strings
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if s == "" {
+			*a = nil
+		} else {
+			*a = Audience{s}
+		}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+// Contains reports whether audience is in a. Empty audience matches only empty a.
+func (a Audience) Contains(audience string) bool {
+	if audience == "" {
+		return len(a) == 0
+	}
+
+	for _, v := range a {
+		if v == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON reads tokens with sub claim, compact tokens and legacy tokens carrying user ID only in usr claim
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	type claims Claims
+	if err := json.Unmarshal(b, (*claims)(c)); err != nil {
+		return err
+	}
+
+	if c.User.Username == "" {
+		c.User.Username = c.Username
+	}
+
+	if c.User.Permissions == nil {
+		c.User.Permissions = c.Permissions
+	}
+
+	if c.Subject == "" && c.User.ID != uuid.Nil {
+		c.Subject = c.User.ID.String()
+	}
+
+	if c.User.ID == uuid.Nil && c.Subject != "" {
+		id, err := uuid.Parse(c.Subject)
+		if err != nil {
+			return err
+		}
+		c.User.ID = id
+	}
+
+	return nil
+}
+
+// IsCompact reports whether claims are compact profile, which omits full permission set
+func (c Claims) IsCompact() bool {
+	return c.Username != ""
+}
+
+func (c Claims) Valid() error {
+	vErr := new(jwt.ValidationError)
+	now := time.Now().Unix()
+
+	// exp
+	if !(now < c.ExpiresAt) {
+		vErr.Inner = errors.New("token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+
+	// iat
+	if !(now >= c.IssuedAt) {
+		vErr.Inner = errors.New("token used before issued")
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+
+	// nbf
+	if !(now >= c.NotBefore) {
+		vErr.Inner = errors.New("token is not valid yet")
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if vErr.Errors == 0 {
+		return nil
+	}
+
+	return vErr
+}
+
+// validAt checks exp, iat and nbf at now allowing leeway clock skew
+func (c Claims) validAt(now time.Time, leeway time.Duration) error {
+	if !now.Add(-leeway).Before(time.Unix(c.ExpiresAt, 0)) {
+		return ErrTokenExpired
+	}
+
+	if now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotValidYet
+	}
+
+	if now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrTokenUsedBeforeIssued
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package gosso
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestClaims_UnmarshalJSON(t *testing.T) {
+	id := uuid.New()
+
+	for i, v := range []struct {
+		payload  string
+		audience Audience
+	}{
+		// Legacy shape: user ID only in usr, empty aud string
+		{`{"aud":"","exp":1,"iat":1,"nbf":1,"iss":"gosso","usr":{"id":"` + id.String() + `","username":"hello","permissions":[]}}`, nil},
+		// RFC 7519 shape
+		{`{"jti":"abc","sub":"` + id.String() + `","aud":"app","exp":1,"iat":1,"nbf":1,"iss":"gosso","usr":{"id":"` + id.String() + `","username":"hello","permissions":[]}}`, Audience{"app"}},
+		// Subject only with audience array
+		{`{"sub":"` + id.String() + `","aud":["app","web"],"exp":1,"iat":1,"nbf":1,"iss":"gosso","usr":{"username":"hello"}}`, Audience{"app", "web"}},
+	} {
+		c := new(Claims)
+		err := json.Unmarshal([]byte(v.payload), c)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+
+		if c.Subject != id.String() || c.User.ID != id {
+			t.Errorf("%d: Expected subject %s but got %s (%s)", i, id, c.Subject, c.User.ID)
+		}
+
+		if len(c.Audience) != len(v.audience) {
+			t.Errorf("%d: Expected audience %v but got %v", i, v.audience, c.Audience)
+		}
+
+		for _, a := range v.audience {
+			if !c.Audience.Contains(a) {
+				t.Errorf("%d: Expected audience %v but got %v", i, v.audience, c.Audience)
+			}
+		}
+	}
+}
+
+func TestClaims_Profile(t *testing.T) {
+	c := new(Claims)
+	err := json.Unmarshal([]byte(`{"sub":"`+uuid.New().String()+`","exp":1,"iat":1,"nbf":1,"iss":"gosso","name":"hello","email":"hello@example.com","display_name":"Hello","attrs":{"team":"sso"}}`), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Email != "hello@example.com" || c.DisplayName != "Hello" || c.Attributes["team"] != "sso" {
+		t.Errorf("Unexpected profile %+v", c.Profile)
+	}
+
+	if c.User.Username != "hello" {
+		t.Errorf("Expected username hello but got %s", c.User.Username)
+	}
+}
+
+func TestAudience_MarshalJSON(t *testing.T) {
+	for i, v := range []struct {
+		aud  Audience
+		json string
+	}{
+		{Audience{"app"}, `"app"`},
+		{Audience{"app", "web"}, `["app","web"]`},
+	} {
+		b, err := json.Marshal(v.aud)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if string(b) != v.json {
+			t.Errorf("%d: Expected %s but got %s", i, v.json, string(b))
+		}
+	}
+}
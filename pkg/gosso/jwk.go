@@ -0,0 +1,184 @@
+package gosso
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrUnsupportedKey = errors.New("gosso: unsupported key type")
+
+// JWK is JSON Web Key (RFC 7517) holding public signing key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKSet is JSON Web Key Set published by GoSSO
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyID derives key id from SHA-256 hash of PKIX encoded public key
+func KeyID(puk crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(puk)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+// NewJWK encodes public key as JWK with key id derived by KeyID
+func NewJWK(puk crypto.PublicKey) (JWK, error) {
+	kid, err := KeyID(puk)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	switch k := puk.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			KeyType:   "EC",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: ecdsaAlgorithm(k.Curve),
+			Curve:     k.Curve.Params().Name,
+			X:         base64.RawURLEncoding.EncodeToString(padBytes(k.X.Bytes(), size)),
+			Y:         base64.RawURLEncoding.EncodeToString(padBytes(k.Y.Bytes(), size)),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: EdDSA.Alg(),
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	}
+
+	return JWK{}, ErrUnsupportedKey
+}
+
+// PublicKey decodes JWK into public key
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("gosso: unsupported curve: %s", j.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		k := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !curve.IsOnCurve(k.X, k.Y) {
+			return nil, errors.New("gosso: invalid EC public key")
+		}
+
+		return k, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, fmt.Errorf("gosso: unsupported curve: %s", j.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("gosso: invalid Ed25519 public key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exp := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("gosso: invalid RSA public key")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exp.Int64()),
+		}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func ecdsaAlgorithm(c elliptic.Curve) string {
+	switch c.Params().BitSize {
+	case 384:
+		return "ES384"
+	case 521:
+		return "ES512"
+	}
+	return "ES256"
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
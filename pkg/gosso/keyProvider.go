@@ -0,0 +1,174 @@
+package gosso
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKSPath is path of JWK Set endpoint relative to GoSSO URL
+const JWKSPath = "/token/jwks"
+
+// minRefetchInterval limits refetch caused by unknown key id
+var minRefetchInterval = 10 * time.Second
+
+var ErrUnknownKey = errors.New("gosso: unknown signing key")
+
+// DefaultClient is used when no HTTP client is given. Unlike http.DefaultClient it times out,
+// so unresponsive GoSSO server fails requests instead of blocking them.
+var DefaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// KeySource resolves token verification key by key id.
+// KeySource can be passed as public key of validation functions.
+type KeySource interface {
+	PublicKey(kid string) (crypto.PublicKey, error)
+}
+
+// KeyProvider fetches signing keys from GoSSO JWK Set endpoint and caches them.
+// Keys are refetched when cache is older than TTL or unknown key id is requested.
+// Concurrent lookups share single refetch in flight, which is done without holding lock.
+type KeyProvider struct {
+	URL    string
+	TTL    time.Duration
+	Client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	inflight    *keyFetch
+}
+
+// keyFetch is refetch in flight. err is set before done is closed.
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeyProvider creates KeyProvider fetching keys from gossoURL + JWKSPath
+func NewKeyProvider(gossoURL string, ttl time.Duration) *KeyProvider {
+	return &KeyProvider{
+		URL:    strings.TrimSuffix(gossoURL, "/") + JWKSPath,
+		TTL:    ttl,
+		Client: DefaultClient,
+	}
+}
+
+// PublicKey returns key with kid. Empty kid is accepted only when single key is published.
+func (p *KeyProvider) PublicKey(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Cached keys are used while expired keys are being refetched
+	expired := time.Since(p.fetchedAt) > p.TTL && time.Since(p.attemptedAt) > minRefetchInterval && p.inflight == nil
+	if p.keys == nil || expired {
+		if err := p.refresh(); err != nil && p.keys == nil {
+			return nil, err
+		}
+	}
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+
+	// Key may be rotated
+	if time.Since(p.attemptedAt) > minRefetchInterval {
+		if err := p.refresh(); err != nil {
+			return nil, err
+		}
+
+		if k, ok := p.lookup(kid); ok {
+			return k, nil
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+// Refresh refetches keys regardless of cache state
+func (p *KeyProvider) Refresh() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.refresh()
+}
+
+func (p *KeyProvider) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// refresh refetches keys, or waits for refetch already in flight.
+// It's called with p.mu held, which is released while fetching.
+func (p *KeyProvider) refresh() error {
+	if f := p.inflight; f != nil {
+		p.mu.Unlock()
+		<-f.done
+		p.mu.Lock()
+		return f.err
+	}
+
+	f := &keyFetch{done: make(chan struct{})}
+	p.inflight = f
+	p.attemptedAt = time.Now()
+
+	p.mu.Unlock()
+	keys, err := p.fetch()
+	p.mu.Lock()
+
+	if err == nil {
+		p.keys = keys
+		p.fetchedAt = time.Now()
+	}
+
+	p.inflight = nil
+	f.err = err
+	close(f.done)
+
+	return err
+}
+
+func (p *KeyProvider) fetch() (map[string]crypto.PublicKey, error) {
+	client := p.Client
+	if client == nil {
+		client = DefaultClient
+	}
+
+	res, err := client.Get(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gosso: fetching keys failed: %s", res.Status)
+	}
+
+	set := new(JWKSet)
+	err = json.NewDecoder(res.Body).Decode(set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, j := range set.Keys {
+		k, err := j.PublicKey()
+		if err != nil {
+			continue // skip keys not supported by this client
+		}
+		keys[j.KeyID] = k
+	}
+
+	return keys, nil
+}
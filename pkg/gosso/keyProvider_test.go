@@ -0,0 +1,203 @@
+package gosso
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyProvider(t *testing.T) {
+	minRefetchInterval = 0
+	defer func() { minRefetchInterval = 10 * time.Second }()
+
+	var mu sync.Mutex
+	var current *ecdsa.PrivateKey
+	fetches := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != JWKSPath {
+			http.NotFound(w, r)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+
+		j, err := NewJWK(current.Public())
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{j}})
+	}))
+	defer srv.Close()
+
+	sign := func(pk *ecdsa.PrivateKey) string {
+		kid, err := KeyID(pk.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, Claims{
+			IssuedAt:  time.Now().Unix(),
+			NotBefore: time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			User:      User{Username: "hello"},
+		})
+		tok.Header["kid"] = kid
+
+		s, err := tok.SignedString(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	k1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	current = k1
+	mu.Unlock()
+
+	kp := NewKeyProvider(srv.URL+"/", time.Hour)
+
+	if _, ok, err := ValidateToken(sign(k1), kp); !ok {
+		t.Errorf("Expected valid token but got %v", err)
+	}
+
+	if _, ok, err := ValidateToken(sign(k1), kp); !ok {
+		t.Errorf("Expected valid token but got %v", err)
+	}
+
+	if fetches != 1 {
+		t.Errorf("Expected keys cached but fetched %d times", fetches)
+	}
+
+	// Rotate key
+	mu.Lock()
+	current = k2
+	mu.Unlock()
+
+	if _, ok, err := ValidateToken(sign(k2), kp); !ok {
+		t.Errorf("Expected valid token after rotation but got %v", err)
+	}
+
+	if fetches != 2 {
+		t.Errorf("Expected refetch on unknown kid but fetched %d times", fetches)
+	}
+
+	if _, ok, _ := ValidateToken(sign(k1), kp); ok {
+		t.Error("Expected token signed with retired key to be rejected")
+	}
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	for _, c := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		pk, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		j, err := NewJWK(pk.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		k, err := j.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !pk.PublicKey.Equal(k) {
+			t.Errorf("%s: decoded key mismatch", c.Params().Name)
+		}
+	}
+}
+
+func TestKeyProvider_Concurrent(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := KeyID(pk.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+
+		<-release
+
+		j, err := NewJWK(pk.Public())
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{j}})
+	}))
+	defer srv.Close()
+
+	kp := NewKeyProvider(srv.URL, time.Hour)
+
+	// Lookups wait for single fetch in flight
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := kp.PublicKey(kid); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if fetches != 1 {
+		t.Errorf("Expected single fetch but fetched %d times", fetches)
+	}
+}
+
+func TestKeyProvider_Timeout(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	kp := NewKeyProvider(srv.URL, time.Hour)
+	if kp.Client.Timeout == 0 {
+		t.Fatal("Expected default client with timeout")
+	}
+	kp.Client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	if _, err := kp.PublicKey(""); err == nil {
+		t.Error("Expected unresponsive endpoint to fail lookup")
+	}
+}
@@ -0,0 +1,68 @@
+package gosso
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+)
+
+type contextKey struct{}
+
+// UserAttribute is restful.Request attribute name holding authenticated *User
+const UserAttribute = "gosso.user"
+
+var (
+	ErrNoToken   = errors.New("gosso: bearer token not provided")
+	ErrForbidden = errors.New("gosso: insufficient permission")
+)
+
+// BearerToken extracts token from Authorization header
+func BearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+
+	t := strings.TrimSpace(h[7:])
+	return t, t != ""
+}
+
+// ContextWithUser returns copy of ctx holding u
+func ContextWithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// UserFromContext returns user stored by middleware
+func UserFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok && u != nil
+}
+
+func writeAuthError(w http.ResponseWriter, _ *http.Request, status int, _ error) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gosso"`)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// defaultValidator accepts access tokens issued by GoSSO only, so refresh tokens can't be used as bearer tokens
+func defaultValidator(puk crypto.PublicKey, required []permission.Permission) *Validator {
+	return NewValidator(puk, WithIssuer(Issuer), RejectRefreshTokens(), RequirePermissions(required...))
+}
+
+// Middleware returns http middleware that requires valid access token having every required permission.
+// Authenticated user can be retrieved using UserFromContext.
+func Middleware(puk crypto.PublicKey, required ...permission.Permission) func(http.Handler) http.Handler {
+	return defaultValidator(puk, required).Middleware()
+}
+
+// Filter returns go-restful filter that requires valid access token having every required permission.
+// Authenticated user is stored in request context and UserAttribute attribute.
+func Filter(puk crypto.PublicKey, required ...permission.Permission) restful.FilterFunction {
+	return defaultValidator(puk, required).Filter()
+}
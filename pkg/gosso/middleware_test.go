@@ -0,0 +1,146 @@
+package gosso
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+)
+
+func mustPermission(s string) permission.Permission {
+	p, err := permission.FromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestMiddleware(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := Claims{
+		Issuer:    Issuer,
+		IssuedAt:  time.Now().Unix(),
+		NotBefore: time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		User: User{
+			ID:          uuid.New(),
+			Username:    "hello",
+			Permissions: []permission.Permission{mustPermission("+:app:read")},
+		},
+	}
+	tok := signClaims(t, pk, claims)
+
+	refresh := claims
+	refresh.User.Permissions = []permission.Permission{RefreshPermission}
+	refreshTok := signClaims(t, pk, refresh)
+
+	foreign := claims
+	foreign.Issuer = "evil"
+	foreignTok := signClaims(t, pk, foreign)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, found := UserFromContext(r.Context())
+		if !found || u.Username != "hello" {
+			t.Errorf("Expected user in context but got %+v", u)
+		}
+	})
+
+	ws := new(restful.WebService)
+	ws.Path("/app")
+	ws.Route(ws.GET("/read").Filter(Filter(pk.Public(), mustPermission("app:read"))).
+		To(func(req *restful.Request, res *restful.Response) {
+			ok.ServeHTTP(res, req.Request)
+			if _, found := req.Attribute(UserAttribute).(*User); !found {
+				t.Error("Expected user attribute")
+			}
+		}))
+	ws.Route(ws.GET("/write").Filter(Filter(pk.Public(), mustPermission("app:write"))).
+		To(func(req *restful.Request, res *restful.Response) {}))
+	ws.Route(ws.GET("/any").Filter(Filter(pk.Public())).
+		To(func(req *restful.Request, res *restful.Response) {}))
+
+	c := restful.NewContainer()
+	c.Add(ws)
+
+	mux := http.NewServeMux()
+	mux.Handle("/read", Middleware(pk.Public(), mustPermission("app:read"))(ok))
+	mux.Handle("/write", Middleware(pk.Public(), mustPermission("app:write"))(ok))
+	mux.Handle("/any", Middleware(pk.Public())(ok))
+
+	for i, v := range []struct {
+		handler http.Handler
+		path    string
+		auth    string
+		code    int
+	}{
+		{mux, "/read", "Bearer " + tok, http.StatusOK},
+		{mux, "/read", "bearer " + tok, http.StatusOK},
+		{mux, "/read", "", http.StatusUnauthorized},
+		{mux, "/read", "Bearer " + tok + "1", http.StatusUnauthorized},
+		{mux, "/write", "Bearer " + tok, http.StatusForbidden},
+		{c, "/app/read", "Bearer " + tok, http.StatusOK},
+		{c, "/app/read", "Basic abc", http.StatusUnauthorized},
+		{c, "/app/write", "Bearer " + tok, http.StatusForbidden},
+		{mux, "/any", "Bearer " + tok, http.StatusOK},
+		{mux, "/any", "Bearer " + refreshTok, http.StatusUnauthorized},
+		{mux, "/any", "Bearer " + foreignTok, http.StatusUnauthorized},
+		{c, "/app/any", "Bearer " + tok, http.StatusOK},
+		{c, "/app/any", "Bearer " + refreshTok, http.StatusUnauthorized},
+		{c, "/app/any", "Bearer " + foreignTok, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", v.path, nil)
+		if v.auth != "" {
+			req.Header.Set("Authorization", v.auth)
+		}
+		res := httptest.NewRecorder()
+
+		v.handler.ServeHTTP(res, req)
+
+		if res.Code != v.code {
+			t.Errorf("%d: Expected %d but got %d", i, v.code, res.Code)
+		}
+
+		if v.code == http.StatusUnauthorized && res.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%d: Expected WWW-Authenticate header", i)
+		}
+	}
+}
+
+func TestValidator_WithErrorHandler(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got error
+	v := NewValidator(pk.Public(), WithErrorHandler(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		got = err
+		w.WriteHeader(status)
+	}))
+
+	h := v.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected handler not to be called")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	res := httptest.NewRecorder()
+
+	h.ServeHTTP(res, req)
+
+	if res.Code != http.StatusUnauthorized || !errors.Is(got, ErrInvalidToken) {
+		t.Errorf("Expected 401 with ErrInvalidToken but got %d, %v", res.Code, got)
+	}
+}
@@ -0,0 +1,34 @@
+package gosso
+
+import (
+	"github.com/dfkdream/permission"
+	"github.com/google/uuid"
+)
+
+// RefreshPermission is the only permission held by refresh tokens
+var RefreshPermission = permission.Permission{Allow: true, Namespaces: []string{"gosso", "token", "refresh"}}
+
+// User is user information carried by GoSSO tokens
+type User struct {
+	ID          uuid.UUID               `json:"id"`
+	Username    string                  `json:"username"`
+	Permissions []permission.Permission `json:"permissions"`
+}
+
+// HasPermission reports whether user permissions allow p
+func (u User) HasPermission(p permission.Permission) bool {
+	return p.HasPermission(u.Permissions)
+}
+
+// IsRefreshToken reports whether user is payload of refresh token
+func (u User) IsRefreshToken() bool {
+	if len(u.Permissions) != 1 {
+		return false
+	}
+
+	if !u.Permissions[0].Equals(RefreshPermission) {
+		return false
+	}
+
+	return RefreshPermission.HasPermission(u.Permissions)
+}
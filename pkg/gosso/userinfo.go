@@ -0,0 +1,63 @@
+package gosso
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dfkdream/permission"
+)
+
+// UserInfoPath is path of userinfo endpoint relative to GoSSO URL
+const UserInfoPath = "/token/userinfo"
+
+// PermissionDigest returns digest of ordered permission set carried in pdg claim of compact tokens
+func PermissionDigest(perms []permission.Permission) string {
+	s := make([]string, len(perms))
+	for i, p := range perms {
+		s[i] = p.String()
+	}
+
+	h := sha256.Sum256([]byte(strings.Join(s, "\n")))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// MatchesDigest reports whether perms is permission set digested in claims
+func (c Claims) MatchesDigest(perms []permission.Permission) bool {
+	return c.PermissionDigest != "" && c.PermissionDigest == PermissionDigest(perms)
+}
+
+// FetchUserInfo fetches user with full permission set from GoSSO userinfo endpoint using access token.
+// DefaultClient is used if client is nil.
+func FetchUserInfo(client *http.Client, gossoURL, token string) (*User, error) {
+	if client == nil {
+		client = DefaultClient
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(gossoURL, "/")+UserInfoPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gosso: fetching userinfo failed: %s", res.Status)
+	}
+
+	u := new(User)
+	err = json.NewDecoder(res.Body).Decode(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
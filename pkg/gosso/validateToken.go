@@ -2,27 +2,67 @@ package gosso
 
 import (
 	"crypto"
+	"errors"
 	"fmt"
 
-	"github.com/dfkdream/GoSSO/internal/auth"
 	"github.com/dgrijalva/jwt-go"
 )
 
-func ValidateToken(token string, puk crypto.PublicKey) (*auth.User, bool, error) {
-	t, err := jwt.ParseWithClaims(token, &auth.UserClaim{}, func(token *jwt.Token) (i interface{}, err error) {
-		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+var ErrAudienceMismatch = errors.New("gosso: token audience mismatch")
+
+// keyFunc returns jwt.Keyfunc resolving puk, or key with kid header if puk is KeySource.
+// ECDSA, RSA and EdDSA signing methods are accepted.
+func keyFunc(puk crypto.PublicKey) jwt.Keyfunc {
+	return func(token *jwt.Token) (i interface{}, err error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodECDSA, *jwt.SigningMethodRSA, *SigningMethodEdDSA:
+		default:
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+
+		if ks, ok := puk.(KeySource); ok {
+			kid, _ := token.Header["kid"].(string)
+			return ks.PublicKey(kid)
+		}
 		return puk, nil
-	})
+	}
+}
+
+// ParseClaims verifies token signature and validity and returns its claims.
+// puk can be KeySource such as KeyProvider to resolve key using kid header.
+func ParseClaims(token string, puk crypto.PublicKey) (*Claims, bool, error) {
+	t, err := jwt.ParseWithClaims(token, &Claims{}, keyFunc(puk))
 
 	if err != nil {
 		return nil, false, err
 	}
 
-	if c, ok := t.Claims.(*auth.UserClaim); ok && t.Valid {
-		return &c.User, true, nil
+	if c, ok := t.Claims.(*Claims); ok && t.Valid {
+		return c, true, nil
 	}
 
 	return nil, false, nil
 }
+
+func ValidateToken(token string, puk crypto.PublicKey) (*User, bool, error) {
+	c, ok, err := ParseClaims(token, puk)
+	if !ok {
+		return nil, false, err
+	}
+
+	return &c.User, true, nil
+}
+
+// ValidateTokenForAudience validates token and rejects tokens not issued for audience
+func ValidateTokenForAudience(token string, puk crypto.PublicKey, audience string) (*User, bool, error) {
+	c, ok, err := ParseClaims(token, puk)
+	if !ok {
+		return nil, false, err
+	}
+
+	if !c.Audience.Contains(audience) {
+		return nil, false, ErrAudienceMismatch
+	}
+
+	return &c.User, true, nil
+}
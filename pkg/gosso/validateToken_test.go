@@ -0,0 +1,72 @@
+package gosso
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+func signClaims(t *testing.T, pk *ecdsa.PrivateKey, c Claims) string {
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodES256, c).SignedString(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestValidateToken(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := permission.FromString("+:gosso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usr := User{
+		ID:          uuid.New(),
+		Username:    "hello",
+		Permissions: []permission.Permission{p},
+	}
+
+	tok := signClaims(t, pk, Claims{
+		Audience:  Audience{"app"},
+		Issuer:    "gosso",
+		IssuedAt:  time.Now().Unix(),
+		NotBefore: time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		User:      usr,
+	})
+
+	u, ok, err := ValidateToken(tok, pk.Public())
+	if !ok || err != nil {
+		t.Fatalf("Expected valid token but got %v", err)
+	}
+
+	if u.ID != usr.ID || u.Username != usr.Username || !u.HasPermission(p) {
+		t.Errorf("Expected %+v but got %+v", usr, *u)
+	}
+
+	if _, ok, err := ValidateTokenForAudience(tok, pk.Public(), "other"); ok || err != ErrAudienceMismatch {
+		t.Errorf("Expected ErrAudienceMismatch but got %v", err)
+	}
+
+	expired := signClaims(t, pk, Claims{
+		IssuedAt:  time.Now().Add(-time.Hour).Unix(),
+		NotBefore: time.Now().Add(-time.Hour).Unix(),
+		ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		User:      usr,
+	})
+
+	if _, ok, _ := ValidateToken(expired, pk.Public()); ok {
+		t.Error("Expected expired token to be rejected")
+	}
+}
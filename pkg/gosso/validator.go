@@ -0,0 +1,193 @@
+package gosso
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dfkdream/permission"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/emicklei/go-restful/v3"
+)
+
+// Issuer is iss claim of tokens issued by GoSSO
+const Issuer = "gosso"
+
+var (
+	ErrInvalidToken          = errors.New("gosso: invalid token")
+	ErrTokenExpired          = errors.New("gosso: token is expired")
+	ErrTokenNotValidYet      = errors.New("gosso: token is not valid yet")
+	ErrTokenUsedBeforeIssued = errors.New("gosso: token used before issued")
+	ErrIssuerMismatch        = errors.New("gosso: token issuer mismatch")
+	ErrRefreshToken          = errors.New("gosso: refresh token not accepted")
+)
+
+// Validator validates tokens with configured requirements.
+// Errors returned by Validate are one of ErrInvalidToken, ErrTokenExpired, ErrTokenNotValidYet,
+// ErrTokenUsedBeforeIssued, ErrIssuerMismatch, ErrAudienceMismatch, ErrRefreshToken or ErrForbidden,
+// and can be compared using errors.Is.
+type Validator struct {
+	puk           crypto.PublicKey
+	issuer        string
+	audience      string
+	checkAudience bool
+	leeway        time.Duration
+	rejectRefresh bool
+	required      []permission.Permission
+	onError       ErrorHandler
+	now           func() time.Time
+}
+
+// ErrorHandler writes response of failed authentication with status and validation error
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+type Option func(*Validator)
+
+// WithIssuer requires iss claim to be issuer
+func WithIssuer(issuer string) Option {
+	return func(v *Validator) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires aud claim to be audience
+func WithAudience(audience string) Option {
+	return func(v *Validator) {
+		v.audience = audience
+		v.checkAudience = true
+	}
+}
+
+// WithLeeway allows clock skew of leeway when checking exp, nbf and iat
+func WithLeeway(leeway time.Duration) Option {
+	return func(v *Validator) {
+		v.leeway = leeway
+	}
+}
+
+// RejectRefreshTokens rejects tokens holding only refresh permission
+func RejectRefreshTokens() Option {
+	return func(v *Validator) {
+		v.rejectRefresh = true
+	}
+}
+
+// RequirePermissions requires token user to have every permission
+func RequirePermissions(perms ...permission.Permission) Option {
+	return func(v *Validator) {
+		v.required = append(v.required, perms...)
+	}
+}
+
+// WithErrorHandler replaces plain text response written by Middleware and Filter on failed authentication
+func WithErrorHandler(h ErrorHandler) Option {
+	return func(v *Validator) {
+		v.onError = h
+	}
+}
+
+// NewValidator creates Validator verifying signature with puk. puk can be KeySource such as KeyProvider.
+func NewValidator(puk crypto.PublicKey, opts ...Option) *Validator {
+	v := &Validator{
+		puk:     puk,
+		onError: writeAuthError,
+		now:     time.Now,
+	}
+
+	for _, o := range opts {
+		o(v)
+	}
+
+	return v
+}
+
+// Validate verifies token and returns its claims
+func (v *Validator) Validate(token string) (*Claims, error) {
+	c := new(Claims)
+
+	// Time based claims are checked below with leeway
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	t, err := parser.ParseWithClaims(token, c, keyFunc(v.puk))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if !t.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if err := c.validAt(v.now(), v.leeway); err != nil {
+		return nil, err
+	}
+
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	if v.checkAudience && !c.Audience.Contains(v.audience) {
+		return nil, ErrAudienceMismatch
+	}
+
+	if v.rejectRefresh && c.User.IsRefreshToken() {
+		return nil, ErrRefreshToken
+	}
+
+	for _, p := range v.required {
+		if !c.User.HasPermission(p) {
+			return nil, ErrForbidden
+		}
+	}
+
+	return c, nil
+}
+
+// Middleware returns http middleware validating bearer token.
+// Responds 403 Forbidden if permission requirement fails, otherwise 401 Unauthorized.
+func (v *Validator) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, status, err := v.authenticate(r)
+			if u == nil {
+				v.onError(w, r, status, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), u)))
+		})
+	}
+}
+
+// Filter returns go-restful filter validating bearer token
+func (v *Validator) Filter() restful.FilterFunction {
+	return func(req *restful.Request, res *restful.Response, chain *restful.FilterChain) {
+		u, status, err := v.authenticate(req.Request)
+		if u == nil {
+			v.onError(res, req.Request, status, err)
+			return
+		}
+
+		req.Request = req.Request.WithContext(ContextWithUser(req.Request.Context(), u))
+		req.SetAttribute(UserAttribute, u)
+		chain.ProcessFilter(req, res)
+	}
+}
+
+// authenticate validates bearer token. Returned status code is http.StatusOK on success.
+func (v *Validator) authenticate(r *http.Request) (*User, int, error) {
+	token, ok := BearerToken(r)
+	if !ok {
+		return nil, http.StatusUnauthorized, ErrNoToken
+	}
+
+	c, err := v.Validate(token)
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return nil, http.StatusForbidden, err
+		}
+		return nil, http.StatusUnauthorized, err
+	}
+
+	return &c.User, http.StatusOK, nil
+}
@@ -0,0 +1,76 @@
+package gosso
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dfkdream/permission"
+)
+
+func TestValidator_Validate(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	claims := func(f func(c *Claims)) Claims {
+		c := Claims{
+			Audience:  Audience{"app"},
+			Issuer:    Issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			User: User{
+				Username:    "hello",
+				Permissions: []permission.Permission{mustPermission("+:app:read")},
+			},
+		}
+		if f != nil {
+			f(&c)
+		}
+		return c
+	}
+
+	for i, v := range []struct {
+		token string
+		opts  []Option
+		err   error
+	}{
+		{signClaims(t, pk, claims(nil)), nil, nil},
+		{signClaims(t, other, claims(nil)), nil, ErrInvalidToken},
+		{"not.a.token", nil, ErrInvalidToken},
+		{signClaims(t, pk, claims(func(c *Claims) { c.ExpiresAt = now.Add(-time.Second).Unix() })), nil, ErrTokenExpired},
+		{signClaims(t, pk, claims(func(c *Claims) { c.ExpiresAt = now.Add(-time.Second).Unix() })), []Option{WithLeeway(time.Minute)}, nil},
+		{signClaims(t, pk, claims(func(c *Claims) { c.NotBefore = now.Add(30 * time.Second).Unix() })), nil, ErrTokenNotValidYet},
+		{signClaims(t, pk, claims(func(c *Claims) { c.NotBefore = now.Add(30 * time.Second).Unix() })), []Option{WithLeeway(time.Minute)}, nil},
+		{signClaims(t, pk, claims(func(c *Claims) { c.IssuedAt = now.Add(30 * time.Second).Unix() })), nil, ErrTokenUsedBeforeIssued},
+		{signClaims(t, pk, claims(nil)), []Option{WithIssuer(Issuer)}, nil},
+		{signClaims(t, pk, claims(func(c *Claims) { c.Issuer = "evil" })), []Option{WithIssuer(Issuer)}, ErrIssuerMismatch},
+		{signClaims(t, pk, claims(nil)), []Option{WithAudience("app")}, nil},
+		{signClaims(t, pk, claims(nil)), []Option{WithAudience("other")}, ErrAudienceMismatch},
+		{signClaims(t, pk, claims(func(c *Claims) { c.User.Permissions = []permission.Permission{RefreshPermission} })), nil, nil},
+		{signClaims(t, pk, claims(func(c *Claims) { c.User.Permissions = []permission.Permission{RefreshPermission} })), []Option{RejectRefreshTokens()}, ErrRefreshToken},
+		{signClaims(t, pk, claims(nil)), []Option{RequirePermissions(mustPermission("app:read"))}, nil},
+		{signClaims(t, pk, claims(nil)), []Option{RequirePermissions(mustPermission("app:read"), mustPermission("app:write"))}, ErrForbidden},
+	} {
+		_, err := NewValidator(pk.Public(), v.opts...).Validate(v.token)
+		if v.err == nil && err != nil {
+			t.Errorf("%d: Expected nil but got %v", i, err)
+		}
+
+		if v.err != nil && !errors.Is(err, v.err) {
+			t.Errorf("%d: Expected %v but got %v", i, v.err, err)
+		}
+	}
+}